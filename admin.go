@@ -0,0 +1,154 @@
+package apidCRUD
+
+// this module implements the admin APIs.  the internal tables of
+// apidCRUD, whose names start with an underscore, and of sqlite, whose
+// names start with "sqlite_", are reserved, and the public APIs refuse
+// to touch them, so that a client cannot corrupt the table of tables
+// or the database schema.  the admin APIs return the metadata in those
+// tables, read-only.
+//
+// the table of tables is authoritative: the other APIs only work on
+// the tables in it.  a table that was created outside of apidCRUD can
+// be adopted, which describes its columns in a schema, from PRAGMA
+// table_info, and puts it in the table of tables.
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// queryRegistry() returns the entries of the table of tables,
+// in order of id.
+func queryRegistry(db dbType) ([]RegistryEntry, error) {
+	ret := []RegistryEntry{}
+	qstring := fmt.Sprintf("SELECT id,name,schema FROM %s ORDER BY id",
+		tableOfTables)
+	log.Debugf("query = %s", qstring)
+	rows, err := db.runner().Query(qstring)
+	if err != nil {
+		return ret, err
+	}
+	defer rows.Close() // nolint
+	for rows.Next() {
+		var e RegistryEntry
+		err = rows.Scan(&e.Id, &e.Name, &e.Schema)
+		if err != nil {
+			return ret, err
+		}
+		ret = append(ret, e)
+	}
+	return ret, rows.Err()
+}
+
+// queryObjects() returns the objects in the sqlite schema, such as
+// tables and indexes, including the internal ones, in order of type
+// and name.
+func queryObjects(db dbType) ([]SqliteObject, error) {
+	ret := []SqliteObject{}
+	qstring := "SELECT type,name,tbl_name,coalesce(sql,'') FROM sqlite_master ORDER BY type,name" // nolint
+	log.Debugf("query = %s", qstring)
+	rows, err := db.runner().Query(qstring)
+	if err != nil {
+		return ret, err
+	}
+	defer rows.Close() // nolint
+	for rows.Next() {
+		var o SqliteObject
+		err = rows.Scan(&o.Type, &o.Name, &o.Table, &o.Sql)
+		if err != nil {
+			return ret, err
+		}
+		ret = append(ret, o)
+	}
+	return ret, rows.Err()
+}
+
+// adoptTable() puts the given table, which must exist in the database
+// but not in the table of tables, in the table of tables, with a schema
+// that describes its columns.
+func adoptTable(db dbType, tabName string) error {
+	err := checkTableRegistered(db, tabName)
+	if err == nil {
+		return statusError{http.StatusConflict,
+			fmt.Errorf("table %s is already registered", tabName)}
+	}
+	if dbErrorStatus(err) != http.StatusNotFound {
+		return err
+	}
+	var n int
+	err = db.runner().QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?", // nolint
+		tabName).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return statusError{http.StatusNotFound,
+			fmt.Errorf("no such table %s in the database", tabName)}
+	}
+	cols, err := readColumnInfo(db, tabName)
+	if err != nil {
+		return err
+	}
+	jschema, err := schemaText(adoptSchema(cols))
+	if err != nil {
+		return err
+	}
+	defer invalidateTable(tabName)
+	return execN(db, newXCmd(fmt.Sprintf(
+		"insert into %s (name, schema) values (?, ?)", tableOfTables),
+		tabName, jschema))
+}
+
+// adoptSchema() returns the schema that describes the given columns.
+// each field has the column's type, if it is one of the db_type
+// values, or else the type of its affinity.  a single integer primary
+// key is auto-incremented, as it is the rowid.  a default value is
+// kept if it is a literal.  indexes and other constraints are not
+// described.
+func adoptSchema(cols []columnInfo) TableSchema {
+	npk := 0
+	for _, ci := range cols {
+		if ci.pk > 0 {
+			npk++
+		}
+	}
+	sch := TableSchema{Fields: []FieldSchema{}}
+	for _, ci := range cols {
+		field := FieldSchema{Name: ci.name}
+		dtype := strings.ToLower(ci.dtype)
+		if _, ok := dbTypes[dtype]; !ok {
+			dtype = typeAffinity(ci.dtype)
+		}
+		if _, ok := dbTypes[dtype]; ok {
+			field.DbType = dtype
+		}
+		if ci.pk > 0 {
+			field.IsPrimaryKey = boolPtr(true)
+			if npk == 1 && dtype == "integer" {
+				field.AutoIncrement = boolPtr(true)
+			}
+		} else if !ci.notNull {
+			field.AllowNull = boolPtr(true)
+		}
+		if ci.dflt.Valid {
+			field.Default = literalValue(ci.dflt.String)
+		}
+		sch.Fields = append(sch.Fields, field)
+	}
+	return sch
+}
+
+// literalValue() returns the value of the given SQL literal, as it
+// would be decoded from JSON, or nil if it is not a number or a string.
+func literalValue(lit string) interface{} {
+	if len(lit) >= 2 && lit[0] == '\'' && lit[len(lit)-1] == '\'' {
+		return strings.Replace(lit[1:len(lit)-1], "''", "'", -1)
+	}
+	f, err := strconv.ParseFloat(lit, 64)
+	if err != nil {
+		return nil
+	}
+	return f
+}
@@ -0,0 +1,172 @@
+package apidCRUD
+
+import (
+	"net/http"
+	"testing"
+)
+
+// ----- unit tests for reserved tables and the admin APIs
+
+// the reserved tables are refused by the public APIs.
+var reservedTables_Tab = []apiCall_TC {
+	{"get records of the table of tables",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`/test/db/_table/_tables_|table_name=_tables_`,
+		http.StatusForbidden, noCheck},
+	{"update records of the table of tables",
+		updateDbRecordsHandler,
+		http.MethodPatch,
+		`/test/db/_table/_tables_|table_name=_tables_|id=1|{"records":[{"keys":["name"],"values":["x"]}]}`,
+		http.StatusForbidden, noCheck},
+	{"delete the table of tables",
+		deleteDbTableHandler,
+		http.MethodDelete,
+		`/test/db/_schema/_tables_|table_name=_tables_`,
+		http.StatusForbidden, noCheck},
+	{"get records of sqlite_master",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`/test/db/_table/sqlite_master|table_name=sqlite_master`,
+		http.StatusForbidden, noCheck},
+	{"create a reserved table",
+		createDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/_mine|table_name=_mine||{"fields":[{"name":"id","properties":["is_primary_key"]}]}`,
+		http.StatusForbidden, noCheck},
+	{"create a table referencing the table of tables",
+		createDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/REF|table_name=REF||{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"t","type":"integer","references":{"table":"_tables_","field":"id"}}]}`,
+		http.StatusBadRequest, noCheck},
+	{"get audit of the table of tables",
+		getDbAuditHandler,
+		http.MethodGet,
+		`/test/db/_audit||table=_tables_`,
+		http.StatusForbidden, noCheck},
+	{"create hook on the table of tables",
+		createDbHookHandler,
+		http.MethodPost,
+		`/test/db/_hooks|||{"table":"_tables_","url":"http://localhost/","secret":"s"}`,
+		http.StatusForbidden, noCheck},
+}
+
+func Test_reservedTables(t *testing.T) {
+	apiCalls_Runner(t, "reservedTables_Tab", reservedTables_Tab)
+}
+
+func Test_getDbAdminRegistryHandler(t *testing.T) {
+	cx := newTestContext(t)
+	res := callApiHandler(getDbAdminRegistryHandler, http.MethodGet,
+		`/test/db/_admin/registry`)
+	cx.assertEqual(http.StatusOK, res.code, "response code")
+	resp, ok := res.data.(RegistryResponse)
+	if !cx.assertTrue(ok, "response data type") {
+		return
+	}
+	names := []string{}
+	for _, e := range resp.Entries {
+		names = append(names, e.Name)
+		cx.assertTrue(e.Schema != "", "schema of " + e.Name)
+	}
+	cx.assertTrue(inList(names, "bundles"), "bundles in registry")
+}
+
+func Test_getDbAdminObjectsHandler(t *testing.T) {
+	cx := newTestContext(t)
+	res := callApiHandler(getDbAdminObjectsHandler, http.MethodGet,
+		`/test/db/_admin/objects`)
+	cx.assertEqual(http.StatusOK, res.code, "response code")
+	resp, ok := res.data.(ObjectsResponse)
+	if !cx.assertTrue(ok, "response data type") {
+		return
+	}
+	tables := []string{}
+	for _, o := range resp.Objects {
+		if o.Type == "table" {
+			tables = append(tables, o.Name)
+		}
+	}
+	cx.assertTrue(inList(tables, tableOfTables), "table of tables")
+	cx.assertTrue(inList(tables, "bundles"), "bundles")
+}
+
+// inList() returns true if the given string is in the given list.
+func inList(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// a table created outside of apidCRUD can be used once it is adopted.
+func Test_adoptDbTableHandler(t *testing.T) {
+	cx := newTestContext(t)
+	_, err := db.handle.Exec(`create table ADOPT(id integer primary key, name text not null, n real default 2, note varchar(10) default 'it''s')`)
+	if !cx.assertErrorNil(err, "create table ADOPT") {
+		return
+	}
+	defer callApiHandler(deleteDbTableHandler, http.MethodDelete,
+		`/test/db/_schema/ADOPT|table_name=ADOPT`)
+
+	tab := []apiCall_TC {
+		{"get records of unregistered table",
+			getDbRecordsHandler,
+			http.MethodGet,
+			`/test/db/_table/ADOPT|table_name=ADOPT`,
+			http.StatusNotFound, noCheck},
+		{"adopt table",
+			adoptDbTableHandler,
+			http.MethodPost,
+			`/test/db/_admin/adopt/ADOPT|table_name=ADOPT`,
+			http.StatusCreated,
+			`{"schema":{"fields":[{"name":"id","db_type":"integer","auto_increment":true,"is_primary_key":true},{"name":"name","db_type":"text"},{"name":"n","db_type":"real","allow_null":true,"default":2},{"name":"note","db_type":"text","allow_null":true,"default":"it's"}]},"columns":[{"name":"id","type":"INTEGER","allow_null":false,"is_primary_key":true},{"name":"name","type":"TEXT","allow_null":false,"is_primary_key":false},{"name":"n","type":"REAL","allow_null":true,"is_primary_key":false,"default":"2"},{"name":"note","type":"varchar(10)","allow_null":true,"is_primary_key":false,"default":"'it''s'"}],"indexes":[],"rowCount":0,"kind":"SchemaResponse","self":"/test/db/_admin/adopt/ADOPT?"}`},
+		{"adopt registered table",
+			adoptDbTableHandler,
+			http.MethodPost,
+			`/test/db/_admin/adopt/ADOPT|table_name=ADOPT`,
+			http.StatusConflict, noCheck},
+		{"adopt missing table",
+			adoptDbTableHandler,
+			http.MethodPost,
+			`/test/db/_admin/adopt/NOSUCH|table_name=NOSUCH`,
+			http.StatusNotFound, noCheck},
+		{"create record in adopted table",
+			createDbRecordsHandler,
+			http.MethodPost,
+			`/test/db/_table/ADOPT|table_name=ADOPT||{"records":[{"keys":["name"],"values":["a"]}]}`,
+			http.StatusCreated, noCheck},
+		{"create record w/ unknown key",
+			createDbRecordsHandler,
+			http.MethodPost,
+			`/test/db/_table/ADOPT|table_name=ADOPT||{"records":[{"keys":["bogus"],"values":["a"]}]}`,
+			http.StatusBadRequest,
+			`{"code":400,"message":"record 0: keys: unknown field bogus (valid fields: id,name,n,note)","kind":"ErrorResponse"}`},
+		{"get records w/ unknown field",
+			getDbRecordsHandler,
+			http.MethodGet,
+			`/test/db/_table/ADOPT|table_name=ADOPT|fields=name,bogus`,
+			http.StatusBadRequest,
+			`{"code":400,"message":"fields: unknown field bogus (valid fields: id,name,n,note)","kind":"ErrorResponse"}`},
+		{"get records of adopted table",
+			getDbRecordsHandler,
+			http.MethodGet,
+			`/test/db/_table/ADOPT|table_name=ADOPT|fields=name,n,note`,
+			http.StatusOK, noCheck},
+	}
+	for _, tc := range tab {
+		apiCall_Checker(cx, &tc)
+		cx.bump()
+	}
+}
+
+// ----- unit tests for literalValue()
+
+func Test_literalValue(t *testing.T) {
+	cx := newTestContext(t)
+	cx.assertEqualObj("it's", literalValue("'it''s'"), "string")
+	cx.assertEqualObj(float64(-1.5), literalValue("-1.5"), "number")
+	cx.assertEqualObj(nil, literalValue("CURRENT_TIMESTAMP"), "expression")
+}
@@ -0,0 +1,226 @@
+package apidCRUD
+
+// this module implements aggregation queries.  the aggregate parameter
+// is a list of aggregate functions of fields, such as
+// count(*),sum(size),max(created), which are computed over the records
+// selected by the filter parameter, for each group of records with the
+// same values of the fields in the group_by parameter, if any.
+// like getDbRecords, the groups are paged by limit and offset.
+
+import (
+	"fmt"
+	"strings"
+)
+
+// aggregateFuncs are the aggregate functions that may be used.
+var aggregateFuncs = map[string]int {
+	"count": 1,
+	"sum": 1,
+	"min": 1,
+	"max": 1,
+	"avg": 1,
+}
+
+// aggregateItem is one aggregate function of an "aggregate" parameter.
+// the field of count(*) is "*".
+type aggregateItem struct {
+	fn string
+	field string
+}
+
+// String() returns the normalized form of an aggregate function,
+// which is the name of its column in the result.
+func (ai aggregateItem) String() string {
+	return ai.fn + "(" + ai.field + ")"
+}
+
+// parseAggregates() breaks up an aggregate string into its functions.
+func parseAggregates(s string) ([]aggregateItem, error) {
+	ret := []aggregateItem{}
+	if strings.TrimSpace(s) == "" {
+		return ret, fmt.Errorf("aggregate is required")
+	}
+	for _, item := range strings.Split(s, ",") {
+		str := strings.TrimSpace(item)
+		open := strings.Index(str, "(")
+		if open < 0 || !strings.HasSuffix(str, ")") {
+			return ret, fmt.Errorf("invalid aggregate \"%s\"", item)
+		}
+		ai := aggregateItem{
+			fn: strings.ToLower(strings.TrimSpace(str[:open])),
+			field: strings.TrimSpace(str[open+1 : len(str)-1]),
+		}
+		if aggregateFuncs[ai.fn] == 0 {
+			return ret, fmt.Errorf("invalid aggregate function %s", ai.fn)
+		}
+		if !isValidIdent(ai.field) &&
+				!(ai.fn == "count" && ai.field == "*") {
+			return ret, fmt.Errorf("invalid aggregate field \"%s\"",
+				ai.field)
+		}
+		ret = append(ret, ai)
+	}
+	return ret, nil
+}
+
+// parseGroupBy() breaks up a group_by string into its field names.
+func parseGroupBy(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+// valueType() returns the type of the values of a field with the
+// given declared type: its affinity, or datetime for a time field.
+func valueType(dtype string) string {
+	if timeTypes[strings.ToLower(dtype)] {
+		return "datetime"
+	}
+	return typeAffinity(dtype)
+}
+
+// aggregateType() returns the type of the values of the given
+// aggregate function, given the declared type of its field.
+func aggregateType(ai aggregateItem, dtype string) string {
+	switch ai.fn {
+	case "count":
+		return "integer"
+	case "avg":
+		return "real"
+	case "sum":
+		switch typeAffinity(dtype) {
+		case "integer", "boolean":
+			return "integer"
+		}
+		return "real"
+	}
+	return valueType(dtype)
+}
+
+// aggregateColumns() returns the columns of the result of the query
+// given by the aggregate and group_by parameters: the group_by fields,
+// then the aggregate functions.  the fields are checked against the
+// columns of the table.
+func aggregateColumns(db dbType,
+	params map[string]string) ([]AggregateColumn, error) {
+	items, err := parseAggregates(params["aggregate"])
+	if err != nil {
+		return nil, err
+	}
+	cols, err := tableColumnInfo(db, params["table_name"])
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	dtypes := map[string]string{}
+	for _, ci := range cols {
+		names = append(names, ci.name)
+		dtypes[ci.name] = ci.dtype
+	}
+	ret := []AggregateColumn{}
+	for _, f := range parseGroupBy(params["group_by"]) {
+		dtype, ok := dtypes[f]
+		if !ok {
+			return nil, unknownFieldError("group_by", f, names)
+		}
+		ret = append(ret, AggregateColumn{Name: f,
+			Type: valueType(dtype)})
+	}
+	for _, ai := range items {
+		dtype, ok := dtypes[ai.field]
+		if !ok && ai.field != "*" {
+			return nil, unknownFieldError("aggregate", ai.field,
+				names)
+		}
+		ret = append(ret, AggregateColumn{Name: ai.String(),
+			Type: aggregateType(ai, dtype)})
+	}
+	return ret, nil
+}
+
+// mkAggregateString() returns the aggregation query given by the
+// aggregate, group_by, filter, limit, and offset parameters, and the
+// values to be bound to it.  the groups are in order of the group_by
+// fields.
+func mkAggregateString(params map[string]string) (string, []interface{}, error) {
+	items, err := parseAggregates(params["aggregate"])
+	if err != nil {
+		return "", nil, err
+	}
+	where, args, err := mkWhereClause(params)
+	if err != nil {
+		return "", nil, err
+	}
+	groups := parseGroupBy(params["group_by"])
+	exprs := []string{}
+	if len(groups) > 0 {
+		exprs = append(exprs, quoteIdents(groups))
+	}
+	for _, ai := range items {
+		arg := "*"
+		if ai.field != "*" {
+			arg = quoteIdent(ai.field)
+		}
+		exprs = append(exprs, ai.fn + "(" + arg + ")")
+	}
+	q := newSQL("SELECT " + strings.Join(exprs, ",")).
+		sql(" FROM ").ident(params["table_name"]).
+		clause(where, args)
+	if len(groups) > 0 {
+		q.sql(" GROUP BY ").idents(groups).
+			sql(" ORDER BY ").idents(groups)
+	}
+	q.sql(" LIMIT ").value(aToIdType(params["limit"])).
+		sql(" OFFSET ").value(aToIdType(params["offset"]))
+	return q.String(), q.args, nil
+}
+
+// runAggregate() runs the aggregation query given by the parameters,
+// and returns its result, with the values converted to the types
+// of the columns.  one more row than the limit is asked for, so that
+// more is true if there are groups after the returned ones.
+func runAggregate(db dbType,
+	params map[string]string) (ret AggregateResponse, more bool, err error) {
+	ret = AggregateResponse{Columns: []AggregateColumn{},
+		Rows: [][]interface{}{}, Kind: "AggregateResponse",
+		Limit: aToIdType(params["limit"]),
+		Offset: aToIdType(params["offset"])}
+	cols, err := aggregateColumns(db, params)
+	if err != nil {
+		return ret, false, err
+	}
+	qparams := copyParams(params)
+	qparams["limit"] = idTypeToA(ret.Limit + 1)
+	qstring, args, err := mkAggregateString(qparams)
+	if err != nil {
+		return ret, false, err
+	}
+	log.Debugf("query = %s", qstring)
+	rows, err := db.runner().Query(qstring, args...)
+	if err != nil {
+		return ret, false, err
+	}
+	defer rows.Close() // nolint
+	types := make([]string, len(cols))
+	for i, col := range cols {
+		types[i] = col.Type
+	}
+	for rows.Next() {
+		vals := mkSQLRow(len(cols))
+		err = rows.Scan(vals...)
+		if err == nil {
+			err = convValues(vals, types)
+		}
+		if err != nil {
+			return ret, false, err
+		}
+		ret.Rows = append(ret.Rows, vals)
+	}
+	if int64(len(ret.Rows)) > ret.Limit {
+		ret.Rows = ret.Rows[:ret.Limit]
+		more = true
+	}
+	ret.Columns = cols
+	return ret, more, rows.Err()
+}
@@ -0,0 +1,120 @@
+package apidCRUD
+
+import (
+	"net/http"
+	"testing"
+)
+
+// ----- unit tests for aggregation queries
+
+// the aggregation test suite.
+func Test_aggregateDbRecords(t *testing.T) {
+	cx := newTestContext(t)
+	setup := []apiCall_TC {
+		{"setup: create table AGG",
+			createDbTableHandler,
+			http.MethodPost,
+			`/test/db/_schema/AGG|table_name=AGG||{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"host"},{"name":"size","db_type":"integer"},{"name":"price","db_type":"real"},{"name":"flag","db_type":"boolean","default":false},{"name":"made","db_type":"datetime","allow_null":true}],"soft_delete":true}`,
+			http.StatusCreated, noCheck},
+		{"setup: create records",
+			createDbRecordsHandler,
+			http.MethodPost,
+			`/test/db/_table/AGG|table_name=AGG||{"records":[{"keys":["host","size","price","made"],"values":["a",1,1.5,"2020-01-02T03:04:05Z"]},{"keys":["host","size","price","flag"],"values":["b",2,2.5,true]},{"keys":["host","size","price","made"],"values":["a",3,3,"2021-06-07 08:09:10.500"]},{"keys":["host","size","price"],"values":["c",10,0]}]}`,
+			http.StatusCreated, noCheck},
+		{"setup: delete record",
+			deleteDbRecordHandler,
+			http.MethodDelete,
+			`/test/db/_table/AGG|table_name=AGG&id=4`,
+			http.StatusOK, noCheck},
+	}
+	for _, tc := range setup {
+		apiCall_Checker(cx, &tc)
+	}
+	defer callApiHandler(deleteDbTableHandler, http.MethodDelete,
+		`/test/db/_schema/AGG|table_name=AGG`)
+
+	tab := []apiCall_TC {
+		{"aggregate w/o group_by",
+			aggregateDbRecordsHandler,
+			http.MethodGet,
+			`/test/db/_table/AGG/_aggregate|table_name=AGG|aggregate=count(*),sum(size),avg(price),min(host),max(size)`,
+			http.StatusOK,
+			`{"columns":[{"name":"count(*)","type":"integer"},{"name":"sum(size)","type":"integer"},{"name":"avg(price)","type":"real"},{"name":"min(host)","type":"text"},{"name":"max(size)","type":"integer"}],"rows":[[3,6,2.3333333333333335,"a",3]],"kind":"AggregateResponse","limit":7,"offset":0}`},
+		{"aggregate w/ group_by",
+			aggregateDbRecordsHandler,
+			http.MethodGet,
+			`/test/db/_table/AGG/_aggregate|table_name=AGG|aggregate=count(*),sum(price)&group_by=host`,
+			http.StatusOK,
+			`{"columns":[{"name":"host","type":"text"},{"name":"count(*)","type":"integer"},{"name":"sum(price)","type":"real"}],"rows":[["a",2,4.5],["b",1,2.5]],"kind":"AggregateResponse","limit":7,"offset":0}`},
+		{"aggregate w/ group_by boolean and filter",
+			aggregateDbRecordsHandler,
+			http.MethodGet,
+			`/test/db/_table/AGG/_aggregate|table_name=AGG|aggregate=count(id)&group_by=flag&filter=size < 3`,
+			http.StatusOK,
+			`{"columns":[{"name":"flag","type":"boolean"},{"name":"count(id)","type":"integer"}],"rows":[[false,1],[true,1]],"kind":"AggregateResponse","limit":7,"offset":0}`},
+		{"aggregate w/ include_deleted, limit, offset",
+			aggregateDbRecordsHandler,
+			http.MethodGet,
+			`/test/db/_table/AGG/_aggregate|table_name=AGG|aggregate=max(size)&group_by=host&include_deleted=true&limit=1&offset=2`,
+			http.StatusOK,
+			`{"columns":[{"name":"host","type":"text"},{"name":"max(size)","type":"integer"}],"rows":[["c",10]],"kind":"AggregateResponse","limit":1,"offset":2}`},
+		{"aggregate w/ more groups than limit",
+			aggregateDbRecordsHandler,
+			http.MethodGet,
+			`/test/db/_table/AGG/_aggregate|table_name=AGG|aggregate=count(*)&group_by=host&limit=1`,
+			http.StatusOK,
+			`{"columns":[{"name":"host","type":"text"},{"name":"count(*)","type":"integer"}],"rows":[["a",2]],"kind":"AggregateResponse","limit":1,"offset":0,"next":"://` + basePath + `/db/_table/AGG/_aggregate?aggregate=count%28%2A%29\u0026group_by=host\u0026limit=1\u0026offset=1"}`},
+		{"aggregate of datetime field",
+			aggregateDbRecordsHandler,
+			http.MethodGet,
+			`/test/db/_table/AGG/_aggregate|table_name=AGG|aggregate=min(made),max(made)&group_by=made&filter=host = 'a'`,
+			http.StatusOK,
+			`{"columns":[{"name":"made","type":"datetime"},{"name":"min(made)","type":"datetime"},{"name":"max(made)","type":"datetime"}],"rows":[["2020-01-02T03:04:05Z","2020-01-02T03:04:05Z","2020-01-02T03:04:05Z"],["2021-06-07T08:09:10.5Z","2021-06-07T08:09:10.5Z","2021-06-07T08:09:10.5Z"]],"kind":"AggregateResponse","limit":7,"offset":0}`},
+		{"aggregate of no records",
+			aggregateDbRecordsHandler,
+			http.MethodGet,
+			`/test/db/_table/AGG/_aggregate|table_name=AGG|aggregate=count(*),sum(size)&filter=host = 'zzz'`,
+			http.StatusOK,
+			`{"columns":[{"name":"count(*)","type":"integer"},{"name":"sum(size)","type":"integer"}],"rows":[[0,null]],"kind":"AggregateResponse","limit":7,"offset":0}`},
+		{"aggregate w/o aggregate",
+			aggregateDbRecordsHandler,
+			http.MethodGet,
+			`/test/db/_table/AGG/_aggregate|table_name=AGG|group_by=host`,
+			http.StatusBadRequest, noCheck},
+		{"aggregate of unknown field",
+			aggregateDbRecordsHandler,
+			http.MethodGet,
+			`/test/db/_table/AGG/_aggregate|table_name=AGG|aggregate=sum(bogus)`,
+			http.StatusBadRequest, noCheck},
+		{"aggregate w/ unknown group_by field",
+			aggregateDbRecordsHandler,
+			http.MethodGet,
+			`/test/db/_table/AGG/_aggregate|table_name=AGG|aggregate=count(*)&group_by=bogus`,
+			http.StatusBadRequest, noCheck},
+		{"aggregate w/ unknown filter field",
+			aggregateDbRecordsHandler,
+			http.MethodGet,
+			`/test/db/_table/AGG/_aggregate|table_name=AGG|aggregate=count(*)&filter=bogus = 1`,
+			http.StatusBadRequest, noCheck},
+		{"aggregate of missing table",
+			aggregateDbRecordsHandler,
+			http.MethodGet,
+			`/test/db/_table/NOSUCH/_aggregate|table_name=NOSUCH|aggregate=count(*)`,
+			http.StatusNotFound, noCheck},
+	}
+	apiCalls_Runner(t, "aggregateDbRecords_Tab", tab)
+}
+
+// ----- unit tests for mkAggregateString()
+
+func Test_mkAggregateString(t *testing.T) {
+	cx := newTestContext(t)
+	params := fakeParams("table_name=T&aggregate=count(*),avg(b)&group_by=a,c&filter=b > 1&limit=5&offset=0")
+	qstring, args, err := mkAggregateString(params)
+	if cx.assertErrorNil(err, "mkAggregateString") {
+		cx.assertEqual("SELECT `a`,`c`,count(*),avg(`b`) FROM `T` WHERE `b` > ? GROUP BY `a`,`c` ORDER BY `a`,`c` LIMIT ? OFFSET ?",
+			qstring, "query")
+		cx.assertEqualObj([]interface{}{int64(1), int64(5), int64(0)},
+			args, "args")
+	}
+}
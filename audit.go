@@ -0,0 +1,223 @@
+package apidCRUD
+
+// this module implements the audit log.  for a table whose schema has
+// audit set, each change that the record APIs make to a record is
+// recorded in the audit table, in the same transaction as the change,
+// with the values of the record before and after, the time, and the
+// identity of the caller.  the changes are found by recordChange(),
+// in changes.go.
+//
+// the caller is identified by the remote address of the request.
+// the caller that the client claims to be, in the X-Apid-Caller
+// header, is recorded too, but it is not verified, so it can only be
+// trusted as far as the clients that can reach the API are trusted.
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// callerHeader is the request header in which a client may say who
+// it is, for the audit log.  it is not verified.
+const callerHeader = "X-Apid-Caller"
+
+// auditCmds are the commands that create the audit table, if it
+// does not exist yet.  %[1]s is the name of the table.
+var auditCmds = []string {
+	`CREATE TABLE IF NOT EXISTS %[1]s (id integer not null primary key autoincrement, ts text not null, op text not null, table_name text not null, record_id integer not null, before text, after text, caller text, claimed_caller text)`,
+	`CREATE INDEX IF NOT EXISTS %[1]s_record ON %[1]s (table_name, record_id)`,
+	`CREATE INDEX IF NOT EXISTS %[1]s_ts ON %[1]s (ts)`,
+}
+
+// setCaller() sets the caller and claimed_caller entries of params
+// to the identity of the caller of the given request, for the audit
+// log: its remote address, and the unverified callerHeader.
+func setCaller(harg *apiHandlerArg, params map[string]string) {
+	params["caller"] = harg.req.RemoteAddr
+	params["claimed_caller"] = harg.req.Header.Get(callerHeader)
+}
+
+// ensureAuditTable() creates the audit table if it does not exist.
+func ensureAuditTable(db dbType) error {
+	for _, cmd := range auditCmds {
+		_, err := db.runner().Exec(fmt.Sprintf(cmd, auditTable))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordValuesChunk is the most ids whose values recordValues()
+// reads in one query, to stay below sqlite's limit on bound values.
+var recordValuesChunk = 500
+
+// recordValues() returns the current values of the records with the
+// given ids, each as a json object, as a map from id to object.
+// records that do not exist are not in the map.  soft-deleted
+// records are included.  unlike runQuery(), it is not limited
+// to maxRecs records, so that every change is recorded.
+// if withValues is false, only the ids are read, and each object
+// in the map is empty.
+func recordValues(db dbType,
+	params map[string]string,
+	ids []int64,
+	withValues bool) (map[int64]string, error) {
+	ret := map[int64]string{}
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > recordValuesChunk {
+			n = recordValuesChunk
+		}
+		err := readRecordValues(db, params, ids[:n], withValues, ret)
+		if err != nil {
+			return ret, err
+		}
+		ids = ids[n:]
+	}
+	return ret, nil
+}
+
+// readRecordValues() reads the values of the records with the given
+// ids into ret, as recordValues() returns them.
+func readRecordValues(db dbType,
+	params map[string]string,
+	ids []int64,
+	withValues bool,
+	ret map[int64]string) error {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	idfield := idFieldName(params)
+	q := newSQL("SELECT ").ident(idfield)
+	if withValues {
+		q.sql(",*")
+	}
+	q.sql(" FROM ").ident(params["table_name"]).
+		sql(" WHERE ").ident(idfield).
+		sql(" in (").values(args).sql(")")
+	log.Debugf("query = %s", q)
+	rows, err := db.runner().Query(q.String(), q.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint
+	if !withValues {
+		for rows.Next() {
+			var id int64
+			if err = rows.Scan(&id); err != nil {
+				return err
+			}
+			ret[id] = ""
+		}
+		return rows.Err()
+	}
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	types, err := columnTypes(rows)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		rec, err := queryRow("", rows, cols, types)
+		if err != nil {
+			return err
+		}
+		obj := map[string]interface{}{}
+		for i, k := range rec.Keys {
+			obj[k] = rec.Values[i]
+		}
+		data, err := json.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		ret[rec.id] = string(data)
+	}
+	return rows.Err()
+}
+
+// writeAudit() records a change to the record with the given id
+// in the audit table.  before and after are the values of the record,
+// as returned by auditValue().
+func writeAudit(db dbType,
+	params map[string]string,
+	op string,
+	id int64,
+	ts string,
+	before interface{},
+	after interface{}) error {
+	_, err := runExec(db, fmt.Sprintf(
+		"INSERT INTO %s (ts,op,table_name,record_id,before,after,caller,claimed_caller) VALUES (?,?,?,?,?,?,?,?)",
+		auditTable),
+		[]interface{}{ts, op, params["table_name"], id, before, after,
+			params["caller"], params["claimed_caller"]})
+	return err
+}
+
+// auditValue() returns the value to store in the audit table for the
+// values of a record, NULL if the record does not exist.
+func auditValue(obj string, exists bool) interface{} {
+	if !exists {
+		return nil
+	}
+	return obj
+}
+
+// queryAudit() returns the entries of the audit log selected by the
+// table, id, start_time, and end_time parameters, in the order in
+// which they were recorded, with the limit and offset parameters.
+func queryAudit(db dbType, params map[string]string) ([]AuditEntry, error) {
+	ret := []AuditEntry{}
+	where := ""
+	args := []interface{}{}
+	if params["table"] != "" {
+		where = andWhere(where, "table_name = ?")
+		args = append(args, params["table"])
+	}
+	if id, ok := params["id"]; ok {
+		where = andWhere(where, "record_id = ?")
+		args = append(args, aToIdType(id))
+	}
+	if params["start_time"] != "" {
+		where = andWhere(where, "ts >= ?")
+		args = append(args, params["start_time"])
+	}
+	if params["end_time"] != "" {
+		where = andWhere(where, "ts < ?")
+		args = append(args, params["end_time"])
+	}
+	limit, _ := strconv.ParseInt(params["limit"], idTypeRadix, idTypeBits)
+	offset, _ := strconv.ParseInt(params["offset"], idTypeRadix, idTypeBits)
+	qstring := fmt.Sprintf("SELECT id,ts,op,table_name,record_id,before,after,caller,claimed_caller FROM %s %s ORDER BY id LIMIT ? OFFSET ?", // nolint
+		auditTable, where)
+	log.Debugf("query = %s", qstring)
+	rows, err := db.runner().Query(qstring, append(args, limit, offset)...)
+	if err != nil {
+		return ret, err
+	}
+	defer rows.Close() // nolint
+	for rows.Next() {
+		var e AuditEntry
+		var before, after, caller, claimed sql.NullString
+		err = rows.Scan(&e.Id, &e.Time, &e.Op, &e.Table, &e.RecordId,
+			&before, &after, &caller, &claimed)
+		if err != nil {
+			return ret, err
+		}
+		if before.Valid {
+			e.Before = json.RawMessage(before.String)
+		}
+		if after.Valid {
+			e.After = json.RawMessage(after.String)
+		}
+		e.Caller = caller.String
+		e.ClaimedCaller = claimed.String
+		ret = append(ret, e)
+	}
+	return ret, rows.Err()
+}
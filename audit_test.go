@@ -0,0 +1,154 @@
+package apidCRUD
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// ----- unit tests for the audit log
+
+// auditOps() returns the operations of the given audit log response,
+// and the claimed caller of each.
+func auditOps(cx *testContext, res apiHandlerRet) ([]string, []string) {
+	ops, callers := []string{}, []string{}
+	resp, ok := res.data.(AuditResponse)
+	if !cx.assertTrue(ok, "audit response data type") {
+		return ops, callers
+	}
+	for _, e := range resp.Entries {
+		ops = append(ops, e.Op)
+		callers = append(callers, e.ClaimedCaller)
+	}
+	return ops, callers
+}
+
+// the audit log test suite.
+func Test_audit(t *testing.T) {
+	cx := newTestContext(t)
+	setup := []apiCall_TC {
+		{"setup: create table AUD",
+			createDbTableHandler,
+			http.MethodPost,
+			`/test/db/_schema/AUD|table_name=AUD||{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name","unique":true}],"audit":true,"soft_delete":true}`,
+			http.StatusCreated, noCheck},
+	}
+	for _, tc := range setup {
+		apiCall_Checker(cx, &tc)
+	}
+	defer callApiHandler(deleteDbTableHandler, http.MethodDelete,
+		`/test/db/_schema/AUD|table_name=AUD`)
+	start := dbTime(time.Now().Add(-time.Second))
+
+	calls := []struct {
+		hf apiHandler
+		verb string
+		desc string
+		xcode int
+	}{
+		{createDbRecordsHandler, http.MethodPost,
+			`/test/db/_table/AUD|table_name=AUD||{"records":[{"keys":["name"],"values":["a"]},{"keys":["name"],"values":["b"]}]}`,
+			http.StatusCreated},
+		{createDbRecordsHandler, http.MethodPost,
+			`/test/db/_table/AUD|table_name=AUD||{"records":[{"keys":["name"],"values":["c"]},{"keys":["name"],"values":["a"]}]}`,
+			http.StatusConflict},
+		{createDbRecordsHandler, http.MethodPost,
+			`/test/db/_table/AUD|table_name=AUD|on_conflict=update&conflict_target=name|{"records":[{"keys":["name"],"values":["a"]}]}`,
+			http.StatusCreated},
+		{updateDbRecordHandler, http.MethodPatch,
+			`/test/db/_table/AUD|table_name=AUD&id=1||{"records":[{"keys":["name"],"values":["aa"]}]}`,
+			http.StatusOK},
+		{updateDbRecordHandler, http.MethodPatch,
+			`/test/db/_table/AUD|table_name=AUD&id=1||{"records":[{"keys":["name"],"values":["aa"]}]}`,
+			http.StatusOK},
+		{replaceDbRecordHandler, http.MethodPut,
+			`/test/db/_table/AUD|table_name=AUD&id=1||{"records":[{"keys":["name"],"values":["a1"]}]}`,
+			http.StatusOK},
+		{deleteDbRecordHandler, http.MethodDelete,
+			`/test/db/_table/AUD|table_name=AUD&id=1`,
+			http.StatusOK},
+		{restoreDbRecordHandler, http.MethodPost,
+			`/test/db/_table/AUD|table_name=AUD&id=1`,
+			http.StatusOK},
+		{deleteDbRecordHandler, http.MethodDelete,
+			`/test/db/_table/AUD|table_name=AUD&id=1`,
+			http.StatusOK},
+		{purgeDbRecordsHandler, http.MethodDelete,
+			`/test/db/_table/AUD|table_name=AUD`,
+			http.StatusOK},
+	}
+	for _, c := range calls {
+		res, _ := callWithHeader(c.hf, c.verb, c.desc,
+			callerHeader, "tester")
+		cx.assertEqual(c.xcode, res.code, c.desc)
+	}
+
+	res := callApiHandler(getDbRecordHistoryHandler, http.MethodGet,
+		`/test/db/_table/AUD|table_name=AUD&id=1`)
+	cx.assertEqual(http.StatusOK, res.code, "history status")
+	ops, callers := auditOps(cx, res)
+	// the upsert and the second update did not change the record.
+	cx.assertEqualObj([]string{"insert", "update", "replace",
+		"delete", "restore", "delete", "purge"}, ops, "history ops")
+	cx.assertEqual("tester", callers[0], "history claimed caller")
+
+	resp, _ := res.data.(AuditResponse)
+	if cx.assertEqual(7, len(resp.Entries), "history length") {
+		cx.assertEqual("", string(resp.Entries[0].Before),
+			"before of insert")
+		cx.assertEqual("", string(resp.Entries[6].After),
+			"after of purge")
+		after := map[string]interface{}{}
+		err := json.Unmarshal(resp.Entries[1].After, &after)
+		cx.assertErrorNil(err, "unmarshal after")
+		cx.assertEqual("aa", after["name"], "after of update")
+		cx.assertEqual(nil, after["deleted_at"], "deleted_at of update")
+	}
+
+	// the failed create is rolled back along with its audit.
+	res = callApiHandler(getDbAuditHandler, http.MethodGet,
+		`/test/db/_audit||table=AUD&start_time=` + start + `&offset=1`)
+	ops, _ = auditOps(cx, res)
+	cx.assertEqualObj([]string{"insert", "update", "replace",
+		"delete", "restore", "delete", "purge"}, ops, "audit of table")
+
+	res = callApiHandler(getDbAuditHandler, http.MethodGet,
+		`/test/db/_audit||table=AUD&limit=2&offset=1`)
+	ops, _ = auditOps(cx, res)
+	cx.assertEqualObj([]string{"insert", "update"}, ops,
+		"audit w/ limit and offset")
+
+	res = callApiHandler(getDbAuditHandler, http.MethodGet,
+		`/test/db/_audit||table=AUD&end_time=` + start)
+	ops, _ = auditOps(cx, res)
+	cx.assertEqual(0, len(ops), "audit before start")
+
+	res = callApiHandler(getDbAuditHandler, http.MethodGet,
+		`/test/db/_audit||start_time=yesterday`)
+	cx.assertEqual(http.StatusBadRequest, res.code, "audit w/ bad time")
+
+	// changes to a table without audit are not recorded.
+	res = callApiHandler(updateDbRecordHandler, http.MethodPatch,
+		`/test/db/_table/xxx|table_name=xxx&id=1||{"records":[{"keys":["name"],"values":["x1"]}]}`)
+	cx.assertEqual(http.StatusOK, res.code, "update xxx")
+	res = callApiHandler(getDbAuditHandler, http.MethodGet,
+		`/test/db/_audit||table=xxx`)
+	ops, _ = auditOps(cx, res)
+	cx.assertEqual(0, len(ops), "audit of table w/o audit")
+}
+
+// the caller is the remote address, and the header is only claimed.
+func Test_setCaller(t *testing.T) {
+	cx := newTestContext(t)
+	harg := parseHandlerArg(http.MethodGet, `/test/db/_audit`)
+	harg.req.RemoteAddr = "192.0.2.1:1234"
+	params := map[string]string{}
+	setCaller(harg, params)
+	cx.assertEqual("192.0.2.1:1234", params["caller"], "caller")
+	cx.assertEqual("", params["claimed_caller"], "claimed caller w/o header")
+	harg.req.Header.Set(callerHeader, "tester")
+	setCaller(harg, params)
+	cx.assertEqual("192.0.2.1:1234", params["caller"], "caller w/ header")
+	cx.assertEqual("tester", params["claimed_caller"], "claimed caller")
+}
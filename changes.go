@@ -0,0 +1,288 @@
+package apidCRUD
+
+// this module implements the change feed.  each change that the record
+// APIs make to a record is recorded in the changes table, in the same
+// transaction as the change, with a sequence number that increases
+// monotonically.  a client reads the changes after the last sequence
+// number it has seen, either at once, or waiting for a change if there
+// are none yet (a long poll), or as a stream of server-sent events.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// changesCmds are the commands that create the changes table, if it
+// does not exist yet.  %[1]s is the name of the table.
+var changesCmds = []string {
+	`CREATE TABLE IF NOT EXISTS %[1]s (seq integer not null primary key autoincrement, ts text not null, op text not null, table_name text not null, record_id integer not null)`,
+	`CREATE INDEX IF NOT EXISTS %[1]s_table ON %[1]s (table_name, seq)`,
+}
+
+// maxChangeWait is the longest that a request for changes may wait.
+const maxChangeWait = 5 * time.Minute
+
+// changePollInterval is how often a waiting request for changes
+// checks for changes that it was not notified of, such as those
+// made by another apid instance using the same database.
+const changePollInterval = time.Second
+
+// ensureChangesTable() creates the changes table if it does not exist.
+func ensureChangesTable(db dbType) error {
+	for _, cmd := range changesCmds {
+		_, err := db.runner().Exec(fmt.Sprintf(cmd, changesTable))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordChange() calls fn, which changes the records with the given
+// ids and returns the ids of any records that it created.  the change
+// to each record is then recorded in the changes table, and if the
+// table is audited, in the audit table, with the given operation, or
+// "insert" for a record that did not exist before.  a payload is
+// queued for each webhook on the table that subscribes to the change.
+// the values of the records are only read if the table is audited
+// or has webhooks, which need them.  then records that did not change
+// are not recorded; otherwise, each record that exists before or after
+// the change is recorded.  db should be in a transaction, so that the
+// change is recorded if and only if it is made.
+func recordChange(db dbType,
+	params map[string]string,
+	op string,
+	ids []int64,
+	fn func() ([]int64, error)) error {
+	hooks, err := tableHooks(db, params["table_name"])
+	if err != nil {
+		return err
+	}
+	withValues := params["audit"] == "true" || len(hooks) > 0
+	before, err := recordValues(db, params, ids, withValues)
+	if err != nil {
+		return err
+	}
+	created, err := fn()
+	if err != nil {
+		return err
+	}
+	ids = append(append([]int64{}, ids...), created...)
+	after, err := recordValues(db, params, ids, withValues)
+	if err != nil {
+		return err
+	}
+	now := dbTime(time.Now())
+	done := map[int64]bool{}
+	for _, id := range ids {
+		b, hadBefore := before[id]
+		a, hasAfter := after[id]
+		if done[id] || (!hadBefore && !hasAfter) ||
+			(withValues && b == a) {
+			continue
+		}
+		done[id] = true
+		rop := op
+		if !hadBefore {
+			rop = "insert"
+		}
+		exres, err := runExec(db, fmt.Sprintf(
+			"INSERT INTO %s (ts,op,table_name,record_id) VALUES (?,?,?,?)",
+			changesTable),
+			[]interface{}{now, rop, params["table_name"], id})
+		if err != nil {
+			return err
+		}
+		err = queueHooks(db, hooks, HookPayload{
+			Seq: int64(exres.lastInsertId), Time: now, Op: rop,
+			Table: params["table_name"], RecordId: id,
+			Before: rawValue(b, hadBefore),
+			After: rawValue(a, hasAfter)}, now)
+		if err != nil {
+			return err
+		}
+		if params["audit"] == "true" {
+			err = writeAudit(db, params, rop, id, now,
+				auditValue(b, hadBefore), auditValue(a, hasAfter))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rawValue() returns the values of a record as json,
+// or nil if the record does not exist.
+func rawValue(obj string, exists bool) json.RawMessage {
+	if !exists {
+		return nil
+	}
+	return json.RawMessage(obj)
+}
+
+// changeMutex protects changeSignal.
+var changeMutex sync.Mutex
+
+// changeSignal is closed, and replaced by a new channel, whenever a
+// transaction that may have recorded changes is committed, to wake
+// the requests that are waiting for changes.
+var changeSignal = make(chan struct{})
+
+// changeWaiter() returns a channel that is closed when changes
+// may have been committed.
+func changeWaiter() <-chan struct{} {
+	changeMutex.Lock()
+	defer changeMutex.Unlock()
+	return changeSignal
+}
+
+// notifyChanges() wakes the requests that are waiting for changes.
+func notifyChanges() {
+	changeMutex.Lock()
+	defer changeMutex.Unlock()
+	close(changeSignal)
+	changeSignal = make(chan struct{})
+}
+
+// queryChanges() returns the changes after the sequence number given
+// by the since parameter, to the table given by the table parameter,
+// if any, in order, up to the limit parameter.
+func queryChanges(db dbType, params map[string]string) ([]ChangeEntry, error) {
+	ret := []ChangeEntry{}
+	since, _ := strconv.ParseInt(params["since"], idTypeRadix, idTypeBits)
+	limit, _ := strconv.ParseInt(params["limit"], idTypeRadix, idTypeBits)
+	where := "WHERE seq > ?"
+	args := []interface{}{since}
+	if params["table"] != "" {
+		where = andWhere(where, "table_name = ?")
+		args = append(args, params["table"])
+	}
+	qstring := fmt.Sprintf("SELECT seq,ts,op,table_name,record_id FROM %s %s ORDER BY seq LIMIT ?", // nolint
+		changesTable, where)
+	log.Debugf("query = %s", qstring)
+	rows, err := db.runner().Query(qstring, append(args, limit)...)
+	if err != nil {
+		return ret, err
+	}
+	defer rows.Close() // nolint
+	for rows.Next() {
+		var c ChangeEntry
+		err = rows.Scan(&c.Seq, &c.Time, &c.Op, &c.Table, &c.RecordId)
+		if err != nil {
+			return ret, err
+		}
+		ret = append(ret, c)
+	}
+	return ret, rows.Err()
+}
+
+// waitChanges() is like queryChanges(), except that if there are no
+// changes, it waits up to the given duration for some, or until the
+// done channel is closed.
+func waitChanges(db dbType,
+	params map[string]string,
+	wait time.Duration,
+	done <-chan struct{}) ([]ChangeEntry, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		// get the signal first, so that a commit after the query
+		// is not missed.
+		signal := changeWaiter()
+		changes, err := queryChanges(db, params)
+		if err != nil || len(changes) > 0 {
+			return changes, err
+		}
+		left := time.Until(deadline)
+		if left <= 0 {
+			return changes, nil
+		}
+		if left > changePollInterval {
+			left = changePollInterval
+		}
+		timer := time.NewTimer(left)
+		select {
+		case <-signal:
+		case <-timer.C:
+		case <-done:
+			timer.Stop()
+			return changes, nil
+		}
+		timer.Stop()
+	}
+}
+
+// lastSeq() returns the sequence number of the last of the given
+// changes, or the given default if there are none.
+func lastSeq(changes []ChangeEntry, dflt int64) int64 {
+	if len(changes) == 0 {
+		return dflt
+	}
+	return changes[len(changes)-1].Seq
+}
+
+// changeStream is the response data for a request for changes
+// as server-sent events.
+type changeStream struct {
+	params map[string]string
+	wait time.Duration
+	done <-chan struct{}
+}
+
+// stream() writes the changes as server-sent events, as they are
+// made, until the client goes away, or until the wait duration is up,
+// if one was given.  each event has the sequence number of the change
+// as its id, so that a client that reconnects with Last-Event-ID
+// continues where it left off.  a comment is written whenever
+// there were no changes for a while, to keep the connection alive.
+func (cs changeStream) stream(w http.ResponseWriter) {
+	flusher, _ := w.(http.Flusher)
+	params := copyParams(cs.params)
+	since, _ := strconv.ParseInt(params["since"], idTypeRadix, idTypeBits)
+	var deadline time.Time
+	if cs.wait > 0 {
+		deadline = time.Now().Add(cs.wait)
+	}
+	for {
+		window := maxChangeWait
+		if !deadline.IsZero() {
+			window = time.Until(deadline)
+			if window <= 0 {
+				return
+			}
+		}
+		params["since"] = strconv.FormatInt(since, 10)
+		changes, err := waitChanges(db, params, window, cs.done)
+		if err != nil {
+			log.Errorf("change stream: %s", err)
+			return
+		}
+		if len(changes) == 0 {
+			_, err = fmt.Fprint(w, ": keepalive\n\n")
+		}
+		for _, c := range changes {
+			data, _ := json.Marshal(c)
+			_, err = fmt.Fprintf(w, "id: %d\nevent: change\ndata: %s\n\n",
+				c.Seq, data)
+			if err != nil {
+				break
+			}
+		}
+		if err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		since = lastSeq(changes, since)
+		select {
+		case <-cs.done:
+			return
+		default:
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package apidCRUD
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ----- unit tests for the change feed
+
+// currentSeq() returns the sequence number of the last change.
+func currentSeq(cx *testContext) int64 {
+	var seq int64
+	err := db.handle.QueryRow(fmt.Sprintf(
+		"SELECT COALESCE(MAX(seq),0) FROM %s", changesTable)).Scan(&seq)
+	cx.assertErrorNil(err, "currentSeq")
+	return seq
+}
+
+// getChanges() calls getDbChangesHandler with the given query,
+// and returns the changes.
+func getChanges(cx *testContext, query string) ChangesResponse {
+	res := callApiHandler(getDbChangesHandler, http.MethodGet,
+		`/test/db/_changes||` + query)
+	cx.assertEqual(http.StatusOK, res.code, query)
+	resp, ok := res.data.(ChangesResponse)
+	cx.assertTrue(ok, "changes response data type")
+	return resp
+}
+
+// changeOps() returns the operations of the given changes.
+func changeOps(changes []ChangeEntry) []string {
+	ret := []string{}
+	for _, c := range changes {
+		ret = append(ret, c.Op)
+	}
+	return ret
+}
+
+// the change feed test suite.
+func Test_getDbChanges(t *testing.T) {
+	cx := newTestContext(t)
+	setup := []apiCall_TC {
+		{"setup: create table CHG",
+			createDbTableHandler,
+			http.MethodPost,
+			`/test/db/_schema/CHG|table_name=CHG||{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"}]}`,
+			http.StatusCreated, noCheck},
+		{"create records",
+			createDbRecordsHandler,
+			http.MethodPost,
+			`/test/db/_table/CHG|table_name=CHG||{"records":[{"keys":["name"],"values":["a"]},{"keys":["name"],"values":["b"]}]}`,
+			http.StatusCreated, noCheck},
+		{"update record",
+			updateDbRecordHandler,
+			http.MethodPatch,
+			`/test/db/_table/CHG|table_name=CHG&id=1||{"records":[{"keys":["name"],"values":["aa"]}]}`,
+			http.StatusOK, noCheck},
+		{"delete record",
+			deleteDbRecordHandler,
+			http.MethodDelete,
+			`/test/db/_table/CHG|table_name=CHG&id=2`,
+			http.StatusOK, noCheck},
+		{"update xxx",
+			updateDbRecordHandler,
+			http.MethodPatch,
+			`/test/db/_table/xxx|table_name=xxx&id=2||{"records":[{"keys":["name"],"values":["x2x"]}]}`,
+			http.StatusOK, noCheck},
+	}
+	start := currentSeq(cx)
+	for _, tc := range setup {
+		apiCall_Checker(cx, &tc)
+	}
+	defer callApiHandler(deleteDbTableHandler, http.MethodDelete,
+		`/test/db/_schema/CHG|table_name=CHG`)
+
+	q := fmt.Sprintf("since=%d", start)
+	resp := getChanges(cx, q + "&table=CHG")
+	cx.assertEqualObj([]string{"insert", "insert", "update", "delete"},
+		changeOps(resp.Changes), "changes of table")
+	cx.assertEqual(resp.Changes[3].Seq, resp.LastSeq, "lastSeq")
+	cx.assertEqual(int64(2), resp.Changes[3].RecordId, "recordId")
+
+	resp = getChanges(cx, q + "&limit=2")
+	cx.assertEqual(2, len(resp.Changes), "changes w/ limit")
+
+	resp = getChanges(cx, q)
+	cx.assertEqual(5, len(resp.Changes), "changes of all tables")
+	cx.assertEqual("xxx", resp.Changes[4].Table, "table of change")
+	last := resp.LastSeq
+
+	// a long poll returns when a change is made.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		callApiHandler(createDbRecordsHandler, http.MethodPost,
+			`/test/db/_table/CHG|table_name=CHG||{"records":[{"keys":["name"],"values":["c"]}]}`)
+	}()
+	t0 := time.Now()
+	resp = getChanges(cx, fmt.Sprintf("since=%d&wait=10s", last))
+	cx.assertTrue(time.Since(t0) < 5*time.Second, "long poll woken")
+	cx.assertEqualObj([]string{"insert"}, changeOps(resp.Changes),
+		"changes after long poll")
+
+	// a long poll with no changes times out.
+	t0 = time.Now()
+	resp = getChanges(cx, fmt.Sprintf("since=%d&wait=50ms", resp.LastSeq))
+	cx.assertTrue(time.Since(t0) >= 50*time.Millisecond, "long poll waited")
+	cx.assertEqual(0, len(resp.Changes), "changes after timeout")
+
+	res := callApiHandler(getDbChangesHandler, http.MethodGet,
+		`/test/db/_changes||since=-1`)
+	cx.assertEqual(http.StatusBadRequest, res.code, "bad since")
+}
+
+// the change stream test suite.
+func Test_changeStream(t *testing.T) {
+	cx := newTestContext(t)
+	start := currentSeq(cx)
+	res := callApiHandler(updateDbRecordHandler, http.MethodPatch,
+		`/test/db/_table/xxx|table_name=xxx&id=3||{"records":[{"keys":["name"],"values":["x3s"]}]}`)
+	cx.assertEqual(http.StatusOK, res.code, "update xxx")
+
+	vmap := verbMap{path: "/db/_changes",
+		methods: map[string]apiHandler{http.MethodGet: getDbChangesHandler}}
+	harg := parseHandlerArg(http.MethodGet,
+		`/test/db/_changes||table=xxx&wait=100ms`)
+	harg.req.Header.Set("Accept", "text/event-stream")
+	harg.req.Header.Set("Last-Event-ID", fmt.Sprintf("%d", start))
+	w := httptest.NewRecorder()
+	pathDispatch(vmap, w, harg)
+
+	cx.assertEqual(http.StatusOK, w.Code, "stream code")
+	cx.assertEqual("text/event-stream", w.Header().Get("Content-Type"),
+		"stream content type")
+	body := w.Body.String()
+	event := fmt.Sprintf("id: %d\nevent: change\ndata: {\"seq\":%d,",
+		start+1, start+1)
+	cx.assertTrue(strings.HasPrefix(body, event), "stream event")
+	cx.assertEqual(1, strings.Count(body, "event: change"),
+		"number of events")
+	cx.assertTrue(strings.Contains(body, ": keepalive\n\n"),
+		"stream keepalive")
+}
+
+// every record changed by a bulk request is recorded, even when there
+// are more of them than maxRecs.
+func Test_bulkChanges(t *testing.T) {
+	cx := newTestContext(t)
+	res := callApiHandler(createDbTableHandler, http.MethodPost,
+		`/test/db/_schema/BULK|table_name=BULK||{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"}],"audit":true}`)
+	cx.assertEqual(http.StatusCreated, res.code, "create table BULK")
+	defer callApiHandler(deleteDbTableHandler, http.MethodDelete,
+		`/test/db/_schema/BULK|table_name=BULK`)
+	recs := []string{}
+	for i := 0; i < 7; i++ {
+		recs = append(recs, fmt.Sprintf(`{"keys":["name"],"values":["b%d"]}`, i))
+	}
+	res = callApiHandler(createDbRecordsHandler, http.MethodPost,
+		`/test/db/_table/BULK|table_name=BULK||{"records":[` +
+		strings.Join(recs, ",") + `]}`)
+	cx.assertEqual(http.StatusCreated, res.code, "create records")
+
+	saveMaxRecs, saveChunk := maxRecs, recordValuesChunk
+	maxRecs, recordValuesChunk = 3, 2
+	defer func() {
+		maxRecs, recordValuesChunk = saveMaxRecs, saveChunk
+	}()
+	start := currentSeq(cx)
+	res = callApiHandler(updateDbRecordsHandler, http.MethodPatch,
+		`/test/db/_table/BULK|table_name=BULK|filter=name+LIKE+'b%25'|{"records":[{"keys":["name"],"values":["c"]}]}`)
+	cx.assertEqual(http.StatusOK, res.code, "bulk update")
+
+	var nchanges, naudits int
+	err := db.handle.QueryRow(fmt.Sprintf(
+		"SELECT count(*) FROM %s WHERE table_name = 'BULK' AND seq > ?",
+		changesTable), start).Scan(&nchanges)
+	cx.assertErrorNil(err, "count changes")
+	cx.assertEqual(7, nchanges, "number of changes")
+	err = db.handle.QueryRow(fmt.Sprintf(
+		"SELECT count(*) FROM %s WHERE table_name = 'BULK' AND op = 'update'",
+		auditTable)).Scan(&naudits)
+	cx.assertErrorNil(err, "count audits")
+	cx.assertEqual(7, naudits, "number of audit entries")
+}
+
+// the values of the records are only read when the table needs them.
+func Test_recordValues(t *testing.T) {
+	cx := newTestContext(t)
+	res := callApiHandler(createDbTableHandler, http.MethodPost,
+		`/test/db/_schema/RV|table_name=RV||{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"}]}`)
+	cx.assertEqual(http.StatusCreated, res.code, "create table RV")
+	defer callApiHandler(deleteDbTableHandler, http.MethodDelete,
+		`/test/db/_schema/RV|table_name=RV`)
+	res = callApiHandler(createDbRecordsHandler, http.MethodPost,
+		`/test/db/_table/RV|table_name=RV||{"records":[{"keys":["name"],"values":["a"]}]}`)
+	cx.assertEqual(http.StatusCreated, res.code, "create record")
+
+	params := map[string]string{"table_name": "RV"}
+	vals, err := recordValues(db, params, []int64{1, 2}, true)
+	cx.assertErrorNil(err, "recordValues w/ values")
+	cx.assertEqualObj(map[int64]string{1: `{"id":1,"name":"a"}`}, vals,
+		"values")
+	vals, err = recordValues(db, params, []int64{1, 2}, false)
+	cx.assertErrorNil(err, "recordValues w/o values")
+	cx.assertEqualObj(map[int64]string{1: ""}, vals, "ids only")
+
+	// the changes to a table w/o audit or webhooks are still recorded.
+	start := currentSeq(cx)
+	res = callApiHandler(deleteDbRecordHandler, http.MethodDelete,
+		`/test/db/_table/RV|table_name=RV&id=1`)
+	cx.assertEqual(http.StatusOK, res.code, "delete record")
+	cx.assertEqual(start+1, currentSeq(cx), "seq after delete")
+}
@@ -0,0 +1,232 @@
+package apidCRUD
+
+// this module implements the opaque "cursor" used for keyset pagination.
+// a cursor records the sort keys of a query, and the values of those
+// keys in the last record returned.  the next page is selected by a
+// condition on the sort keys, rather than by an offset, so the cost
+// of fetching a page does not grow as the client walks thru the table,
+// and concurrent inserts do not cause records to be skipped or repeated.
+// when the keys all sort in one direction, the condition is a single
+// row value comparison, which sqlite can answer by seeking in an index
+// on the keys.  otherwise, or when NULLs must be allowed for, it is an
+// OR over the keys, which sqlite may answer by a scan, so deep pages
+// are then not fetched in constant time.
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// cursorData is the decoded form of a cursor.
+type cursorData struct {
+	Order string	`json:"o"`	// normalized sort keys
+	Keys []interface{} `json:"k"`	// values of the sort keys, except id
+	Id int64	`json:"i"`	// id of the last record seen
+}
+
+// encodeCursor() returns the opaque string form of the given cursor.
+func encodeCursor(cd cursorData) (string, error) {
+	data, err := json.Marshal(cd)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor() converts the opaque string form of a cursor
+// back to a cursorData.
+func decodeCursor(cursor string) (cursorData, error) {
+	cd := cursorData{}
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return cd, fmt.Errorf("invalid cursor")
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	err = dec.Decode(&cd)
+	if err != nil || cd.Order == "" {
+		return cd, fmt.Errorf("invalid cursor")
+	}
+	for i, v := range cd.Keys {
+		cd.Keys[i] = convCursorValue(v)
+	}
+	return cd, nil
+}
+
+// convCursorValue() converts a json.Number from a decoded cursor
+// to int64 or float64, so it can be bound as an SQL value.
+func convCursorValue(v interface{}) interface{} {
+	num, ok := v.(json.Number)
+	if !ok {
+		return v
+	}
+	if i, err := num.Int64(); err == nil {
+		return i
+	}
+	f, _ := num.Float64()
+	return f
+}
+
+// sortKeys() returns the sort keys implied by the order parameter.
+// the id field is appended if it is not already one of the keys.
+func sortKeys(params map[string]string) []orderItem {
+	items, _ := parseOrder(params["order"])
+	idfield := idFieldName(params)
+	for _, oi := range items {
+		if oi.field == idfield {
+			return items
+		}
+	}
+	return append(items, orderItem{field: idfield})
+}
+
+// mkCursorClause() returns the condition that selects the records
+// after the position recorded in the cursor parameter,
+// and the values to be bound to it.  the condition is empty if
+// there is no cursor.  the cursor must have been made for the
+// same order and id_field as the current request.
+// the not_null_fields parameter, set by setTableOptions(), names the
+// fields that cannot be NULL.
+func mkCursorClause(params map[string]string) (string, []interface{}, error) {
+	args := []interface{}{}
+	if params["cursor"] == "" {
+		return "", args, nil
+	}
+	cd, err := decodeCursor(params["cursor"])
+	if err != nil {
+		return "", args, err
+	}
+	keys := sortKeys(params)
+	idfield := idFieldName(params)
+	if cd.Order != orderToA(keys) || len(cd.Keys) != len(keys)-1 {
+		return "", args,
+			fmt.Errorf("cursor does not match order or id_field")
+	}
+	vals := make([]interface{}, 0, len(keys))
+	rest := cd.Keys
+	for _, oi := range keys {
+		if oi.field == idfield {
+			vals = append(vals, cd.Id)
+		} else {
+			vals = append(vals, rest[0])
+			rest = rest[1:]
+		}
+	}
+
+	if rowValueCursor(params, keys, vals) {
+		return rowValueCond(keys, vals), vals, nil
+	}
+
+	// for keys k1..kn, the condition is the OR over i of
+	// k1 IS v1 AND ... AND k(i-1) IS v(i-1) AND (ki is after vi).
+	terms := make([]string, len(keys))
+	for i, oi := range keys {
+		conds := []string{}
+		for j := 0; j < i; j++ {
+			conds = append(conds, quoteIdent(keys[j].field) + " IS ?")
+			args = append(args, vals[j])
+		}
+		cond, cargs := afterCond(oi, vals[i])
+		conds = append(conds, cond)
+		args = append(args, cargs...)
+		terms[i] = "(" + strings.Join(conds, " AND ") + ")"
+	}
+	return "(" + strings.Join(terms, " OR ") + ")", args, nil
+}
+
+// rowValueCursor() returns true if the condition that the records
+// come after the given values of the sort keys can be a row value
+// comparison.  that compares NULL as unknown, rather than as less than
+// all other values, so none of the values may be NULL, and when the
+// keys are descending, none of the fields may be NULL either.
+func rowValueCursor(params map[string]string,
+	keys []orderItem,
+	vals []interface{}) bool {
+	notNull := map[string]bool{}
+	for _, f := range strings.Split(params["not_null_fields"], ",") {
+		notNull[f] = true
+	}
+	for i, oi := range keys {
+		if vals[i] == nil || oi.desc != keys[0].desc ||
+			(oi.desc && !notNull[oi.field]) {
+			return false
+		}
+	}
+	return true
+}
+
+// rowValueCond() returns the row value comparison of the sort keys
+// with the given values.
+func rowValueCond(keys []orderItem, vals []interface{}) string {
+	fields := make([]string, len(keys))
+	for i, oi := range keys {
+		fields[i] = quoteIdent(oi.field)
+	}
+	op := " > "
+	if keys[0].desc {
+		op = " < "
+	}
+	return "(" + strings.Join(fields, ",") + ")" + op +
+		"(" + strings.TrimSuffix(strings.Repeat("?,", len(vals)), ",") + ")"
+}
+
+// afterCond() returns the condition that the given sort key comes
+// after the value v, and the values to be bound to it.
+// sqlite sorts NULL before all other values.
+func afterCond(oi orderItem, v interface{}) (string, []interface{}) {
+	field := quoteIdent(oi.field)
+	switch {
+	case v == nil && !oi.desc:
+		return field + " IS NOT NULL", []interface{}{}
+	case v == nil && oi.desc:
+		return "0", []interface{}{}
+	case oi.desc:
+		return "(" + field + " < ? OR " + field + " IS NULL)",
+			[]interface{}{v}
+	default:
+		return field + " > ?", []interface{}{v}
+	}
+}
+
+// mkNextCursor() returns the cursor for the page after the one
+// ending with the record of the given id.  the values of the sort
+// keys are read back from that record as they are stored, not as
+// runQuery() converts them, since they are compared with the stored
+// values.  each key is read as an expression (+field), which has no
+// declared type, so that the driver does not convert a datetime.
+func mkNextCursor(db dbType,
+	params map[string]string,
+	id int64) (string, error) {
+	keys := sortKeys(params)
+	idfield := idFieldName(params)
+	cd := cursorData{Order: orderToA(keys), Keys: []interface{}{}, Id: id}
+	exprs := []string{}
+	for _, oi := range keys {
+		if oi.field != idfield {
+			exprs = append(exprs, "+" + quoteIdent(oi.field))
+		}
+	}
+	if len(exprs) == 0 {
+		return encodeCursor(cd)
+	}
+	q := newSQL("SELECT " + strings.Join(exprs, ",")).
+		sql(" FROM ").ident(params["table_name"]).
+		sql(" WHERE ").ident(idfield).sql(" = ").value(id)
+	log.Debugf("query = %s", q)
+	vals := mkSQLRow(len(exprs))
+	err := db.runner().QueryRow(q.String(), q.args...).Scan(vals...)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("cursor record %d not found", id)
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, v := range vals {
+		cd.Keys = append(cd.Keys, *(v.(*interface{})))
+	}
+	return encodeCursor(cd)
+}
@@ -0,0 +1,149 @@
+package apidCRUD
+
+import (
+	"testing"
+	"fmt"
+	"strings"
+)
+
+// ----- unit tests for encodeCursor() and decodeCursor()
+
+// table of cursors for the round trip test.
+var cursorRoundTrip_Tab = []cursorData {
+	{"id ASC", []interface{}{}, 1},
+	{"name DESC,id ASC", []interface{}{"x1"}, 12},
+	{"a ASC,b ASC,id ASC", []interface{}{int64(3), 2.5}, 7},
+	{"a ASC,id ASC", []interface{}{nil}, 7},
+}
+
+// run one testcase for the round trip of encodeCursor and decodeCursor.
+func cursorRoundTrip_Checker(cx *testContext, tc *cursorData) {
+	s, err := encodeCursor(*tc)
+	if !cx.assertErrorNil(err, "encodeCursor") {
+		return
+	}
+	cx.assertTrue(!strings.ContainsAny(s, "+/=?&"), "cursor is url-safe")
+	cd, err := decodeCursor(s)
+	if !cx.assertErrorNil(err, "decodeCursor") {
+		return
+	}
+	cx.assertEqualObj(*tc, cd, "decoded cursor")
+}
+
+// the cursor round trip test suite.
+func Test_cursorRoundTrip(t *testing.T) {
+	cx := newTestContext(t, "cursorRoundTrip_Tab")
+	for _, tc := range cursorRoundTrip_Tab {
+		cursorRoundTrip_Checker(cx, &tc)
+		cx.bump()	// increment testno.
+	}
+}
+
+// invalid cursors.
+var decodeCursor_Tab = []string {
+	"bogus!",
+	"e30",		// {}
+	"eyJvIjoxfQ",	// {"o":1}
+}
+
+// the decodeCursor test suite.  all testcases should fail.
+func Test_decodeCursor(t *testing.T) {
+	cx := newTestContext(t, "decodeCursor_Tab")
+	for _, s := range decodeCursor_Tab {
+		_, err := decodeCursor(s)
+		cx.assertTrue(err != nil, "decodeCursor should fail")
+		cx.bump()	// increment testno.
+	}
+}
+
+// ----- unit tests for mkCursorClause()
+
+// inputs and outputs for one mkCursorClause testcase.
+type mkCursorClause_TC struct {
+	params string
+	cd cursorData
+	xsql string
+	xargs string
+	xsucc bool
+}
+
+// table of mkCursorClause testcases.
+var mkCursorClause_Tab = []mkCursorClause_TC {
+	{"", cursorData{"id ASC", []interface{}{}, 5},
+		"(`id`) > (?)", "5", true},
+	{"order=id DESC", cursorData{"id DESC", []interface{}{}, 5},
+		"(((`id` < ? OR `id` IS NULL)))", "5", true},
+	{"order=id DESC&not_null_fields=id",
+		cursorData{"id DESC", []interface{}{}, 5},
+		"(`id`) < (?)", "5", true},
+	{"order=name ASC", cursorData{"name ASC,id ASC", []interface{}{"x"}, 5},
+		"(`name`,`id`) > (?,?)", "x,5", true},
+	{"order=name DESC,id DESC&id_field=id",
+		cursorData{"name DESC,id DESC", []interface{}{"x"}, 5},
+		"(((`name` < ? OR `name` IS NULL)) OR (`name` IS ? AND (`id` < ? OR `id` IS NULL)))",
+		"x,x,5", true},
+	{"order=name DESC,id DESC&not_null_fields=id",
+		cursorData{"name DESC,id DESC", []interface{}{"x"}, 5},
+		"(((`name` < ? OR `name` IS NULL)) OR (`name` IS ? AND (`id` < ? OR `id` IS NULL)))",
+		"x,x,5", true},
+	{"order=name DESC,id DESC&not_null_fields=name,id",
+		cursorData{"name DESC,id DESC", []interface{}{"x"}, 5},
+		"(`name`,`id`) < (?,?)", "x,5", true},
+	{"order=id DESC,name ASC",
+		cursorData{"id DESC,name ASC", []interface{}{"x"}, 5},
+		"(((`id` < ? OR `id` IS NULL)) OR (`id` IS ? AND `name` > ?))",
+		"5,5,x", true},
+	{"order=name ASC", cursorData{"name ASC,id ASC", []interface{}{nil}, 5},
+		"((`name` IS NOT NULL) OR (`name` IS ? AND `id` > ?))", "<nil>,5", true},
+	{"order=name DESC", cursorData{"name DESC,id ASC", []interface{}{nil}, 5},
+		"((0) OR (`name` IS ? AND `id` > ?))", "<nil>,5", true},
+	{"id_field=key", cursorData{"key ASC", []interface{}{}, 5},
+		"(`key`) > (?)", "5", true},
+	// cursor made for a different order.
+	{"order=name DESC", cursorData{"name ASC,id ASC", []interface{}{"x"}, 5},
+		"", "", false},
+	// cursor made for a different id_field.
+	{"id_field=key", cursorData{"id ASC", []interface{}{}, 5},
+		"", "", false},
+	// wrong number of values.
+	{"order=name ASC", cursorData{"name ASC,id ASC", []interface{}{}, 5},
+		"", "", false},
+}
+
+// run one testcase for function mkCursorClause.
+func mkCursorClause_Checker(cx *testContext, tc *mkCursorClause_TC) {
+	params := fakeParams(tc.params)
+	s, err := encodeCursor(tc.cd)
+	if !cx.assertErrorNil(err, "encodeCursor") {
+		return
+	}
+	params["cursor"] = s
+	sql, args, err := mkCursorClause(params)
+	if !cx.assertEqual(tc.xsucc, err == nil, "success") || err != nil {
+		return
+	}
+	cx.assertEqual(tc.xsql, sql, "clause")
+	strs := make([]string, len(args))
+	for i, a := range args {
+		strs[i] = fmt.Sprintf("%v", a)
+	}
+	cx.assertEqual(tc.xargs, strings.Join(strs, ","), "args")
+}
+
+// the mkCursorClause test suite.  run all mkCursorClause testcases.
+func Test_mkCursorClause(t *testing.T) {
+	cx := newTestContext(t, "mkCursorClause_Tab")
+	for _, tc := range mkCursorClause_Tab {
+		mkCursorClause_Checker(cx, &tc)
+		cx.bump()	// increment testno.
+	}
+}
+
+// mkCursorClause with no cursor should return an empty clause.
+func Test_mkCursorClause_empty(t *testing.T) {
+	cx := newTestContext(t, "mkCursorClause_empty")
+	sql, args, err := mkCursorClause(fakeParams("order=name ASC"))
+	cx.assertErrorNil(err, "mkCursorClause")
+	cx.assertEqual("", sql, "clause")
+	cx.assertEqual(0, len(args), "number of args")
+}
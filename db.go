@@ -1,11 +1,256 @@
 package apidCRUD
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"github.com/mattn/go-sqlite3"
 )
 
-// initDB opens the named database and returns a handle wrapper.
+// initDB opens the named database, creates the internal tables,
+// and returns a handle wrapper.
+// for sqlite, foreign key constraints are enabled on every connection.
 func initDB(dbName string) (dbType, error) {
-	h, err := sql.Open(dbDriver, dbName)
-	return dbType{handle: h}, err
+	h, err := sql.Open(dbDriver, dbDSN(dbName))
+	if err != nil {
+		return dbType{handle: h}, err
+	}
+	db := dbType{handle: h}
+	return db, createInternalTables(db)
+}
+
+// createInternalTables() creates the internal tables of apidCRUD,
+// other than the table of tables, if they do not exist: the audit
+// table, the changes table, and the webhooks and outbox tables.
+// they are created once, here, rather than when they are first used.
+func createInternalTables(db dbType) error {
+	for _, ensure := range []func(dbType) error {
+		ensureAuditTable,
+		ensureChangesTable,
+		ensureHooksTables,
+	} {
+		err := ensure(db)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dbBusyTimeout is how long, in milliseconds, a statement waits for
+// a lock that another connection holds, before it fails as busy.
+const dbBusyTimeout = 5000
+
+// dbDSN() returns the data source name used to open the named database.
+// transactions take the write lock when they begin, so that a
+// transaction that reads and then writes waits for the lock, rather
+// than failing when it cannot upgrade its read lock.
+func dbDSN(dbName string) string {
+	if dbDriver != "sqlite3" {
+		return dbName
+	}
+	sep := "?"
+	if strings.Contains(dbName, "?") {
+		sep = "&"
+	}
+	return fmt.Sprintf("%s%s_foreign_keys=1&_busy_timeout=%d&_txlock=immediate",
+		dbName, sep, dbBusyTimeout)
+}
+
+// dbTimeFormat is the format of the times that apidCRUD stores,
+// such as deletion times.  it has fixed width, so that the times
+// sort as strings, and is one of the formats that the sqlite driver
+// converts back to time.Time .
+const dbTimeFormat = "2006-01-02 15:04:05.000"
+
+// dbTime() returns the given time as stored in the database, in UTC.
+func dbTime(t time.Time) string {
+	return t.UTC().Format(dbTimeFormat)
+}
+
+// runner() returns what statements should be run on,
+// the transaction if there is one, else the database handle.
+func (db dbType) runner() dbRunner {
+	if db.tx != nil {
+		return db.tx
+	}
+	return db.handle
+}
+
+// withTx() calls the given function with a copy of db that runs
+// statements in a new transaction, which is committed if the function
+// succeeds and rolled back if it fails.  after a commit, the requests
+// waiting for changes are woken, since the transaction may have
+// recorded some.
+func withTx(db dbType, fn func(txdb dbType) error) error {
+	tx, err := db.handle.Begin()
+	if err != nil {
+		return err
+	}
+	err = fn(dbType{handle: db.handle, tx: tx})
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err == nil {
+		notifyChanges()
+	}
+	return err
+}
+
+// conflictMessages are the sqlite error messages for constraint
+// violations that are reported as conflicts.  sqlite does not
+// always return the extended error code, e.g. for a foreign key
+// with on delete restrict.
+var conflictMessages = []string {
+	"UNIQUE constraint failed",
+	"PRIMARY KEY must be unique",
+	"FOREIGN KEY constraint failed",
+}
+
+// dbErrorStatus() returns the http status for an error from
+// the database.  violations of unique, primary key, and
+// foreign key constraints are conflicts; a database that stays busy
+// or locked is unavailable; others are bad requests.
+// a recordError has the status of the error it wraps,
+// and a statusError has its own status.
+func dbErrorStatus(err error) int {
+	if rerr, ok := err.(recordError); ok {
+		err = rerr.err
+	}
+	if serr, ok := err.(statusError); ok {
+		return serr.code
+	}
+	serr, ok := err.(sqlite3.Error)
+	if !ok {
+		return badStat
+	}
+	switch serr.Code {
+	case sqlite3.ErrBusy, sqlite3.ErrLocked:
+		return http.StatusServiceUnavailable
+	}
+	switch serr.ExtendedCode {
+	case sqlite3.ErrConstraintUnique,
+		sqlite3.ErrConstraintPrimaryKey,
+		sqlite3.ErrConstraintForeignKey:
+		return http.StatusConflict
+	}
+	msg := serr.Error()
+	for _, cm := range conflictMessages {
+		if strings.Contains(msg, cm) {
+			return http.StatusConflict
+		}
+	}
+	return badStat
+}
+
+// execNWithoutFKs() is like execN(), except that foreign key
+// constraints are not enforced while the commands run, and are
+// checked afterward, before the commit, for the table tabName that
+// the commands change and the tables that refer to it.  this is the
+// procedure that sqlite recommends for schema changes, so that
+// dropping a table that is to be replaced does not delete or orphan
+// the records that refer to it.
+func execNWithoutFKs(db dbType, tabName string, cmdList ...*xCmd) error {
+	ctx := context.Background()
+	conn, err := db.handle.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close() // nolint
+
+	// the pragma has no effect inside a transaction.
+	_, err = conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF")
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON") // nolint
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for i, xCmd := range cmdList {
+		log.Debugf("cmd%d = %s", i, xCmd)
+		_, err = tx.Exec(xCmd.cmd, xCmd.args...)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	err = checkForeignKeys(tx, tabName)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err == nil {
+		notifyChanges()
+	}
+	return err
+}
+
+// checkForeignKeys() returns an error if any record of the given
+// table, or of a table that refers to it, has a foreign key that
+// refers to no record.  only those tables are checked, since
+// checking the whole database could take much longer.
+// the error is a conflict that names the table and the record.
+func checkForeignKeys(tx *sql.Tx, tabName string) error {
+	rows, err := tx.Query(`SELECT m.name FROM sqlite_master m,
+		pragma_foreign_key_list(m.name) f
+		WHERE m.type = 'table' AND f."table" = ? COLLATE NOCASE
+		AND m.name <> ? COLLATE NOCASE`, tabName, tabName)
+	if err != nil {
+		return err
+	}
+	tables := []string{tabName}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			break
+		}
+		tables = append(tables, name)
+	}
+	rows.Close() // nolint
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		return err
+	}
+	for _, name := range tables {
+		err = checkTableForeignKeys(tx, name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkTableForeignKeys() returns an error if any record of the given
+// table has a foreign key that refers to no record.
+func checkTableForeignKeys(tx *sql.Tx, tabName string) error {
+	rows, err := tx.Query("PRAGMA foreign_key_check(" +
+		quoteIdent(tabName) + ")")
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint
+	if rows.Next() {
+		var table, parent string
+		var rowid sql.NullInt64
+		var fkid int64
+		err = rows.Scan(&table, &rowid, &parent, &fkid)
+		if err != nil {
+			return err
+		}
+		return statusError{http.StatusConflict,
+			fmt.Errorf("FOREIGN KEY constraint failed: record %d of %s refers to no record of %s",
+				rowid.Int64, table, parent)}
+	}
+	return rows.Err()
 }
@@ -1,9 +1,13 @@
 package apidCRUD
 
 import (
+	"testing"
 	"fmt"
 	"os"
+	"net/http"
 	"database/sql"
+	"sync"
+	"github.com/mattn/go-sqlite3"
 )
 
 const ut_DBNAME = "unit-test.db"
@@ -23,6 +27,9 @@ var cmds = []string {
 	`insert into _tables_ (name,schema) values ("bundles", "bundles_schema")`,
 	`insert into _tables_ (name,schema) values ("users", "users_schema")`,
 	`insert into _tables_ (name,schema) values ("nothing", "nothing_schema")`,
+	`insert into _tables_ (name,schema) values ("xxx", "xxx_schema")`,
+	`insert into _tables_ (name,schema) values ("toomany", "toomany_schema")`,
+	`insert into _tables_ (name,schema) values ("typed", "typed_schema")`,
 
 	// create the table bundles
 	`create table bundles(id integer not null primary key autoincrement, name text not null, uri text not null)`,
@@ -30,6 +37,10 @@ var cmds = []string {
 	`insert into bundles (name, uri) values ("b2", "http://localhost/~dfong/bundles/b2.zip")`,
 	`insert into bundles (name, uri) values ("b3", "http://localhost/~dfong/bundles/b3.zip")`,
 
+	// create the table users
+	`create table users(id integer not null primary key autoincrement, name text not null)`,
+	`insert into users (name) values ("u1")`,
+
 	// create a scratch table xxx
 	`create table xxx(id integer not null primary key autoincrement, name text not null, uri text not null)`,
 	`insert into xxx (name, uri) values ("x1", "url1")`,
@@ -58,6 +69,11 @@ var cmds = []string {
 	`insert into toomany (name, uri) values ("x15", "url15")`,
 	`insert into toomany (name, uri) values ("x16", "url16")`,
 
+	// create a table typed for testing conversion of typed values
+	`create table typed(id integer not null primary key autoincrement, i integer, r real, t text, b blob, f boolean, d datetime)`,
+	`insert into typed (i, r, t, b, f, d) values (1, 2.5, "x", x'010203', 1, "2017-03-04 05:06:07")`,
+	`insert into typed (i, r, t, b, f, d) values (NULL, 3, "", NULL, 0, NULL)`,
+
 	// create a table badschema
 	`create table _badtables_(id integer not null primary key autoincrement, name text not null, schema real)`,
 	`insert into _badtables_ (name, schema) values ("bundles", 123)`,
@@ -80,5 +96,144 @@ func createDbData(db dbType) {
 func mkBadDb() dbType {
 	h, _ := sql.Open(dbDriver, dbName)
 	_ = h.Close()
-	return dbType{h}
+	return dbType{handle: h}
+}
+
+// ----- unit tests for dbDSN()
+
+// inputs and outputs for one dbDSN testcase.
+type dbDSN_TC struct {
+	name string
+	xdsn string
+}
+
+// table of dbDSN testcases.
+var dbDSN_Tab = []dbDSN_TC {
+	{"x.db", "x.db?_foreign_keys=1&_busy_timeout=5000&_txlock=immediate"},
+	{"file:x.db?mode=ro",
+		"file:x.db?mode=ro&_foreign_keys=1&_busy_timeout=5000&_txlock=immediate"},
+}
+
+// the dbDSN test suite.
+func Test_dbDSN(t *testing.T) {
+	cx := newTestContext(t, "dbDSN_Tab")
+	for _, tc := range dbDSN_Tab {
+		cx.assertEqual(tc.xdsn, dbDSN(tc.name), "dsn")
+		cx.bump()
+	}
+}
+
+// ----- unit tests for dbErrorStatus()
+
+// inputs and outputs for one dbErrorStatus testcase.
+type dbErrorStatus_TC struct {
+	cmd string
+	xcode int
+}
+
+// table of dbErrorStatus testcases.
+var dbErrorStatus_Tab = []dbErrorStatus_TC {
+	{`insert into bundles (id, name, uri) values (1, "b", "u")`,
+		http.StatusConflict},
+	{`insert into _tables_ (name, schema) values ("bundles", "x")`,
+		http.StatusConflict},
+	{`insert into bundles (name) values ("b")`,
+		http.StatusBadRequest},
+	{`insert into nosuchtable (name) values ("b")`,
+		http.StatusBadRequest},
+}
+
+// the dbErrorStatus test suite.
+func Test_dbErrorStatus(t *testing.T) {
+	cx := newTestContext(t, "dbErrorStatus_Tab")
+	for _, tc := range dbErrorStatus_Tab {
+		_, err := db.handle.Exec(tc.cmd)
+		if cx.assertTrue(err != nil, "Exec should fail") {
+			cx.assertEqual(tc.xcode, dbErrorStatus(err), "status")
+		}
+		cx.bump()
+	}
+	cx.assertEqual(http.StatusBadRequest,
+		dbErrorStatus(fmt.Errorf("x")), "status of non-db error")
+	_, err := db.handle.Exec(dbErrorStatus_Tab[0].cmd)
+	cx.assertEqual(http.StatusConflict,
+		dbErrorStatus(recordError{1, err}), "status of recordError")
+	cx.assertEqual(http.StatusNotFound,
+		dbErrorStatus(recordError{1,
+			statusError{http.StatusNotFound, fmt.Errorf("x")}}),
+		"status of statusError")
+	cx.assertEqual(http.StatusServiceUnavailable,
+		dbErrorStatus(sqlite3.Error{Code: sqlite3.ErrBusy}),
+		"status of busy error")
+}
+
+// ----- unit tests for withTx()
+
+// the withTx test suite.  a failing function rolls back.
+func Test_withTx(t *testing.T) {
+	cx := newTestContext(t)
+	cmd := `insert into xxx (name, uri) values ("tx1", "urltx1")`
+	query := `select count(*) from xxx where name = "tx1"`
+	err := withTx(db, func(txdb dbType) error {
+		_, err := txdb.runner().Exec(cmd)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("fail on purpose")
+	})
+	cx.assertTrue(err != nil, "withTx should fail")
+	var n int
+	err = db.runner().QueryRow(query).Scan(&n)
+	cx.assertErrorNil(err, "count after rollback")
+	cx.assertEqual(0, n, "count after rollback")
+
+	err = withTx(db, func(txdb dbType) error {
+		_, err := txdb.runner().Exec(cmd)
+		return err
+	})
+	cx.assertErrorNil(err, "withTx")
+	_, err = db.runner().Exec(`delete from xxx where name = "tx1"`)
+	cx.assertErrorNil(err, "cleanup")
+
+	err = withTx(mkBadDb(), func(txdb dbType) error {
+		return nil
+	})
+	cx.assertTrue(err != nil, "withTx on bad db should fail")
+}
+
+// concurrent transactions that read and then write wait for each
+// other, rather than failing as busy.
+func Test_withTx_concurrent(t *testing.T) {
+	cx := newTestContext(t)
+	defer db.runner().Exec(`delete from xxx where name = "txc"`) // nolint
+	const N = 8
+	var wg sync.WaitGroup
+	errs := make([]error, N)
+	for i := 0; i < N; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = withTx(db, func(txdb dbType) error {
+				var n int
+				err := txdb.runner().QueryRow(
+					`select count(*) from xxx where name = "txc"`).Scan(&n)
+				if err != nil {
+					return err
+				}
+				_, err = txdb.runner().Exec(
+					`insert into xxx (name, uri) values ("txc", ?)`,
+					fmt.Sprintf("u%d", n))
+				return err
+			})
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		cx.assertErrorNil(err, fmt.Sprintf("withTx %d", i))
+	}
+	var n int
+	err := db.runner().QueryRow(
+		`select count(distinct uri) from xxx where name = "txc"`).Scan(&n)
+	cx.assertErrorNil(err, "count")
+	cx.assertEqual(N, n, "distinct counts seen")
 }
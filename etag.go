@@ -0,0 +1,191 @@
+package apidCRUD
+
+// this module implements entity tags for records, for optimistic
+// concurrency control.  the etag of a record is a hash of the values
+// of all its fields, so it changes whenever the record does, no matter
+// which apid instance changed it, and no extra column is needed.
+// a client that read a record can make a change conditional on the
+// record being unchanged since, with an If-Match header.  on a request
+// that writes several records, the headers apply to each of them,
+// and the request fails if any of them does not satisfy them.
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// etagLen is the number of bytes of the hash used in an etag.
+const etagLen = 8
+
+// recordEtag() returns the etag for a record with the given values,
+// which should be those of all the record's fields.
+func recordEtag(values []interface{}) string {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf(`"%x"`, sum[:etagLen])
+}
+
+// rowEtags() returns the etags of the records with the given ids,
+// as a map from id to etag.  records that do not exist, or are
+// hidden by soft deletion, are not in the map.
+func rowEtags(db dbType,
+	params map[string]string,
+	ids []int64) (map[int64]string, error) {
+	ret := map[int64]string{}
+	if len(ids) == 0 {
+		return ret, nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	idfield := idFieldName(params)
+	where := "WHERE " + quoteIdent(idfield) + " in (" +
+		nstring("?", len(args)) + ")"
+	q := newSQL("SELECT ").ident(idfield).
+		sql(",* FROM ").ident(params["table_name"]).
+		clause(andSoftDelete(where, params), args)
+	result, err := runQuery(db, "", q.String(), q.args)
+	if err != nil {
+		return ret, err
+	}
+	for _, rec := range result {
+		ret[rec.id] = recordEtag(rec.Values[1:])
+	}
+	return ret, nil
+}
+
+// setEtags() sets the Etag of each of the given records.
+func setEtags(db dbType,
+	params map[string]string,
+	recs []*KVResponse) error {
+	ids := make([]int64, len(recs))
+	for i, rec := range recs {
+		ids[i] = rec.id
+	}
+	etags, err := rowEtags(db, params, ids)
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		rec.Etag = etags[rec.id]
+	}
+	return nil
+}
+
+// preconditions are the conditional request headers of a request
+// that writes records.
+type preconditions struct {
+	ifMatch string
+	ifNoneMatch string
+}
+
+// getPreconditions() returns the conditional request headers
+// of the given request.
+func getPreconditions(harg *apiHandlerArg) preconditions {
+	return preconditions{harg.req.Header.Get("If-Match"),
+		harg.req.Header.Get("If-None-Match")}
+}
+
+// setEtagHeader() sets the ETag header of the response to the
+// current etag of the record with the given id, if it exists.
+func setEtagHeader(harg *apiHandlerArg,
+	db dbType,
+	params map[string]string,
+	id int64) error {
+	etags, err := rowEtags(db, params, []int64{id})
+	if err == nil && etags[id] != "" {
+		harg.setHeader("ETag", etags[id])
+	}
+	return err
+}
+
+// etagListHas() tells whether the given etag is in the given
+// list of etags from a conditional request header.
+// "*" matches any etag.  weak etags match by their opaque part.
+func etagListHas(list string, etag string) bool {
+	for _, e := range strings.Split(list, ",") {
+		e = strings.TrimPrefix(strings.TrimSpace(e), "W/")
+		if e == "*" || e == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPreconditions() checks the conditional request headers
+// against the current state of the record with the given id.
+// if a condition is false, it returns a statusError with status 412.
+// If-Match is false if the record does not exist, or if it does not
+// have one of the given etags.  If-None-Match is false if the record
+// exists and has one of the given etags, or if it exists and the
+// value is "*".
+func checkPreconditions(db dbType,
+	params map[string]string,
+	pre preconditions,
+	id int64) error {
+	if pre.ifMatch == "" && pre.ifNoneMatch == "" {
+		return nil
+	}
+	etags, err := rowEtags(db, params, []int64{id})
+	if err != nil {
+		return err
+	}
+	etag, exists := etags[id]
+	return pre.check(etag, exists, "the record")
+}
+
+// checkEachPrecondition() is like checkPreconditions(), for each of
+// the records with the given ids, all of which should exist.  the 412
+// error names the records that do not satisfy the conditions.
+func checkEachPrecondition(db dbType,
+	params map[string]string,
+	pre preconditions,
+	ids []int64) error {
+	if pre.ifMatch == "" && pre.ifNoneMatch == "" {
+		return nil
+	}
+	etags, err := rowEtags(db, params, ids)
+	if err != nil {
+		return err
+	}
+	failed := []string{}
+	var ferr error
+	for _, id := range ids {
+		etag, exists := etags[id]
+		if err := pre.check(etag, exists, "a record"); err != nil {
+			failed = append(failed, strconv.FormatInt(id, 10))
+			ferr = err
+		}
+	}
+	if ferr != nil {
+		return statusError{http.StatusPreconditionFailed,
+			fmt.Errorf("%s: %s %s",
+				ferr.(statusError).err, idFieldName(params),
+				strings.Join(failed, ","))}
+	}
+	return nil
+}
+
+// check() returns a statusError with status 412 if the conditions
+// are false for a record with the given etag, if it exists.
+// what describes the record in the error message.
+func (pre preconditions) check(etag string, exists bool, what string) error {
+	if pre.ifMatch != "" && !(exists && etagListHas(pre.ifMatch, etag)) {
+		return statusError{http.StatusPreconditionFailed,
+			fmt.Errorf("If-Match does not match %s", what)}
+	}
+	if pre.ifNoneMatch != "" && exists &&
+		etagListHas(pre.ifNoneMatch, etag) {
+		return statusError{http.StatusPreconditionFailed,
+			fmt.Errorf("If-None-Match matches %s", what)}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package apidCRUD
+
+import (
+	"testing"
+	"net/http"
+)
+
+// ----- unit tests for recordEtag()
+
+// the recordEtag test suite.
+func Test_recordEtag(t *testing.T) {
+	cx := newTestContext(t)
+	e1 := recordEtag([]interface{}{int64(1), "a"})
+	cx.assertEqual(18, len(e1), "etag length")
+	cx.assertEqual(e1, recordEtag([]interface{}{int64(1), "a"}),
+		"etag of same values")
+	cx.assertTrue(e1 != recordEtag([]interface{}{int64(1), "b"}),
+		"etag of different values")
+	cx.assertEqual("", recordEtag([]interface{}{make(chan int)}),
+		"etag of unmarshalable values")
+}
+
+// ----- unit tests for etagListHas()
+
+// inputs and outputs for one etagListHas testcase.
+type etagListHas_TC struct {
+	list string
+	etag string
+	xres bool
+}
+
+// table of etagListHas testcases.
+var etagListHas_Tab = []etagListHas_TC {
+	{`"a"`, `"a"`, true},
+	{`"b", "a"`, `"a"`, true},
+	{`W/"a"`, `"a"`, true},
+	{`*`, `"a"`, true},
+	{`"b"`, `"a"`, false},
+	{`a`, `"a"`, false},
+}
+
+// the etagListHas test suite.
+func Test_etagListHas(t *testing.T) {
+	cx := newTestContext(t, "etagListHas_Tab")
+	for _, tc := range etagListHas_Tab {
+		cx.assertEqual(tc.xres, etagListHas(tc.list, tc.etag), tc.list)
+		cx.bump()
+	}
+}
+
+// ----- unit tests for conditional requests
+
+// callWithHeader() calls the given handler with a request that has
+// the given header, and returns the result and the handler arg,
+// which holds the response headers.
+func callWithHeader(hf apiHandler,
+		verb string,
+		desc string,
+		name string,
+		value string) (apiHandlerRet, *apiHandlerArg) {
+	harg := parseHandlerArg(verb, desc)
+	if name != "" {
+		harg.req.Header.Set(name, value)
+	}
+	return hf(harg), harg
+}
+
+// the conditional request test suite.
+func Test_conditionalRequests(t *testing.T) {
+	cx := newTestContext(t)
+	setup := []apiCall_TC {
+		{"setup: create table ET",
+			createDbTableHandler,
+			http.MethodPost,
+			`/test/db/_schema/ET|table_name=ET||{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"}]}`,
+			http.StatusCreated, noCheck},
+		{"create record",
+			createDbRecordsHandler,
+			http.MethodPost,
+			`/test/db/_table/ET|table_name=ET||{"records":[{"keys":["name"],"values":["a"]}]}`,
+			http.StatusCreated, noCheck},
+	}
+	for _, tc := range setup {
+		apiCall_Checker(cx, &tc)
+	}
+	defer callApiHandler(deleteDbTableHandler, http.MethodDelete,
+		`/test/db/_schema/ET|table_name=ET`)
+
+	res, harg := callWithHeader(getDbRecordHandler, http.MethodGet,
+		`/test/db/_table/ET|table_name=ET&id=1`, "", "")
+	cx.assertEqual(http.StatusOK, res.code, "get record")
+	e1 := harg.header.Get("ETag")
+	cx.assertEqual(18, len(e1), "ETag header of get")
+	resp, ok := res.data.(RecordsResponse)
+	if cx.assertTrue(ok, "get data type") {
+		cx.assertEqual(e1, resp.Records[0].Etag, "etag in record")
+	}
+
+	patch := `/test/db/_table/ET|table_name=ET&id=1||{"records":[{"keys":["name"],"values":["b"]}]}`
+	res, _ = callWithHeader(updateDbRecordHandler, http.MethodPatch,
+		patch, "If-Match", `"bogus"`)
+	cx.assertEqual(http.StatusPreconditionFailed, res.code,
+		"patch w/ wrong etag")
+
+	res, harg = callWithHeader(updateDbRecordHandler, http.MethodPatch,
+		patch, "If-Match", e1)
+	cx.assertEqual(http.StatusOK, res.code, "patch w/ current etag")
+	e2 := harg.header.Get("ETag")
+	cx.assertTrue(e2 != "" && e2 != e1, "ETag header of patch")
+
+	res, _ = callWithHeader(updateDbRecordHandler, http.MethodPatch,
+		patch, "If-Match", e1)
+	cx.assertEqual(http.StatusPreconditionFailed, res.code,
+		"patch w/ stale etag")
+
+	// on a collection, the headers apply to each record.
+	res, _ = callWithHeader(updateDbRecordsHandler, http.MethodPatch,
+		`/test/db/_table/ET|table_name=ET|ids=1|{"records":[{"keys":["name"],"values":["c"]}]}`,
+		"If-Match", e1)
+	cx.assertEqual(http.StatusPreconditionFailed, res.code,
+		"patch collection w/ stale etag")
+	rdata, _ := convData(res.data)
+	cx.assertEqual(`{"code":412,"message":"If-Match does not match a record: id 1","kind":"ErrorResponse"}`,
+		string(rdata), "error of patch collection w/ stale etag")
+	res, _ = callWithHeader(updateDbRecordsHandler, http.MethodPatch,
+		`/test/db/_table/ET|table_name=ET||{"records":[{"keys":["id","name"],"values":[1,"c"]}]}`,
+		"If-Match", e1)
+	cx.assertEqual(http.StatusPreconditionFailed, res.code,
+		"patch per-record bodies w/ stale etag")
+	res, _ = callWithHeader(replaceDbRecordsHandler, http.MethodPut,
+		`/test/db/_table/ET|table_name=ET||{"records":[{"keys":["id","name"],"values":[1,"c"]}]}`,
+		"If-Match", e1)
+	cx.assertEqual(http.StatusPreconditionFailed, res.code,
+		"put collection w/ stale etag")
+	res, _ = callWithHeader(deleteDbRecordsHandler, http.MethodDelete,
+		`/test/db/_table/ET|table_name=ET|ids=1`, "If-Match", e1)
+	cx.assertEqual(http.StatusPreconditionFailed, res.code,
+		"delete collection w/ stale etag")
+	res, _ = callWithHeader(updateDbRecordsHandler, http.MethodPatch,
+		`/test/db/_table/ET|table_name=ET|ids=1|{"records":[{"keys":["name"],"values":["c"]}]}`,
+		"If-Match", e2)
+	cx.assertEqual(http.StatusOK, res.code, "patch collection w/ etag")
+	res, harg = callWithHeader(getDbRecordHandler, http.MethodGet,
+		`/test/db/_table/ET|table_name=ET&id=1`, "", "")
+	e3 := harg.header.Get("ETag")
+	cx.assertTrue(e3 != "" && e3 != e2, "ETag after patch collection")
+
+	res, _ = callWithHeader(replaceDbRecordHandler, http.MethodPut,
+		`/test/db/_table/ET|table_name=ET&id=1|create_if_missing=true|{"records":[{"keys":["name"],"values":["d"]}]}`,
+		"If-None-Match", "*")
+	cx.assertEqual(http.StatusPreconditionFailed, res.code,
+		"put existing record w/ If-None-Match *")
+
+	res, harg = callWithHeader(replaceDbRecordHandler, http.MethodPut,
+		`/test/db/_table/ET|table_name=ET&id=5|create_if_missing=true|{"records":[{"keys":["name"],"values":["e"]}]}`,
+		"If-None-Match", "*")
+	cx.assertEqual(http.StatusCreated, res.code,
+		"put new record w/ If-None-Match *")
+	cx.assertTrue(harg.header.Get("ETag") != "", "ETag header of put")
+
+	res, _ = callWithHeader(deleteDbRecordHandler, http.MethodDelete,
+		`/test/db/_table/ET|table_name=ET&id=1`, "If-Match", e2)
+	cx.assertEqual(http.StatusPreconditionFailed, res.code,
+		"delete w/ stale etag")
+
+	res, _ = callWithHeader(deleteDbRecordHandler, http.MethodDelete,
+		`/test/db/_table/ET|table_name=ET&id=1`, "If-Match", e3)
+	cx.assertEqual(http.StatusOK, res.code, "delete w/ current etag")
+
+	res, _ = callWithHeader(deleteDbRecordHandler, http.MethodDelete,
+		`/test/db/_table/ET|table_name=ET&id=1`, "If-Match", "*")
+	cx.assertEqual(http.StatusPreconditionFailed, res.code,
+		"delete missing record w/ If-Match *")
+}
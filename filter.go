@@ -0,0 +1,570 @@
+package apidCRUD
+
+// this module implements the filter expression language accepted by
+// the "filter" parameter.  a filter is parsed into an AST,
+// the AST can be checked against a table's columns,
+// and then compiled into a parameterized WHERE condition.
+//
+// grammar (keywords are case-insensitive):
+//	filter    := orExpr
+//	orExpr    := andExpr { OR andExpr }
+//	andExpr   := notExpr { AND notExpr }
+//	notExpr   := NOT notExpr | primary
+//	primary   := '(' orExpr ')' | predicate
+//	predicate := IDENT cmpOp literal
+//	           | IDENT [NOT] LIKE STRING
+//	           | IDENT [NOT] IN '(' literal { ',' literal } ')'
+//	           | IDENT IS [NOT] NULL
+//	cmpOp     := '=' | '!=' | '<>' | '<' | '<=' | '>' | '>='
+//	literal   := STRING | NUMBER
+// strings are enclosed in single quotes; a quote is doubled to escape it.
+//
+// the values of a datetime field are returned in RFC 3339 format,
+// whatever format they are stored in, so a value taken from a response
+// may not match the stored value as a string.  a comparison of such a
+// field, other than LIKE, compares both sides as normalized times.
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// maxFilterDepth limits the nesting of parentheses and NOTs in a filter.
+const maxFilterDepth = 32
+
+// timeTypes are the declared types of the columns whose values
+// the sqlite driver returns as times.
+var timeTypes = map[string]bool {
+	"date": true,
+	"datetime": true,
+	"timestamp": true,
+}
+
+// ----- types for the filter AST
+
+// filterNode is a node of a parsed filter expression.
+type filterNode interface {
+	// compile writes the SQL for this node to buf,
+	// and returns args with this node's values appended.
+	compile(buf *bytes.Buffer, args []interface{}) []interface{}
+
+	// fieldNames returns names with this node's field names appended.
+	fieldNames(names []string) []string
+}
+
+// filterBool is an AND or OR of two subexpressions.
+type filterBool struct {
+	op string
+	left filterNode
+	right filterNode
+}
+
+// filterNot is the negation of a subexpression.
+type filterNot struct {
+	expr filterNode
+}
+
+// filterCmp compares a field to a single value.
+// op is one of the comparison operators, or LIKE or NOT LIKE.
+// time is true if the field is a datetime field.
+type filterCmp struct {
+	field string
+	op string
+	value interface{}
+	time bool
+}
+
+// filterIn tests a field for membership in a list of values.
+// time is true if the field is a datetime field.
+type filterIn struct {
+	field string
+	not bool
+	values []interface{}
+	time bool
+}
+
+// filterNull tests a field for NULL.
+type filterNull struct {
+	field string
+	not bool
+}
+
+func (n *filterBool) compile(buf *bytes.Buffer, args []interface{}) []interface{} {
+	buf.WriteString("(")
+	args = n.left.compile(buf, args)
+	buf.WriteString(" " + n.op + " ")
+	args = n.right.compile(buf, args)
+	buf.WriteString(")")
+	return args
+}
+
+func (n *filterBool) fieldNames(names []string) []string {
+	return n.right.fieldNames(n.left.fieldNames(names))
+}
+
+func (n *filterNot) compile(buf *bytes.Buffer, args []interface{}) []interface{} {
+	buf.WriteString("NOT (")
+	args = n.expr.compile(buf, args)
+	buf.WriteString(")")
+	return args
+}
+
+func (n *filterNot) fieldNames(names []string) []string {
+	return n.expr.fieldNames(names)
+}
+
+func (n *filterCmp) compile(buf *bytes.Buffer, args []interface{}) []interface{} {
+	if n.time && !strings.HasSuffix(n.op, "LIKE") {
+		buf.WriteString(timeExpr(quoteIdent(n.field)) + " " + n.op +
+			" " + timeExpr("?"))
+	} else {
+		buf.WriteString(quoteIdent(n.field) + " " + n.op + " ?")
+	}
+	return append(args, n.value)
+}
+
+func (n *filterCmp) fieldNames(names []string) []string {
+	return append(names, n.field)
+}
+
+func (n *filterIn) compile(buf *bytes.Buffer, args []interface{}) []interface{} {
+	item := "?"
+	if n.time {
+		buf.WriteString(timeExpr(quoteIdent(n.field)))
+		item = timeExpr("?")
+	} else {
+		buf.WriteString(quoteIdent(n.field))
+	}
+	if n.not {
+		buf.WriteString(" NOT")
+	}
+	buf.WriteString(" IN (" + nstring(item, len(n.values)) + ")")
+	return append(args, n.values...)
+}
+
+func (n *filterIn) fieldNames(names []string) []string {
+	return append(names, n.field)
+}
+
+func (n *filterNull) compile(buf *bytes.Buffer, args []interface{}) []interface{} {
+	if n.not {
+		buf.WriteString(quoteIdent(n.field) + " IS NOT NULL")
+	} else {
+		buf.WriteString(quoteIdent(n.field) + " IS NULL")
+	}
+	return args
+}
+
+func (n *filterNull) fieldNames(names []string) []string {
+	return append(names, n.field)
+}
+
+// ----- the filter tokenizer
+
+// kinds of filter tokens.
+const (
+	tokEOF = iota
+	tokIdent
+	tokKeyword
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+// filterToken is one lexical item of a filter expression.
+type filterToken struct {
+	kind int
+	text string		// keywords are upper-cased
+	value interface{}	// for tokString and tokNumber
+	pos int
+}
+
+// filterKeywords is the set of reserved words in the filter language.
+var filterKeywords = map[string]bool {
+	"AND": true,
+	"OR": true,
+	"NOT": true,
+	"LIKE": true,
+	"IN": true,
+	"IS": true,
+	"NULL": true,
+}
+
+// tokenizeFilter() breaks up the given filter string into a list of tokens.
+// the last token in the list is always of kind tokEOF.
+func tokenizeFilter(s string) ([]filterToken, error) {
+	ret := []filterToken{}
+	i := 0
+	N := len(s)
+	for i < N {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			ret = append(ret, filterToken{tokLParen, "(", nil, i})
+			i++
+		case c == ')':
+			ret = append(ret, filterToken{tokRParen, ")", nil, i})
+			i++
+		case c == ',':
+			ret = append(ret, filterToken{tokComma, ",", nil, i})
+			i++
+		case c == '=':
+			ret = append(ret, filterToken{tokOp, "=", nil, i})
+			i++
+		case c == '!' || c == '<' || c == '>':
+			op := string(c)
+			if i+1 < N && (s[i+1] == '=' || (c == '<' && s[i+1] == '>')) {
+				op = s[i:i+2]
+			}
+			if op == "!" {
+				return ret, fmt.Errorf("filter: bad operator at %d", i)
+			}
+			ret = append(ret, filterToken{tokOp, op, nil, i})
+			i += len(op)
+		case c == '\'':
+			str, n, err := scanFilterString(s[i:])
+			if err != nil {
+				return ret, fmt.Errorf("filter: %s at %d", err, i)
+			}
+			ret = append(ret, filterToken{tokString, s[i:i+n], str, i})
+			i += n
+		case c == '-' || c == '.' || (c >= '0' && c <= '9'):
+			tok, n, err := scanFilterNumber(s[i:])
+			if err != nil {
+				return ret, fmt.Errorf("filter: %s at %d", err, i)
+			}
+			tok.pos = i
+			ret = append(ret, tok)
+			i += n
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i
+			for j < N && !notIdentChar(rune(s[j])) {
+				j++
+			}
+			word := s[i:j]
+			upper := strings.ToUpper(word)
+			if filterKeywords[upper] {
+				ret = append(ret, filterToken{tokKeyword, upper, nil, i})
+			} else {
+				ret = append(ret, filterToken{tokIdent, word, nil, i})
+			}
+			i = j
+		default:
+			return ret, fmt.Errorf("filter: unexpected character %q at %d",
+				c, i)
+		}
+	}
+	ret = append(ret, filterToken{tokEOF, "", nil, N})
+	return ret, nil
+}
+
+// scanFilterString() scans a quoted string at the start of s.
+// it returns the unquoted value and the number of bytes consumed.
+func scanFilterString(s string) (string, int, error) {
+	var buf bytes.Buffer
+	N := len(s)
+	for i := 1; i < N; i++ {
+		if s[i] != '\'' {
+			buf.WriteByte(s[i])
+			continue
+		}
+		if i+1 < N && s[i+1] == '\'' {
+			buf.WriteByte('\'')
+			i++
+			continue
+		}
+		return buf.String(), i+1, nil
+	}
+	return "", N, fmt.Errorf("unterminated string")
+}
+
+// scanFilterNumber() scans a numeric literal at the start of s.
+// integers become int64 values, others become float64.
+func scanFilterNumber(s string) (filterToken, int, error) {
+	n := 0
+	N := len(s)
+	if s[0] == '-' {
+		n++
+	}
+	for n < N && strings.IndexByte("0123456789.eE", s[n]) >= 0 {
+		if (s[n] == 'e' || s[n] == 'E') && n+1 < N &&
+				(s[n+1] == '-' || s[n+1] == '+') {
+			n++
+		}
+		n++
+	}
+	text := s[:n]
+	if i, err := strconv.ParseInt(text, idTypeRadix, idTypeBits); err == nil {
+		return filterToken{tokNumber, text, i, 0}, n, nil
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return filterToken{}, n, fmt.Errorf("bad number %s", text)
+	}
+	return filterToken{tokNumber, text, f, 0}, n, nil
+}
+
+// ----- the filter parser
+
+// filterParser holds the state of a recursive-descent parse.
+type filterParser struct {
+	toks []filterToken
+	pos int
+	depth int
+}
+
+// parseFilter() parses the given filter string into an AST.
+// an empty (or all blank) filter string yields a nil node.
+func parseFilter(s string) (filterNode, error) {
+	toks, err := tokenizeFilter(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{toks, 0, 0}
+	if p.peek().kind == tokEOF {
+		return nil, nil
+	}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf(tok, "unexpected %s", tok.text)
+	}
+	return node, nil
+}
+
+// peek() returns the current token without consuming it.
+func (p *filterParser) peek() filterToken {
+	return p.toks[p.pos]
+}
+
+// next() consumes and returns the current token.
+func (p *filterParser) next() filterToken {
+	tok := p.toks[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// isKeyword() returns true iff the current token is the given keyword.
+func (p *filterParser) isKeyword(kw string) bool {
+	tok := p.peek()
+	return tok.kind == tokKeyword && tok.text == kw
+}
+
+// errorf() returns an error describing a problem at the given token.
+func (p *filterParser) errorf(tok filterToken,
+		form string,
+		args ...interface{}) error {
+	if tok.kind == tokEOF {
+		return fmt.Errorf("filter: %s at end", fmt.Sprintf(form, args...))
+	}
+	return fmt.Errorf("filter: %s at %d",
+		fmt.Sprintf(form, args...), tok.pos)
+}
+
+// parseOr() parses: andExpr { OR andExpr }
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("OR") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &filterBool{"OR", left, right}
+	}
+	return left, nil
+}
+
+// parseAnd() parses: notExpr { AND notExpr }
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("AND") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &filterBool{"AND", left, right}
+	}
+	return left, nil
+}
+
+// parseNot() parses: NOT notExpr | primary
+func (p *filterParser) parseNot() (filterNode, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxFilterDepth {
+		return nil, p.errorf(p.peek(), "expression nested too deeply")
+	}
+
+	if p.isKeyword("NOT") {
+		p.next()
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &filterNot{expr}, nil
+	}
+	if p.peek().kind == tokLParen {
+		p.next()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if tok := p.next(); tok.kind != tokRParen {
+			return nil, p.errorf(tok, "expected )")
+		}
+		return expr, nil
+	}
+	return p.parsePredicate()
+}
+
+// parsePredicate() parses a single test on a field.
+func (p *filterParser) parsePredicate() (filterNode, error) {
+	tok := p.next()
+	if tok.kind != tokIdent {
+		return nil, p.errorf(tok, "expected field name")
+	}
+	field := tok.text
+
+	tok = p.next()
+	switch {
+	case tok.kind == tokOp:
+		val, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		return &filterCmp{field, tok.text, val, false}, nil
+	case tok.kind == tokKeyword && tok.text == "IS":
+		not := false
+		if p.isKeyword("NOT") {
+			p.next()
+			not = true
+		}
+		if !p.isKeyword("NULL") {
+			return nil, p.errorf(p.peek(), "expected NULL")
+		}
+		p.next()
+		return &filterNull{field, not}, nil
+	case tok.kind == tokKeyword && tok.text == "NOT":
+		tok = p.next()
+		if tok.kind == tokKeyword && tok.text == "LIKE" {
+			return p.parseLike(field, "NOT LIKE")
+		}
+		if tok.kind == tokKeyword && tok.text == "IN" {
+			return p.parseIn(field, true)
+		}
+		return nil, p.errorf(tok, "expected LIKE or IN")
+	case tok.kind == tokKeyword && tok.text == "LIKE":
+		return p.parseLike(field, "LIKE")
+	case tok.kind == tokKeyword && tok.text == "IN":
+		return p.parseIn(field, false)
+	}
+	return nil, p.errorf(tok, "expected operator after %s", field)
+}
+
+// parseLike() parses the pattern operand of a LIKE test.
+func (p *filterParser) parseLike(field string, op string) (filterNode, error) {
+	tok := p.next()
+	if tok.kind != tokString {
+		return nil, p.errorf(tok, "expected string after %s", op)
+	}
+	return &filterCmp{field, op, tok.value, false}, nil
+}
+
+// parseIn() parses the parenthesized list of values of an IN test.
+func (p *filterParser) parseIn(field string, not bool) (filterNode, error) {
+	if tok := p.next(); tok.kind != tokLParen {
+		return nil, p.errorf(tok, "expected (")
+	}
+	values := []interface{}{}
+	for {
+		val, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, val)
+		tok := p.next()
+		if tok.kind == tokRParen {
+			break
+		}
+		if tok.kind != tokComma {
+			return nil, p.errorf(tok, "expected , or )")
+		}
+	}
+	return &filterIn{field, not, values, false}, nil
+}
+
+// parseLiteral() parses a string or numeric value.
+func (p *filterParser) parseLiteral() (interface{}, error) {
+	tok := p.next()
+	if tok.kind != tokString && tok.kind != tokNumber {
+		return nil, p.errorf(tok, "expected value")
+	}
+	return tok.value, nil
+}
+
+// ----- functions that operate on a parsed filter
+
+// compileFilter() returns the SQL condition for the given filter,
+// along with the values to be bound to its placeholders.
+func compileFilter(node filterNode) (string, []interface{}) {
+	var buf bytes.Buffer
+	args := node.compile(&buf, []interface{}{})
+	return buf.String(), args
+}
+
+// markTimeFields() marks the comparisons in the filter of the given
+// datetime fields, so that they compare normalized times.
+func markTimeFields(node filterNode, fields []string) {
+	isTime := map[string]bool{}
+	for _, f := range fields {
+		isTime[f] = true
+	}
+	var mark func(node filterNode)
+	mark = func(node filterNode) {
+		switch n := node.(type) {
+		case *filterBool:
+			mark(n.left)
+			mark(n.right)
+		case *filterNot:
+			mark(n.expr)
+		case *filterCmp:
+			n.time = isTime[n.field]
+		case *filterIn:
+			n.time = isTime[n.field]
+		}
+	}
+	mark(node)
+}
+
+// timeExpr() returns the SQL expression for the given time expression,
+// normalized to UTC in the format in which apidCRUD stores times.
+// it is NULL if the value is not a time.
+func timeExpr(expr string) string {
+	return "strftime('%Y-%m-%d %H:%M:%f'," + expr + ")"
+}
+
+// validateFilterFields() checks that every field named in the filter
+// is one of the given columns.
+func validateFilterFields(node filterNode, cols []string) error {
+	return checkFields("filter", node.fieldNames([]string{}), cols)
+}
@@ -0,0 +1,135 @@
+package apidCRUD
+
+import (
+	"testing"
+	"fmt"
+	"strings"
+)
+
+// ----- unit tests for parseFilter() and compileFilter()
+
+// inputs and outputs for one parseFilter testcase.
+type parseFilter_TC struct {
+	filter string
+	xsql string
+	xargs string
+	xsucc bool
+}
+
+// table of parseFilter testcases.
+var parseFilter_Tab = []parseFilter_TC {
+	{"", "", "", true},
+	{"a = 1", "`a` = ?", "1", true},
+	{"a=1", "`a` = ?", "1", true},
+	{"a != 'x'", "`a` != ?", "x", true},
+	{"a <> -2.5", "`a` <> ?", "-2.5", true},
+	{"a<=1 and b>=2", "(`a` <= ? AND `b` >= ?)", "1,2", true},
+	{"a < 1 OR b > 2 AND c = 3", "(`a` < ? OR (`b` > ? AND `c` = ?))", "1,2,3", true},
+	{"(a < 1 OR b > 2) AND c = 3", "((`a` < ? OR `b` > ?) AND `c` = ?)", "1,2,3", true},
+	{"NOT a = 1", "NOT (`a` = ?)", "1", true},
+	{"name = 'it''s'", "`name` = ?", "it's", true},
+	{"uri LIKE 'http%'", "`uri` LIKE ?", "http%", true},
+	{"uri not like 'http%'", "`uri` NOT LIKE ?", "http%", true},
+	{"id IN (1, 2,3)", "`id` IN (?,?,?)", "1,2,3", true},
+	{"id NOT IN ('a')", "`id` NOT IN (?)", "a", true},
+	{"x IS NULL", "`x` IS NULL", "", true},
+	{"x is not null", "`x` IS NOT NULL", "", true},
+	{"name = 'foo' AND (uri LIKE 'http%' OR id > 10)",
+		"(`name` = ? AND (`uri` LIKE ? OR `id` > ?))", "foo,http%,10", true},
+	{"a =", "", "", false},
+	{"= 1", "", "", false},
+	{"a = b", "", "", false},
+	{"a = 'x", "", "", false},
+	{"a ! 1", "", "", false},
+	{"a LIKE 1", "", "", false},
+	{"a IN ()", "", "", false},
+	{"a IN (1", "", "", false},
+	{"a IS 1", "", "", false},
+	{"a NOT = 1", "", "", false},
+	{"(a = 1", "", "", false},
+	{"a = 1)", "", "", false},
+	{"a = 1 b = 2", "", "", false},
+	{"a = 1; drop table x", "", "", false},
+	{"a = 1 -- comment", "", "", false},
+	{"a = 1e", "", "", false},
+	{strings.Repeat("(", maxFilterDepth+1) + "a = 1" +
+		strings.Repeat(")", maxFilterDepth+1), "", "", false},
+}
+
+// run one testcase for function parseFilter.
+func parseFilter_Checker(cx *testContext, tc *parseFilter_TC) {
+	node, err := parseFilter(tc.filter)
+	if !cx.assertEqual(tc.xsucc, err == nil, "success") {
+		return
+	}
+	if err != nil || node == nil {
+		return
+	}
+	sql, args := compileFilter(node)
+	cx.assertEqual(tc.xsql, sql, "compiled sql")
+	strs := make([]string, len(args))
+	for i, a := range args {
+		strs[i] = fmt.Sprintf("%v", a)
+	}
+	cx.assertEqual(tc.xargs, strings.Join(strs, ","), "args")
+}
+
+// the parseFilter test suite.  run all parseFilter testcases.
+func Test_parseFilter(t *testing.T) {
+	cx := newTestContext(t, "parseFilter_Tab")
+	for _, tc := range parseFilter_Tab {
+		parseFilter_Checker(cx, &tc)
+		cx.bump()	// increment testno.
+	}
+}
+
+// ----- unit tests for markTimeFields()
+
+func Test_markTimeFields(t *testing.T) {
+	cx := newTestContext(t)
+	node, err := parseFilter("d > 'x' AND NOT (d IN ('y') OR n = 1 OR d LIKE 'z%')")
+	if !cx.assertErrorNil(err, "parseFilter") {
+		return
+	}
+	markTimeFields(node, []string{"d"})
+	sql, _ := compileFilter(node)
+	cx.assertEqual("(strftime('%Y-%m-%d %H:%M:%f',`d`) > strftime('%Y-%m-%d %H:%M:%f',?) AND NOT (((strftime('%Y-%m-%d %H:%M:%f',`d`) IN (strftime('%Y-%m-%d %H:%M:%f',?)) OR `n` = ?) OR `d` LIKE ?)))",
+		sql, "compiled sql")
+}
+
+// ----- unit tests for validateFilterFields()
+
+// inputs and outputs for one validateFilterFields testcase.
+type validateFilterFields_TC struct {
+	filter string
+	cols string
+	xsucc bool
+}
+
+// table of validateFilterFields testcases.
+var validateFilterFields_Tab = []validateFilterFields_TC {
+	{"a = 1", "a,b", true},
+	{"a = 1 OR (b IS NULL AND NOT a IN (1,2))", "a,b", true},
+	{"c = 1", "a,b", false},
+	{"a = 1 AND c LIKE 'x'", "a,b", false},
+}
+
+// run one testcase for function validateFilterFields.
+func validateFilterFields_Checker(cx *testContext,
+		tc *validateFilterFields_TC) {
+	node, err := parseFilter(tc.filter)
+	if !cx.assertErrorNil(err, "parseFilter") {
+		return
+	}
+	err = validateFilterFields(node, mySplit(tc.cols, ","))
+	cx.assertEqual(tc.xsucc, err == nil, "success")
+}
+
+// the validateFilterFields test suite.
+func Test_validateFilterFields(t *testing.T) {
+	cx := newTestContext(t, "validateFilterFields_Tab")
+	for _, tc := range validateFilterFields_Tab {
+		validateFilterFields_Checker(cx, &tc)
+		cx.bump()	// increment testno.
+	}
+}
@@ -0,0 +1,20 @@
+#! /bin/bash
+#	alttabtest.sh TABNAME CHANGES
+# add, drop, or rename fields of a table.
+# CHANGES is the json body, by default it adds a nullable field "note".
+# the API is PATCH /db/_schema/XXX aka alterDbTable
+
+# ----- start of mainline code
+PROGDIR=$(cd "$(dirname "$0")" && /bin/pwd)
+. "$PROGDIR/tester-env.sh" || exit 1
+. "$PROGDIR/test-common.sh" || exit 1
+
+if [[ $# -eq 0 ]]; then
+	echo 1>&2 "error: TABNAME must be specified on cmd line"
+	exit 1
+fi
+
+TABNAME=$1
+CHANGES=${2:-'{"add":[{"name":"note","allow_null":true}]}'}
+
+apicurl PATCH "db/_schema/$TABNAME" -d "$CHANGES"
@@ -0,0 +1,26 @@
+#! /bin/bash
+#	curtest.sh LIMIT
+# retrieve all records, LIMIT at a time, following nextCursor.
+# prints the id of each record.
+# the API is GET on /db/_table/{table_name} aka getDbRecords .
+
+# ----- start of mainline code
+PROGDIR=$(cd "$(dirname "$0")" && /bin/pwd)
+. "$PROGDIR/tester-env.sh" || exit 1
+. "$PROGDIR/test-common.sh" || exit 1
+
+FIELDS=id,name
+API_PATH=db/_table
+LIMIT=${1:-2}
+
+cursor=
+while :; do
+	out=$(apicurl GET "$API_PATH/$TABLE_NAME" -G \
+		--data-urlencode "fields=$FIELDS" \
+		--data-urlencode "limit=$LIMIT" \
+		${cursor:+--data-urlencode "cursor=$cursor"}) || exit 1
+	echo "$out" | jq -r '.records[].values[0]'
+	cursor=$(echo "$out" | jq -r '.nextCursor // empty')
+	[[ -n "$cursor" ]] || break
+done
+exit 0
@@ -0,0 +1,21 @@
+#! /bin/bash
+#	filtest.sh FILTER
+# retrieve the records matching the given filter expression.
+# the API is GET on /db/_table/{table_name} aka getDbRecords .
+
+# ----- start of mainline code
+PROGDIR=$(cd "$(dirname "$0")" && /bin/pwd)
+. "$PROGDIR/tester-env.sh" || exit 1
+. "$PROGDIR/test-common.sh" || exit 1
+
+FIELDS=id,name
+API_PATH=db/_table
+FILTER=${1:-"name = 'name1'"}
+
+out=$(apicurl GET "$API_PATH/$TABLE_NAME" -G \
+	--data-urlencode "fields=$FIELDS" \
+	--data-urlencode "filter=$FILTER")
+xstat=$?
+
+echo "$out"
+exit $xstat
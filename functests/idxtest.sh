@@ -0,0 +1,22 @@
+#! /bin/bash
+#	idxtest.sh TABNAME INDEX
+# create an index on a table, then list the table's indexes.
+# INDEX is the json body, by default an index "by_name" on field name.
+# the APIs are POST and GET /db/_schema/XXX/_index
+# aka createDbIndex and listDbIndexes
+
+# ----- start of mainline code
+PROGDIR=$(cd "$(dirname "$0")" && /bin/pwd)
+. "$PROGDIR/tester-env.sh" || exit 1
+. "$PROGDIR/test-common.sh" || exit 1
+
+if [[ $# -eq 0 ]]; then
+	echo 1>&2 "error: TABNAME must be specified on cmd line"
+	exit 1
+fi
+
+TABNAME=$1
+INDEX=${2:-'{"name":"by_name","fields":["name"]}'}
+
+apicurl POST "db/_schema/$TABNAME/_index" -d "$INDEX" > /dev/null || exit 1
+apicurl GET "db/_schema/$TABNAME/_index"
@@ -26,13 +26,13 @@ EOF
 sqlite3 "$DBFILE" <<EOF
 create table _tables_ (id integer not null primary key autoincrement, name text unique not null, schema text not null);
 insert into _tables_ (name,schema) values ("bundles",
-'{"fields":[{"name":"id",properties:["is_primary_key"]},{"name":"name"},{"name":"uri"}]}');
+'{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"},{"name":"uri"}]}');
 insert into _tables_ (name,schema) values ("users",
-'{"fields":[{"name":"id",properties:["is_primary_key"]},{"name":"name"}]}');
+'{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"}]}');
 insert into _tables_ (name,schema) values ("nothing",
-'{"fields":[{"name":"id",properties:["is_primary_key"]},{"name":"name"}]}');
+'{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"}]}');
 insert into _tables_ (name,schema) values ("file",
-'{"files":[{"name":"line"}]}');
+'{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"line","allow_null":true}]}');
 .quit
 EOF
 
@@ -0,0 +1,16 @@
+#! /bin/bash
+#	upserttest.sh ID
+# create two records with on_conflict=update, the first with the
+# given existing ID, the second new, and print the outcomes.
+# the API is POST /db/_table/{table_name} aka createDbRecords .
+
+# ----- start of mainline code
+PROGDIR=$(cd "$(dirname "$0")" && /bin/pwd)
+. "$PROGDIR/tester-env.sh" || exit 1
+. "$PROGDIR/test-common.sh" || exit 1
+
+ID=${1:-1}
+BODY="{\"records\":[{\"keys\":[\"id\",\"name\",\"uri\"],\"values\":[$ID,\"upname$ID\",\"uphost$ID\"]},{\"keys\":[\"name\",\"uri\"],\"values\":[\"upname\",\"uphost\"]}]}"
+
+apicurl POST "db/_table/$TABLE_NAME?on_conflict=update" -d "$BODY" \
+| jq -r '.results[].outcome'
@@ -10,8 +10,19 @@ import (
 )
 
 // dbType is intended to encapsulate the database handle type.
+// if tx is not nil, statements run in that transaction.
 type dbType struct {
 	handle *sql.DB
+	tx *sql.Tx
+}
+
+// dbRunner is the part of the database API that is common to
+// a database handle and a transaction.
+type dbRunner interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Prepare(query string) (*sql.Stmt, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
 }
 
 // badStat is a convenience constant, the http status for a bad request.
@@ -42,5 +53,22 @@ var basePath = "/apid"
 // maxRecs is the max number of results allowed in a bulk request.
 var maxRecs = 1000
 
+// hookAllowHosts is a comma-separated list of the hosts to which
+// webhook payloads may be delivered although they are not public,
+// such as loopback or private addresses.
+var hookAllowHosts = ""
+
 // tobleOfTables is the name of the internal table of table names/schemas
 var  tableOfTables = "_tables_"
+
+// auditTable is the name of the internal table of audited record changes
+var auditTable = "_audit_"
+
+// changesTable is the name of the internal table of the change feed
+var changesTable = "_changes_"
+
+// hooksTable is the name of the internal table of webhooks
+var hooksTable = "_hooks_"
+
+// outboxTable is the name of the internal table of webhook payloads
+var outboxTable = "_outbox_"
@@ -1,13 +1,15 @@
 package apidCRUD
 
 import (
-	"bytes"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
+	"github.com/mattn/go-sqlite3"
 )
 
 // ----- types used internally
@@ -40,9 +42,10 @@ func getDbTablesHandler(harg *apiHandlerArg) apiHandlerRet {
 
 // createDbRecordsHandler() handles POST requests on /db/_table/{table_name} .
 func createDbRecordsHandler(harg *apiHandlerArg) apiHandlerRet {
-	params, err := fetchParams(harg, "table_name")
+	params, err := fetchWriteParams(harg, "table_name", "id_field",
+		"on_conflict", "conflict_target", "atomic")
 	if err != nil {
-		return errorRet(badStat, err, "after fetchParams")
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
 	}
 
 	body, err := getBodyRecord(harg)
@@ -60,89 +63,476 @@ func createDbRecordsHandler(harg *apiHandlerArg) apiHandlerRet {
 		return apiHandlerRet{badStat, err}
 	}
 
-	for _, rec := range records {
-		id, err := runInsert(db, params["table_name"], rec.Keys, rec.Values)
+	err = setTableOptions(db, params)
+	if err != nil {
+		return errorRet(dbErrorStatus(err), err, "after setTableOptions")
+	}
+	err = validateParamFields(db, params)
+	if err != nil {
+		return errorRet(badStat, err, "after validateParamFields")
+	}
+	self := tableSelf(harg, params["table_name"])
+	if params["atomic"] == "false" {
+		return createEach(self, params, records)
+	}
+	err = validateRecordFields(db, params, records)
+	if err != nil {
+		return errorRet(badStat, err, "after validateRecordFields")
+	}
+
+	var results []RecordOutcome
+	var recs []*KVResponse
+	err = withTx(db, func(txdb dbType) error {
+		for i, rec := range records {
+			id, outcome, err := insertRecord(txdb, params, rec)
+			if err != nil {
+				return recordError{i, err}
+			}
+			idlist = append(idlist, int64(id))
+			results = append(results,
+				RecordOutcome{Id: int64(id), Outcome: outcome})
+		}
+		recs, err = fetchRecords(txdb, self, params, idlist)
+		return err
+	})
+	if err != nil {
+		return errorRet(dbErrorStatus(err), err, "after insertRecord")
+	}
+	if params["on_conflict"] == "error" {
+		results = nil
+	}
+	return apiHandlerRet{http.StatusCreated,
+		IdsResponse{Ids: idlist, Kind: "Collection", Results: results,
+			Records: recs}}
+}
+
+// createEach() is the non-atomic part of createDbRecordsHandler().
+// each record is inserted on its own, and the outcome of each is
+// returned, including the error for a record that failed.
+// the status is 207 if any record failed.
+func createEach(self string,
+	params map[string]string,
+	records []KVRecord) apiHandlerRet {
+	cols, err := tableColumns(db, params["table_name"])
+	if err != nil {
+		return errorRet(badStat, err, "after tableColumns")
+	}
+	code := http.StatusCreated
+	idlist := make([]int64, len(records))
+	results := make([]RecordOutcome, len(records))
+	for i, rec := range records {
+		var id idType
+		var outcome string
+		err := checkFields("keys", rec.Keys, cols)
+		if err == nil {
+			err = checkTimeValues(params, rec)
+		}
+		if err == nil {
+			// each record is in its own transaction, with its change.
+			err = withTx(db, func(txdb dbType) error {
+				var err error
+				id, outcome, err = insertRecord(txdb, params, rec)
+				return err
+			})
+		}
 		if err != nil {
-			return apiHandlerRet{badStat, err}
+			log.Debugf("record %d failed [%s]", i, err)
+			code = http.StatusMultiStatus
+			id, outcome = -1, "failed"
+			results[i].Error = err.Error()
 		}
-		idlist = append(idlist, int64(id))
+		idlist[i] = int64(id)
+		results[i].Id = int64(id)
+		results[i].Outcome = outcome
+	}
+	recs, err := fetchRecords(db, self, params, idlist)
+	if err != nil {
+		return errorRet(badStat, err, "after fetchRecords")
 	}
+	return apiHandlerRet{code,
+		IdsResponse{Ids: idlist, Kind: "Collection", Results: results,
+			Records: recs}}
+}
 
-	return apiHandlerRet{http.StatusCreated,
-		IdsResponse{Ids: idlist, Kind: "Collection"}}
+// recordError is an error for one record of a request,
+// identified by its index in the request.
+type recordError struct {
+	index int
+	err error
+}
+
+// Error() returns the message for a recordError.
+func (e recordError) Error() string {
+	return fmt.Sprintf("record %d: %s", e.index, e.err)
+}
+
+// statusError is an error with the http status to report it with.
+type statusError struct {
+	code int
+	err error
+}
+
+// Error() returns the message for a statusError.
+func (e statusError) Error() string {
+	return e.err.Error()
 }
 
 // getDbRecordsHandler() handles GET requests on /db/_table/{table_name} .
 func getDbRecordsHandler(harg *apiHandlerArg) apiHandlerRet {
 	params, err := fetchParams(harg,
-		"table_name", "fields", "id_field", "ids", "limit", "offset")
+		"table_name", "fields", "id_field", "ids", "limit", "offset",
+		"filter", "order", "include_count", "cursor", "include_deleted")
 	if err != nil {
-		return errorRet(badStat, err, "after fetchParams")
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
+	}
+	if params["cursor"] != "" && aToIdType(params["offset"]) != 0 {
+		return errorRet(badStat,
+			fmt.Errorf("cursor and offset cannot be used together"), "")
 	}
 
-	u := harg.req.URL
-	self := fmt.Sprintf("%s://%s%s%s/%s",
-		u.Scheme, u.Host, basePath, "/db/_table", params["table_name"])
-	return getCommon(self, params)
+	self := tableSelf(harg, params["table_name"])
+	return getCommon(self, params, harg.req.URL.Query())
 }
 
 // getDbRecordHandler() handles GET requests on /db/_table/{table_name}/{id} .
 func getDbRecordHandler(harg *apiHandlerArg) apiHandlerRet {
 	params, err := fetchParams(harg,
-		"table_name", "id", "fields", "id_field")
+		"table_name", "id", "fields", "id_field", "include_deleted")
 	if err != nil {
-		return errorRet(badStat, err, "after fetchParams")
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
 	}
 	params["limit"] = strconv.Itoa(1)
 	params["offset"] = strconv.Itoa(0)
 
-	u := harg.req.URL
-	self := fmt.Sprintf("%s://%s%s%s/%s",
-		u.Scheme, u.Host, basePath, "/db/_table", params["table_name"])
-	return getCommon(self, params)
+	self := tableSelf(harg, params["table_name"])
+	ret := getCommon(self, params, nil)
+	if resp, ok := ret.data.(RecordsResponse); ok && resp.Records[0].Etag != "" {
+		harg.setHeader("ETag", resp.Records[0].Etag)
+	}
+	return ret
 }
 
 // updateDbRecordsHandler() handles PATCH requests on /db/_table/{table_name} .
 func updateDbRecordsHandler(harg *apiHandlerArg) apiHandlerRet {
-	params, err := fetchParams(harg, "table_name", "id_field", "ids")
+	params, err := fetchWriteParams(harg, "table_name", "id_field", "ids",
+		"filter")
 	if err != nil {
-		return errorRet(badStat, err, "after fetchParams")
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
 	}
 	return updateCommon(harg, params)
 }
 
 // updateDbRecordHandler() handles PATCH requests on /db/_table/{table_name}/{id} .
 func updateDbRecordHandler(harg *apiHandlerArg) apiHandlerRet {
-	params, err := fetchParams(harg, "table_name", "id", "id_field")
+	params, err := fetchWriteParams(harg, "table_name", "id", "id_field")
 	if err != nil {
-		return errorRet(badStat, err, "after fetchParams")
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
 	}
 	return updateCommon(harg, params)
 }
 
+// replaceDbRecordsHandler() handles PUT requests on /db/_table/{table_name} .
+func replaceDbRecordsHandler(harg *apiHandlerArg) apiHandlerRet {
+	params, err := fetchWriteParams(harg, "table_name", "id_field",
+		"create_if_missing")
+	if err != nil {
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
+	}
+	return replaceCommon(harg, params)
+}
+
+// replaceDbRecordHandler() handles PUT requests on /db/_table/{table_name}/{id} .
+func replaceDbRecordHandler(harg *apiHandlerArg) apiHandlerRet {
+	params, err := fetchWriteParams(harg, "table_name", "id", "id_field",
+		"create_if_missing")
+	if err != nil {
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
+	}
+	return replaceCommon(harg, params)
+}
+
 // deleteDbRecordsHandler handles DELETE requests on /db/_table/{table_name} .
 func deleteDbRecordsHandler(harg *apiHandlerArg) apiHandlerRet {
-	params, err := fetchParams(harg, "table_name", "id_field", "ids")
+	params, err := fetchWriteParams(harg, "table_name", "id_field", "ids",
+		"filter")
 	if err != nil {
-		return errorRet(badStat, err, "after fetchParams")
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
 	}
-	return delCommon(params)
+	return delCommon(harg, params)
 }
 
 // deleteDbRecordHandler handles DELETE requests on /db/_table/{table_name}/{id} .
 func deleteDbRecordHandler(harg *apiHandlerArg) apiHandlerRet {
+	params, err := fetchWriteParams(harg, "table_name", "id", "id_field")
+	if err != nil {
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
+	}
+	return delCommon(harg, params)
+}
+
+// restoreDbRecordsHandler() handles POST requests on
+// /db/_table/{table_name}/_restore .
+func restoreDbRecordsHandler(harg *apiHandlerArg) apiHandlerRet {
+	params, err := fetchParams(harg, "table_name", "id_field", "ids",
+		"filter")
+	if err != nil {
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
+	}
+	return restoreCommon(harg, params)
+}
+
+// restoreDbRecordHandler() handles POST requests on
+// /db/_table/{table_name}/{id}/_restore .
+func restoreDbRecordHandler(harg *apiHandlerArg) apiHandlerRet {
 	params, err := fetchParams(harg, "table_name", "id", "id_field")
 	if err != nil {
-		return errorRet(badStat, err, "after fetchParams")
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
+	}
+	return restoreCommon(harg, params)
+}
+
+// purgeDbRecordsHandler() handles DELETE requests on
+// /db/_table/{table_name}/_purge .
+func purgeDbRecordsHandler(harg *apiHandlerArg) apiHandlerRet {
+	params, err := fetchParams(harg, "table_name", "older_than")
+	if err != nil {
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
+	}
+	age, _ := time.ParseDuration(params["older_than"])
+	err = setTableOptions(db, params)
+	if err != nil {
+		return errorRet(dbErrorStatus(err), err, "after setTableOptions")
+	}
+	setCaller(harg, params)
+	var nc idType
+	err = withTx(db, func(txdb dbType) error {
+		var err error
+		nc, err = purgeRecs(txdb, params, time.Now(), age)
+		return err
+	})
+	if err != nil {
+		return errorRet(dbErrorStatus(err), err, "after purgeRecs")
+	}
+	return apiHandlerRet{http.StatusOK,
+		NumChangedResponse{NumChanged: int64(nc),
+			Kind: "NumChangedResponse"}}
+}
+
+// getDbRecordHistoryHandler() handles GET requests on
+// /db/_table/{table_name}/{id}/_history .
+func getDbRecordHistoryHandler(harg *apiHandlerArg) apiHandlerRet {
+	params, err := fetchParams(harg, "table_name", "id", "start_time",
+		"end_time", "limit", "offset")
+	if err != nil {
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
+	}
+	params["table"] = params["table_name"]
+	return auditCommon(params)
+}
+
+// aggregateDbRecordsHandler() handles GET requests on
+// /db/_table/{table_name}/_aggregate .
+func aggregateDbRecordsHandler(harg *apiHandlerArg) apiHandlerRet {
+	params, err := fetchParams(harg, "table_name", "aggregate",
+		"group_by", "filter", "limit", "offset", "include_deleted")
+	if err != nil {
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
+	}
+	err = setTableOptions(db, params)
+	if err != nil {
+		return errorRet(dbErrorStatus(err), err, "after setTableOptions")
+	}
+	err = validateParamFields(db, params)
+	if err != nil {
+		return errorRet(badStat, err, "after validateParamFields")
+	}
+	resp, more, err := runAggregate(db, params)
+	if err != nil {
+		return errorRet(badStat, err, "after runAggregate")
+	}
+	if more {
+		self := tableSelf(harg, params["table_name"]) + "/_aggregate"
+		resp.Next = mkPageLink(self, harg.req.URL.Query(),
+			resp.Offset + resp.Limit)
+	}
+	return apiHandlerRet{http.StatusOK, resp}
+}
+
+// getDbAdminRegistryHandler() handles GET requests on
+// /db/_admin/registry .
+func getDbAdminRegistryHandler(harg *apiHandlerArg) apiHandlerRet {
+	entries, err := queryRegistry(db)
+	if err != nil {
+		return errorRet(badStat, err, "after queryRegistry")
+	}
+	return apiHandlerRet{http.StatusOK,
+		RegistryResponse{Entries: entries, Kind: "Collection"}}
+}
+
+// getDbAdminObjectsHandler() handles GET requests on /db/_admin/objects .
+func getDbAdminObjectsHandler(harg *apiHandlerArg) apiHandlerRet {
+	objects, err := queryObjects(db)
+	if err != nil {
+		return errorRet(badStat, err, "after queryObjects")
+	}
+	return apiHandlerRet{http.StatusOK,
+		ObjectsResponse{Objects: objects, Kind: "Collection"}}
+}
+
+// adoptDbTableHandler() handles POST requests on
+// /db/_admin/adopt/{table_name} .
+func adoptDbTableHandler(harg *apiHandlerArg) apiHandlerRet {
+	params, err := fetchParams(harg, "table_name")
+	if err != nil {
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
+	}
+	err = adoptTable(db, params["table_name"])
+	if err != nil {
+		return errorRet(dbErrorStatus(err), err, "after adoptTable")
+	}
+	resp, err := describeTable(db, params["table_name"],
+		harg.req.URL.String())
+	if err != nil {
+		return errorRet(dbErrorStatus(err), err, "after describeTable")
+	}
+	return apiHandlerRet{http.StatusCreated, resp}
+}
+
+// getDbAuditHandler() handles GET requests on /db/_audit .
+func getDbAuditHandler(harg *apiHandlerArg) apiHandlerRet {
+	params, err := fetchParams(harg, "table", "start_time", "end_time",
+		"limit", "offset")
+	if err != nil {
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
+	}
+	return auditCommon(params)
+}
+
+// getDbChangesHandler() handles GET requests on /db/_changes .
+// if the request accepts text/event-stream, the changes are streamed
+// as server-sent events.  otherwise they are returned at once, after
+// waiting for the wait parameter's duration if there are none yet.
+func getDbChangesHandler(harg *apiHandlerArg) apiHandlerRet {
+	params, err := fetchParams(harg, "since", "table", "limit", "wait")
+	if err != nil {
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
+	}
+	if harg.formValue("since") == "" {
+		// a reconnecting event stream continues after its last event.
+		if last := harg.req.Header.Get("Last-Event-ID"); last != "" {
+			params["since"], err = validate_since(last)
+			if err != nil {
+				return errorRet(badStat, err, "after validate_since")
+			}
+		}
+	}
+	wait, _ := time.ParseDuration(params["wait"])
+	done := harg.req.Context().Done()
+	if strings.Contains(harg.req.Header.Get("Accept"), "text/event-stream") {
+		harg.setHeader("Content-Type", "text/event-stream")
+		harg.setHeader("Cache-Control", "no-cache")
+		return apiHandlerRet{http.StatusOK, changeStream{params, wait, done}}
+	}
+	changes, err := waitChanges(db, params, wait, done)
+	if err != nil {
+		return errorRet(badStat, err, "after waitChanges")
+	}
+	return apiHandlerRet{http.StatusOK,
+		ChangesResponse{Changes: changes, Kind: "Collection",
+			LastSeq: lastSeq(changes, aToIdType(params["since"]))}}
+}
+
+// createDbHookHandler() handles POST requests on /db/_hooks .
+func createDbHookHandler(harg *apiHandlerArg) apiHandlerRet {
+	req := HookRequest{}
+	err := json.NewDecoder(harg.getBody()).Decode(&req)
+	if err != nil {
+		return errorRet(badStat, err, "after Decode")
+	}
+	id, err := createHook(db, req)
+	if err != nil {
+		return errorRet(dbErrorStatus(err), err, "after createHook")
+	}
+	return apiHandlerRet{http.StatusCreated,
+		IdsResponse{Ids: []int64{int64(id)}, Kind: "Collection"}}
+}
+
+// getDbHooksHandler() handles GET requests on /db/_hooks .
+func getDbHooksHandler(harg *apiHandlerArg) apiHandlerRet {
+	params, err := fetchParams(harg, "table")
+	if err != nil {
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
+	}
+	hooks, err := queryHooks(db, params)
+	if err != nil {
+		return errorRet(badStat, err, "after queryHooks")
+	}
+	return apiHandlerRet{http.StatusOK,
+		HooksResponse{Hooks: hooks, Kind: "Collection"}}
+}
+
+// getDbHookHandler() handles GET requests on /db/_hooks/{hook_id} .
+// the webhook is returned with the state of its deliveries.
+func getDbHookHandler(harg *apiHandlerArg) apiHandlerRet {
+	params, err := fetchParams(harg, "hook_id")
+	if err != nil {
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
+	}
+	hooks, err := queryHooks(db, params)
+	if err != nil {
+		return errorRet(badStat, err, "after queryHooks")
+	}
+	if len(hooks) == 0 {
+		return errorRet(http.StatusNotFound,
+			fmt.Errorf("no webhook with id %s", params["hook_id"]), "")
+	}
+	st, err := hookOutbox(db, hooks[0].Id)
+	if err != nil {
+		return errorRet(badStat, err, "after hookOutbox")
+	}
+	hooks[0].Status = &st
+	return apiHandlerRet{http.StatusOK, hooks[0]}
+}
+
+// deleteDbHookHandler() handles DELETE requests on /db/_hooks/{hook_id} .
+func deleteDbHookHandler(harg *apiHandlerArg) apiHandlerRet {
+	params, err := fetchParams(harg, "hook_id")
+	if err != nil {
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
+	}
+	var nc idType
+	err = withTx(db, func(txdb dbType) error {
+		var err error
+		nc, err = deleteHook(txdb, aToIdType(params["hook_id"]))
+		return err
+	})
+	if err != nil {
+		return errorRet(badStat, err, "after deleteHook")
 	}
-	return delCommon(params)
+	if nc == 0 {
+		return errorRet(http.StatusNotFound,
+			fmt.Errorf("no webhook with id %s", params["hook_id"]), "")
+	}
+	return apiHandlerRet{http.StatusOK,
+		NumChangedResponse{NumChanged: int64(nc),
+			Kind: "NumChangedResponse"}}
+}
+
+// auditCommon() is common code for the audit log APIs.
+func auditCommon(params map[string]string) apiHandlerRet {
+	entries, err := queryAudit(db, params)
+	if err != nil {
+		return errorRet(badStat, err, "after queryAudit")
+	}
+	return apiHandlerRet{http.StatusOK,
+		AuditResponse{Entries: entries, Kind: "Collection"}}
 }
 
 // createDbTableHandler handles POST requests on /db/_schema/{table_name} .
 func createDbTableHandler(harg *apiHandlerArg) apiHandlerRet {
 	params, err := fetchParams(harg, "table_name")
 	if err != nil {
-		return errorRet(badStat, err, "after fetchParams")
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
 	}
 	schema, err := getBodySchema(harg)
 	if err != nil {
@@ -157,78 +547,172 @@ func createDbTableHandler(harg *apiHandlerArg) apiHandlerRet {
 }
 
 // describeDbTableHandler handles GET requests on /db/_schema/{table_name} .
+// the schema is read from the schema cache, unless refresh is true.
 func describeDbTableHandler(harg *apiHandlerArg) apiHandlerRet {
+	params, err := fetchParams(harg, "table_name", "refresh")
+	if err != nil {
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
+	}
+	if params["refresh"] == "true" {
+		invalidateTable(params["table_name"])
+	}
+	resp, err := describeTable(db, params["table_name"],
+		harg.req.URL.String())
+	if err != nil {
+		return errorRet(dbErrorStatus(err), err, "after describeTable")
+	}
+	return apiHandlerRet{http.StatusOK, resp}
+}
+
+// alterDbTableHandler handles PATCH requests on /db/_schema/{table_name} .
+func alterDbTableHandler(harg *apiHandlerArg) apiHandlerRet {
 	params, err := fetchParams(harg, "table_name")
 	if err != nil {
-		return errorRet(badStat, err, "after fetchParams")
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
+	}
+	req, err := getBodyAlter(harg)
+	if err != nil {
+		return errorRet(badStat, err, "after getBodyAlter")
 	}
-	return schemaQuery(harg.req.URL.String(), tableOfTables,
-		"schema", "name", params["table_name"])
+	log.Debugf("alter=%v", req)
+	err = alterTable(params, req)
+	if err != nil {
+		return errorRet(dbErrorStatus(err), err, "after alterTable")
+	}
+	resp, err := describeTable(db, params["table_name"],
+		harg.req.URL.String())
+	if err != nil {
+		return errorRet(dbErrorStatus(err), err, "after describeTable")
+	}
+	return apiHandlerRet{http.StatusOK, resp}
 }
 
 // deleteDbTableHandler handles DELETE requests on /db/_schema/{table_name} .
 func deleteDbTableHandler(harg *apiHandlerArg) apiHandlerRet {
 	params, err := fetchParams(harg, "table_name")
 	if err != nil {
-		return errorRet(badStat, err, "after fetchParams")
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
 	}
 	err = deleteTable(params["table_name"])
 	if err != nil {
-		return errorRet(badStat, err, "deleteTable")
+		return errorRet(dbErrorStatus(err), err, "deleteTable")
 	}
 	return apiHandlerRet{http.StatusOK, nil}
 }
 
-// ----- misc support functions
-
-// tablesQuery is the guts of getDbTablesHandler().
-// it's easier to test with an argument.
-func tablesQuery(self string,
-	tabName string,
-	fieldName string) apiHandlerRet {
-	// the tableOfTables table is our convention, not maintained by sqlite.
+// renameDbTableHandler handles POST requests on
+// /db/_schema/{table_name}/_rename .
+func renameDbTableHandler(harg *apiHandlerArg) apiHandlerRet {
+	params, err := fetchParams(harg, "table_name", "new_name")
+	if err != nil {
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
+	}
+	err = renameTable(db, params["table_name"], params["new_name"])
+	if err != nil {
+		return errorRet(dbErrorStatus(err), err, "after renameTable")
+	}
+	resp, err := describeTable(db, params["new_name"],
+		harg.req.URL.String())
+	if err != nil {
+		return errorRet(dbErrorStatus(err), err, "after describeTable")
+	}
+	return apiHandlerRet{http.StatusOK, resp}
+}
 
-	idlist := []interface{}{}
-	qstring := fmt.Sprintf("select id,%s from %s", fieldName, tabName)
-	result, err := runQuery(db, "", qstring, idlist)
+// copyDbTableHandler handles POST requests on
+// /db/_schema/{table_name}/_copy .
+func copyDbTableHandler(harg *apiHandlerArg) apiHandlerRet {
+	params, err := fetchParams(harg, "table_name", "new_name", "with_data")
 	if err != nil {
-		return errorRet(badStat, err, "after runQuery")
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
 	}
-	ret, err := convTableNames(result)
+	err = copyTable(db, params["table_name"], params["new_name"],
+		params["with_data"] == "true")
 	if err != nil {
-		return errorRet(badStat, err, "after convTableNames")
+		return errorRet(dbErrorStatus(err), err, "after copyTable")
+	}
+	resp, err := describeTable(db, params["new_name"],
+		harg.req.URL.String())
+	if err != nil {
+		return errorRet(dbErrorStatus(err), err, "after describeTable")
 	}
+	return apiHandlerRet{http.StatusCreated, resp}
+}
 
+// listDbIndexesHandler handles GET requests on
+// /db/_schema/{table_name}/_index .
+func listDbIndexesHandler(harg *apiHandlerArg) apiHandlerRet {
+	params, err := fetchParams(harg, "table_name")
+	if err != nil {
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
+	}
+	sch, err := getTableSchema(db, params["table_name"])
+	if err != nil {
+		return errorRet(dbErrorStatus(err), err, "after getTableSchema")
+	}
+	indexes := sch.Indexes
+	if indexes == nil {
+		indexes = []IndexSchema{}
+	}
 	return apiHandlerRet{http.StatusOK,
-		TablesResponse{ret, "TablesResponse", self}}
+		IndexesResponse{indexes, "IndexesResponse",
+			harg.req.URL.String()}}
 }
 
-// schemaQuery is the guts of describeDbTableHandler().
+// createDbIndexHandler handles POST requests on
+// /db/_schema/{table_name}/_index .
+func createDbIndexHandler(harg *apiHandlerArg) apiHandlerRet {
+	params, err := fetchParams(harg, "table_name")
+	if err != nil {
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
+	}
+	idx := IndexSchema{}
+	err = json.NewDecoder(harg.getBody()).Decode(&idx)
+	if err != nil {
+		return errorRet(badStat, err, "after Decode")
+	}
+	err = createIndex(params, idx)
+	if err != nil {
+		return errorRet(dbErrorStatus(err), err, "after createIndex")
+	}
+	return apiHandlerRet{http.StatusCreated, nil}
+}
+
+// deleteDbIndexHandler handles DELETE requests on
+// /db/_schema/{table_name}/_index/{index_name} .
+func deleteDbIndexHandler(harg *apiHandlerArg) apiHandlerRet {
+	params, err := fetchParams(harg, "table_name", "index_name")
+	if err != nil {
+		return errorRet(paramErrorStatus(err), err, "after fetchParams")
+	}
+	err = deleteIndex(params)
+	if err != nil {
+		return errorRet(dbErrorStatus(err), err, "after deleteIndex")
+	}
+	return apiHandlerRet{http.StatusOK, nil}
+}
+
+// ----- misc support functions
+
+// tablesQuery is the guts of getDbTablesHandler().
 // it's easier to test with an argument.
-func schemaQuery(self string,
+func tablesQuery(self string,
 	tabName string,
-	fieldName string,
-	selector string,
-	item string) apiHandlerRet {
+	fieldName string) apiHandlerRet {
 	// the tableOfTables table is our convention, not maintained by sqlite.
 
-	idlist := []interface{}{}
-	qstring := fmt.Sprintf(`select id,%s from %s where %s = "%s"`,
-		fieldName, tabName, selector, item)
-	result, err := runQuery(db, "", qstring, idlist)
+	q := newSQL("select id,").ident(fieldName).sql(" from ").ident(tabName)
+	result, err := runQuery(db, "", q.String(), q.args)
 	if err != nil {
 		return errorRet(badStat, err, "after runQuery")
 	}
-	if len(result) != 1 {
-		return errorRet(badStat,
-			fmt.Errorf("results length mismatch"),
-			"after runQuery")
+	ret, err := convTableNames(result)
+	if err != nil {
+		return errorRet(badStat, err, "after convTableNames")
 	}
-	data, _ := (*result[0]).Values[0].(string)
-	log.Debugf("schema = %s", data)
 
 	return apiHandlerRet{http.StatusOK,
-		SchemaResponse{data, "SchemaResponse", self}}
+		TablesResponse{ret, "TablesResponse", self}}
 }
 
 // errorRet() is called by apiHandler routines to pass back the code/data
@@ -242,11 +726,11 @@ func errorRet(code int, err error, dmsg string) apiHandlerRet {
 }
 
 // mkSQLRow() returns a list of interface{} of the given length,
-// each element is actually a pointer to sql.RawBytes .
+// each element is actually a pointer to interface{} .
 func mkSQLRow(N int) []interface{} {
 	ret := make([]interface{}, N)
 	for i := 0; i < N; i++ {
-		ret[i] = new(sql.RawBytes)
+		ret[i] = new(interface{})
 	}
 	return ret
 }
@@ -273,7 +757,7 @@ func runQuery(db dbType,
 
 	ret := make([]*KVResponse, 0, 1)
 
-	rows, err := db.handle.Query(qstring, ivals...)
+	rows, err := db.runner().Query(qstring, ivals...)
 	if err != nil {
 		return queryErrorRet(ret, err, "failure after Query")
 	}
@@ -287,8 +771,13 @@ func runQuery(db dbType,
 	}
 	log.Debugf("cols = %s", cols)
 
+	types, err := columnTypes(rows)
+	if err != nil {
+		return queryErrorRet(ret, err, "failure after ColumnTypes")
+	}
+
 	for rows.Next() {
-		rec, err := queryRow(self, rows, cols)
+		rec, err := queryRow(self, rows, cols, types)
 		if err != nil {
 			return queryErrorRet(ret, err, "failure after queryRow")
 		}
@@ -301,10 +790,27 @@ func runQuery(db dbType,
 	return ret, rows.Err()
 }
 
+// columnTypes() returns the declared types of the columns
+// of the given rows.  the type of a column that is an expression
+// rather than a table column is "".
+func columnTypes(rows *sql.Rows) ([]string, error) {
+	ctypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]string, len(ctypes))
+	for i, ct := range ctypes {
+		ret[i] = ct.DatabaseTypeName()
+	}
+	return ret, nil
+}
+
 // queryRow() handles one iteration of runQuery's row loop.
+// types are the declared types of the columns.
 func queryRow(self string,
 	rows *sql.Rows,
-	cols []string) (*KVResponse, error) {
+	cols []string,
+	types []string) (*KVResponse, error) {
 
 	ret := &KVResponse{}
 	ret.Kind = "KVResponse"
@@ -315,7 +821,7 @@ func queryRow(self string,
 		return ret, err
 	}
 
-	err = convValues(vals)
+	err = convValues(vals, types)
 	if err != nil {
 		return ret, err
 	}
@@ -325,11 +831,12 @@ func queryRow(self string,
 	// the following fields are those from the request.
 
 	// get the record id for use in the self property.
-	id, ok := vals[0].(string)
+	id, ok := vals[0].(int64)
 	if !ok {
 		return ret, fmt.Errorf("id type conversion error")
 	}
-	ret.Self = fmt.Sprintf("%s/%s", self, id)
+	ret.Self = fmt.Sprintf("%s/%s", self, idTypeToA(id))
+	ret.id = id
 
 	ret.Keys = cols[1:]
 	ret.Values = vals[1:]
@@ -375,57 +882,285 @@ func runInsert(db dbType,
 	tabName string,
 	keys []string,
 	values []interface{}) (idType, error) {
-	nvalues := len(values)
+	q := newSQL("INSERT INTO ").ident(tabName).
+		sql(" (").idents(keys).
+		sql(") VALUES (").values(values).sql(")")
 
-	keystr := strings.Join(keys, ",")
-	placestr := nstring("?", nvalues)
-
-	qstring := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", // nolint
-		tabName, keystr, placestr)
-
-	exres, err := runExec(db, qstring, values)
+	exres, err := runExec(db, q.String(), q.args)
 	return exres.lastInsertId, err
 }
 
-// delCommon() is the common part of record deletion APIs.
-func delCommon(params map[string]string) apiHandlerRet {
-	nc, err := delRecs(db, params)
+// insertRecord() inserts one record, handling a conflict as specified
+// by the on_conflict parameter.  it returns the id and the outcome
+// as runUpsert() does.  the change is recorded as an update of the
+// conflicting record, if any.
+func insertRecord(db dbType,
+	params map[string]string,
+	rec KVRecord) (idType, string, error) {
+	if params["on_conflict"] == "error" {
+		var id idType
+		err := recordChange(db, params, "insert", nil,
+			func() ([]int64, error) {
+				var err error
+				id, err = runInsert(db, params["table_name"],
+					rec.Keys, rec.Values)
+				return []int64{int64(id)}, err
+			})
+		return id, "inserted", err
+	}
+	var ids []int64
+	oldId, found, err := findConflict(db, params, rec)
 	if err != nil {
-		return errorRet(badStat, err, "after delRec")
+		return -1, "", err
 	}
-
-	return apiHandlerRet{http.StatusOK,
-		NumChangedResponse{int64(nc), "NumChangedResponse"}}
+	if found {
+		ids = []int64{int64(oldId)}
+	}
+	var id idType
+	var outcome string
+	err = recordChange(db, params, "update", ids,
+		func() ([]int64, error) {
+			var err error
+			id, outcome, err = runUpsert(db, params, rec, oldId, found)
+			return []int64{int64(id)}, err
+		})
+	return id, outcome, err
 }
 
-// dbErrorRet() returns an error value on behalf of a db caller
-// that normally returns an idType/error pair.
-func dbErrorRet(err error) (idType, error) {
-	return idType(-1), err
+// conflictTarget() returns the fields that identify a conflicting
+// record, from the conflict_target parameter, defaulting to the id field.
+func conflictTarget(params map[string]string) []string {
+	if params["conflict_target"] == "" {
+		return []string{idFieldName(params)}
+	}
+	return strings.Split(params["conflict_target"], ",")
 }
 
-// delRecs() deletes multiple records, using parameters in the params map.
-// it returns the number of records deleted.
-func delRecs(db dbType, params map[string]string) (idType, error) {
-	idclause, idlist := mkIdClause(params)
-	if idclause == "" {
-		return dbErrorRet(fmt.Errorf("deletion must specify id or ids"))
+// findConflict() returns the id of the existing record whose
+// conflict target fields have the same values as the given record.
+// the boolean result is false if there is no such record,
+// or if the given record does not have all the conflict target fields.
+func findConflict(db dbType,
+	params map[string]string,
+	rec KVRecord) (idType, bool, error) {
+	vals := map[string]interface{}{}
+	for i, k := range rec.Keys {
+		vals[k] = rec.Values[i]
 	}
-	qstring := fmt.Sprintf("DELETE FROM %s %s", // nolint
-		params["table_name"],
-		idclause)
-	log.Debugf("qstring = %s", qstring)
-
-	exres, err := runExec(db, qstring, idlist)
-	if int(exres.rowsAffected) != len(idlist) {
-		return dbErrorRet(fmt.Errorf("mismatch in rows affected"))
+	target := conflictTarget(params)
+	conds := make([]string, len(target))
+	args := make([]interface{}, len(target))
+	for i, f := range target {
+		v, ok := vals[f]
+		if !ok {
+			return -1, false, nil
+		}
+		conds[i] = quoteIdent(f) + " = ?"
+		args[i] = v
 	}
-	return exres.rowsAffected, err
+	q := newSQL("SELECT ").ident(idFieldName(params)).
+		sql(" FROM ").ident(params["table_name"]).
+		clause("WHERE " + strings.Join(conds, " AND "), args)
+	log.Debugf("query = %s", q)
+	var id idType
+	err := db.runner().QueryRow(q.String(), q.args...).Scan(&id)
+	if err == sql.ErrNoRows {
+		return -1, false, nil
+	}
+	return id, err == nil, err
 }
 
-// validateSQLKeys() checks an array of key names,
-// returning a non-nil error if anything is found that
-// would not be a valid SQL key.
+// mkUpsertString() returns the insertion command for a record with
+// the given keys, that handles conflicts as specified by the
+// on_conflict parameter.  the boolean result is false if the
+// command would update nothing on a conflict.
+func mkUpsertString(params map[string]string, keys []string) (string, bool) {
+	insert := "INSERT"
+	suffix := ""
+	updates := true
+	switch params["on_conflict"] {
+	case "ignore":
+		suffix = " ON CONFLICT DO NOTHING"
+		updates = false
+	case "update":
+		target := conflictTarget(params)
+		tmap := listToMap(target)
+		sets := []string{}
+		for _, k := range keys {
+			if tmap[k] == 0 {
+				sets = append(sets, quoteIdent(k) +
+					" = excluded." + quoteIdent(k))
+			}
+		}
+		suffix = " ON CONFLICT (" + quoteIdents(target) + ")"
+		if len(sets) == 0 {
+			suffix += " DO NOTHING"
+			updates = false
+		} else {
+			suffix += " DO UPDATE SET " + strings.Join(sets, ", ")
+		}
+	}
+	q := newSQL(insert + " INTO ").ident(params["table_name"]).
+		sql(" (").idents(keys).
+		sql(") VALUES (" + nstring("?", len(keys)) + ")" + suffix)
+	return q.String(), updates
+}
+
+// runUpsert() inserts a record like runInsert(), but a record that
+// conflicts with an existing one is handled as specified by the
+// on_conflict parameter.  oldId and found are the conflicting record,
+// as returned by findConflict(), which must be called first, since
+// afterward it is not possible to tell an update from an insertion.
+// it returns the id of the inserted or conflicting record, and the
+// outcome: inserted, updated, or skipped.  the id of a skipped record
+// is -1 if it cannot be determined.
+func runUpsert(db dbType,
+	params map[string]string,
+	rec KVRecord,
+	oldId idType,
+	found bool) (idType, string, error) {
+	if found && params["on_conflict"] == "replace" {
+		return oldId, "updated", replaceConflict(db, params, rec, oldId)
+	}
+	qstring, updates := mkUpsertString(params, rec.Keys)
+	exres, err := runExec(db, qstring, rec.Values)
+	if err != nil {
+		return -1, "", err
+	}
+	switch {
+	case exres.rowsAffected == 0 && !found:
+		// the conflict was not on the target fields.
+		return -1, "skipped", nil
+	case exres.rowsAffected == 0:
+		return oldId, "skipped", nil
+	case !found:
+		return exres.lastInsertId, "inserted", nil
+	case !updates:
+		return oldId, "skipped", nil
+	}
+	return oldId, "updated", nil
+}
+
+// replaceConflict() replaces all the fields of the conflicting record
+// with the given id, other than its id field, with those of rec, as
+// replaceRecord() does.  the record is updated in place, rather than
+// deleted and inserted again, so it keeps its id, and the records
+// that refer to it are left alone.  a soft-deleted record is replaced
+// too, and so is no longer deleted.
+func replaceConflict(db dbType,
+	params map[string]string,
+	rec KVRecord,
+	id idType) error {
+	cols, err := tableColumnInfo(db, params["table_name"])
+	if err != nil {
+		return err
+	}
+	rparams := map[string]string{}
+	for k, v := range params {
+		rparams[k] = v
+	}
+	delete(rparams, "soft_delete")
+	delete(rparams, "create_if_missing")
+	_, err = replaceRecord(db, rparams, cols, int64(id), rec)
+	return err
+}
+
+// delCommon() is the common part of record deletion APIs.
+// if the fields parameter is given, the deleted records are returned.
+func delCommon(harg *apiHandlerArg, params map[string]string) apiHandlerRet {
+	pre := getPreconditions(harg)
+	err := setTableOptions(db, params)
+	if err != nil {
+		return errorRet(dbErrorStatus(err), err, "after setTableOptions")
+	}
+	err = validateParamFields(db, params)
+	if err != nil {
+		return errorRet(badStat, err, "after validateParamFields")
+	}
+
+	self := tableSelf(harg, params["table_name"])
+	var nc idType
+	var recs []*KVResponse
+	err = withTx(db, func(txdb dbType) error {
+		if _, single := params["id"]; single {
+			err := checkPreconditions(txdb, params, pre,
+				aToIdType(params["id"]))
+			if err != nil {
+				return err
+			}
+		}
+		ids, err := matchingIds(txdb, params)
+		if err != nil {
+			return err
+		}
+		if _, single := params["id"]; !single {
+			err = checkEachPrecondition(txdb, params, pre, ids)
+			if err != nil {
+				return err
+			}
+		}
+		recs, err = fetchRecords(txdb, self, params, ids)
+		if err != nil {
+			return err
+		}
+		return recordChange(txdb, params, "delete", ids,
+			func() ([]int64, error) {
+				var err error
+				nc, err = delRecs(txdb, params)
+				return nil, err
+			})
+	})
+	if err != nil {
+		return errorRet(dbErrorStatus(err), err, "after delRec")
+	}
+
+	return apiHandlerRet{http.StatusOK,
+		NumChangedResponse{NumChanged: int64(nc),
+			Kind: "NumChangedResponse", Records: recs}}
+}
+
+// dbErrorRet() returns an error value on behalf of a db caller
+// that normally returns an idType/error pair.
+func dbErrorRet(err error) (idType, error) {
+	return idType(-1), err
+}
+
+// delRecs() deletes multiple records, using parameters in the params map.
+// it returns the number of records deleted.
+func delRecs(db dbType, params map[string]string) (idType, error) {
+	idclause, idlist := mkIdClause(params)
+	nids := len(idlist)
+	where, args, err := mkFilterClause(params, idclause, idlist)
+	if err != nil {
+		return dbErrorRet(err)
+	}
+	if where == "" {
+		return dbErrorRet(
+			fmt.Errorf("deletion must specify id, ids, or filter"))
+	}
+	qstring := newSQL("DELETE FROM ").ident(params["table_name"]).
+		clause(where, nil).String()
+	if params["soft_delete"] == "true" {
+		qstring = mkSoftDeleteString(params, where)
+		args = append([]interface{}{dbTime(time.Now())},
+			args...)
+	}
+	log.Debugf("qstring = %s", qstring)
+
+	exres, err := runExec(db, qstring, args)
+	if err != nil {
+		return dbErrorRet(err)
+	}
+	// with a filter, fewer rows than ids may legitimately match.
+	if params["filter"] == "" && int(exres.rowsAffected) != nids {
+		return dbErrorRet(fmt.Errorf("mismatch in rows affected"))
+	}
+	return exres.rowsAffected, nil
+}
+
+// validateSQLKeys() checks an array of key names,
+// returning a non-nil error if anything is found that
+// would not be a valid SQL key.
 func validateSQLKeys(keys []string) error {
 	for _, k := range keys {
 		if !isValidIdent(k) {
@@ -436,17 +1171,54 @@ func validateSQLKeys(keys []string) error {
 }
 
 // getBodySchema() returns a json schema from the body of the request.
+// a schema with unknown keys is refused, so that a misspelled key
+// is not silently dropped.
 func getBodySchema(harg *apiHandlerArg) (TableSchema, error) {
 	jrec := TableSchema{}
+	dec := json.NewDecoder(harg.getBody())
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&jrec)
+	return jrec, err
+}
+
+// getBodyAlter() returns the table changes from the body of the request.
+func getBodyAlter(harg *apiHandlerArg) (AlterTableRequest, error) {
+	jrec := AlterTableRequest{}
 	err := json.NewDecoder(harg.getBody()).Decode(&jrec)
 	return jrec, err
 }
 
 // getBodyRecord() returns a json record from the body of the given request.
+// numbers are decoded exactly, so that large ids keep their precision.
 func getBodyRecord(harg *apiHandlerArg) (BodyRecord, error) {
 	jrec := BodyRecord{}
-	err := json.NewDecoder(harg.getBody()).Decode(&jrec)
-	return jrec, err
+	dec := json.NewDecoder(harg.getBody())
+	dec.UseNumber()
+	err := dec.Decode(&jrec)
+	if err != nil {
+		return jrec, err
+	}
+	for _, rec := range jrec.Records {
+		for i, v := range rec.Values {
+			if num, ok := v.(json.Number); ok {
+				rec.Values[i], err = numberValue(num)
+				if err != nil {
+					return jrec, err
+				}
+			}
+		}
+	}
+	return jrec, nil
+}
+
+// numberValue() converts a number decoded from JSON to a value that
+// can be bound in SQL.  integers become int64 values, others float64.
+func numberValue(num json.Number) (interface{}, error) {
+	if i, err := strconv.ParseInt(string(num),
+		idTypeRadix, idTypeBits); err == nil {
+		return i, nil
+	}
+	return num.Float64()
 }
 
 // mkIdClause() takes the API parameters,
@@ -462,8 +1234,7 @@ func mkIdClause(params map[string]string) (string, []interface{}) { // nolint
 	if ok {
 		idlist := []interface{}{aToIdType(id)}
 		placestr := "?"
-		idclause := fmt.Sprintf("WHERE %s = %s", // nolint
-			id_field, placestr)
+		idclause := "WHERE " + quoteIdent(id_field) + " = " + placestr
 		return idclause, idlist
 	}
 
@@ -472,7 +1243,7 @@ func mkIdClause(params map[string]string) (string, []interface{}) { // nolint
 		idstrings := strings.Split(ids, ",")
 		idlist := idTypesToInterface(idstrings)
 		placestr := nstring("?", len(idlist))
-		idclause := fmt.Sprintf("WHERE %s in (%s)", id_field, placestr) // nolint
+		idclause := "WHERE " + quoteIdent(id_field) + " in (" + placestr + ")"
 		return idclause, idlist
 	}
 
@@ -481,25 +1252,6 @@ func mkIdClause(params map[string]string) (string, []interface{}) { // nolint
 	return "", []interface{}{}
 }
 
-// mkIdClauseUpdate() is like mkIdClause(), but for UPDATE operations.
-// the difference is, for now, that the id values are formatted directly
-// into the WHERE string, rather than being subbed in by Exec.
-func mkIdClauseUpdate(params map[string]string) string { // nolint
-	id_field := params["id_field"]
-	id, ok := params["id"]
-	if ok {
-		return fmt.Sprintf("WHERE %s = %s", id_field, id) // nolint
-	}
-	ids, ok := params["ids"]
-	if ok && ids != "" {
-		return fmt.Sprintf("WHERE %s in (%s)", id_field, ids) // nolint
-	}
-
-	// no id and no ids implies everything matches.
-	// if this is bad, caller should check.
-	return ""
-}
-
 // updateRec() updates certain fields of a given record or records,
 // using parameters in the params map.
 // it returns the number of records changed.
@@ -507,21 +1259,22 @@ func updateRec(db dbType,
 	params map[string]string,
 	body BodyRecord) (idType, error) {
 	dbrec := body.Records[0]
-	keylist := dbrec.Keys
-	keystr := strings.Join(keylist, ",")
-	placestr := nstring("?", len(keylist))
-	idclause := mkIdClauseUpdate(params)
-	if idclause == "" {
-		return dbErrorRet(fmt.Errorf("update must specify id or ids"))
+	idclause, idlist := mkIdClause(params)
+	where, args, err := mkFilterClause(params, idclause, idlist)
+	if err != nil {
+		return dbErrorRet(err)
+	}
+	if where == "" {
+		return dbErrorRet(
+			fmt.Errorf("update must specify id, ids, or filter"))
 	}
 
-	qstring := fmt.Sprintf("UPDATE %s SET (%s) = (%s) %s", // nolint
-		params["table_name"],
-		keystr,
-		placestr,
-		idclause)
+	q := newSQL("UPDATE ").ident(params["table_name"]).
+		sql(" SET (").idents(dbrec.Keys).
+		sql(") = (").values(dbrec.Values).sql(")").
+		clause(andSoftDelete(where, params), args)
 
-	exres, err := runExec(db, qstring, dbrec.Values)
+	exres, err := runExec(db, q.String(), q.args)
 	return exres.rowsAffected, err
 }
 
@@ -531,7 +1284,7 @@ func runExec(db dbType,
 	query string,
 	values []interface{}) (xResult, error) {
 	log.Debugf("query = %s", query)
-	stmt, err := db.handle.Prepare(query)
+	stmt, err := db.runner().Prepare(query)
 	if err != nil {
 		return xResult{}, err
 	}
@@ -543,30 +1296,534 @@ func runExec(db dbType,
 	return getExecResult(result), nil
 }
 
+// mkFilterClause() adds the condition from the filter parameter, if any,
+// to the given WHERE clause (which may be empty).
+// the filter's values are appended to args.
+// the returned clause and values are suitable for use with Exec.
+// the time_fields parameter, set by setTableOptions(), names the
+// datetime fields of the table.
+func mkFilterClause(params map[string]string,
+	where string,
+	args []interface{}) (string, []interface{}, error) {
+	node, err := parseFilter(params["filter"])
+	if err != nil || node == nil {
+		return where, args, err
+	}
+	if params["time_fields"] != "" {
+		markTimeFields(node, strings.Split(params["time_fields"], ","))
+	}
+	cond, fargs := compileFilter(node)
+	return andWhere(where, cond), append(args, fargs...), nil
+}
+
+// andWhere() adds the given condition to the given WHERE clause
+// (which may be empty).
+func andWhere(where string, cond string) string {
+	if where == "" {
+		return "WHERE " + cond
+	}
+	return where + " AND " + cond
+}
+
+// validateParamFields() checks the field names used in the
+// fields, id_field, conflict_target, filter, and order parameters,
+// if any, against the columns of the table named by the table_name
+// parameter.
+func validateParamFields(db dbType, params map[string]string) error {
+	node, err := parseFilter(params["filter"])
+	if err != nil {
+		return err
+	}
+	items, err := parseOrder(params["order"])
+	if err != nil {
+		return err
+	}
+
+	cols, err := tableColumns(db, params["table_name"])
+	if err != nil {
+		return err
+	}
+	if params["fields"] != "" && params["fields"] != "*" {
+		err = checkFields("fields",
+			strings.Split(params["fields"], ","), cols)
+		if err != nil {
+			return err
+		}
+	}
+	err = checkFields("id_field", []string{idFieldName(params)}, cols)
+	if err != nil {
+		return err
+	}
+	if params["conflict_target"] != "" {
+		err = checkFields("conflict_target",
+			strings.Split(params["conflict_target"], ","), cols)
+		if err != nil {
+			return err
+		}
+	}
+	if node != nil {
+		err = validateFilterFields(node, cols)
+		if err != nil {
+			return err
+		}
+	}
+	for _, oi := range items {
+		err = checkFields("order", []string{oi.field}, cols)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateRecordFields() checks the keys of the given records against
+// the columns of the table named by the table_name parameter,
+// and their values for the datetime fields.
+func validateRecordFields(db dbType,
+	params map[string]string,
+	records []KVRecord) error {
+	cols, err := tableColumns(db, params["table_name"])
+	if err != nil {
+		return err
+	}
+	for i, rec := range records {
+		err = checkFields("keys", rec.Keys, cols)
+		if err == nil {
+			err = checkTimeValues(params, rec)
+		}
+		if err != nil {
+			return recordError{i, err}
+		}
+	}
+	return nil
+}
+
+// checkTimeValues() checks that the values of the given record for
+// the fields named by the time_fields parameter are times in one of
+// the accepted formats, and changes them to the form stored in the
+// database, so that they compare correctly.  null is allowed.
+func checkTimeValues(params map[string]string, rec KVRecord) error {
+	if params["time_fields"] == "" {
+		return nil
+	}
+	timeFields := listToMap(strings.Split(params["time_fields"], ","))
+	for i, k := range rec.Keys {
+		if timeFields[k] == 0 || rec.Values[i] == nil {
+			continue
+		}
+		str, ok := rec.Values[i].(string)
+		if !ok || str == "" {
+			return fmt.Errorf("field %s: invalid time %v",
+				k, rec.Values[i])
+		}
+		t, err := validateTime(str)
+		if err != nil {
+			return fmt.Errorf("field %s: %s", k, err)
+		}
+		rec.Values[i] = t
+	}
+	return nil
+}
+
+// checkFields() returns an error if any of the given fields is not
+// one of the given columns.  what tells where the fields came from.
+func checkFields(what string, fields []string, cols []string) error {
+	colmap := listToMap(cols)
+	for _, f := range fields {
+		if colmap[f] == 0 {
+			return unknownFieldError(what, f, cols)
+		}
+	}
+	return nil
+}
+
+// unknownFieldError() returns the error for a field that is not one
+// of the given columns of a table.  the message lists the columns,
+// so that the client can correct the request.
+func unknownFieldError(what string, field string, cols []string) error {
+	return fmt.Errorf("%s: unknown field %s (valid fields: %s)",
+		what, field, strings.Join(cols, ","))
+}
+
+// columnInfo describes a column of a table, from PRAGMA table_info.
+// dflt is the SQL expression for the column's default value, if any.
+type columnInfo struct {
+	name string
+	dtype string
+	notNull bool
+	dflt sql.NullString
+	pk int
+}
+
+// tableColumnInfo() returns the descriptions of the columns
+// of the given table, from the schema cache.
+func tableColumnInfo(db dbType, tabName string) ([]columnInfo, error) {
+	ct, err := lookupTable(db, tabName)
+	if err != nil {
+		return nil, err
+	}
+	if len(ct.cols) == 0 {
+		return nil, fmt.Errorf("no such table: %s", tabName)
+	}
+	return ct.cols, nil
+}
+
+// readColumnInfo() reads the descriptions of the columns of the
+// given table from the database.  there are none if there is no
+// such table.
+func readColumnInfo(db dbType, tabName string) ([]columnInfo, error) {
+	rows, err := db.runner().Query("PRAGMA table_info(" +
+		quoteIdent(tabName) + ")")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close() // nolint
+
+	ret := []columnInfo{}
+	for rows.Next() {
+		var cid, notnull int
+		var ci columnInfo
+		err = rows.Scan(&cid, &ci.name, &ci.dtype, &notnull,
+			&ci.dflt, &ci.pk)
+		if err != nil {
+			return ret, err
+		}
+		ci.notNull = notnull != 0
+		ret = append(ret, ci)
+	}
+	return ret, rows.Err()
+}
+
+// tableColumns() returns the names of the columns of the given table.
+func tableColumns(db dbType, tabName string) ([]string, error) {
+	cols, err := tableColumnInfo(db, tabName)
+	ret := make([]string, len(cols))
+	for i, ci := range cols {
+		ret[i] = ci.name
+	}
+	return ret, err
+}
+
+// mkWhereClause() returns the WHERE clause implied by the
+// id, ids, and filter parameters, and the values to be bound to it.
+func mkWhereClause(params map[string]string) (string, []interface{}, error) {
+	idclause, idlist := mkIdClause(params)
+	where, args, err := mkFilterClause(params, idclause, idlist)
+	return andSoftDelete(where, params), args, err
+}
+
 // mkSelectString() returns the WHERE part of a selection query.
 // insert an extra id field at the start of the list of fields,
 // to ensure that the id is one of the retrieved fields.
-func mkSelectString(params map[string]string) (string, []interface{}) {
-	idclause, idlist := mkIdClause(params)
+// if there is a cursor, only the records after it are selected.
+func mkSelectString(params map[string]string) (string, []interface{}, error) {
+	where, args, err := mkWhereClause(params)
+	if err != nil {
+		return "", args, err
+	}
+	cond, cargs, err := mkCursorClause(params)
+	if err != nil {
+		return "", args, err
+	}
+	if cond != "" {
+		where = andWhere(where, cond)
+		args = append(args, cargs...)
+	}
 
-	idfield := params["idfield"]
+	q := newSQL("SELECT ").ident(idFieldName(params)).
+		sql("," + quoteFields(params["fields"])).
+		sql(" FROM ").ident(params["table_name"]).
+		clause(where, args).
+		clause(mkOrderClause(params), nil).
+		sql(" LIMIT ").value(aToIdType(params["limit"])).
+		sql(" OFFSET ").value(aToIdType(params["offset"]))
+
+	return q.String(), q.args, nil
+}
+
+// idFieldName() returns the name of the id field from the
+// id_field parameter, defaulting to "id".
+func idFieldName(params map[string]string) string {
+	idfield := params["id_field"]
 	if idfield == "" {
 		idfield = "id"
 	}
-	xfields := idfield + "," + params["fields"]
-	qstring := fmt.Sprintf("SELECT %s FROM %s %s LIMIT %s OFFSET %s", // nolint
-		xfields,
-		params["table_name"],
-		idclause,
-		params["limit"],
-		params["offset"])
+	return idfield
+}
+
+// mkOrderClause() returns the ORDER BY clause implied by
+// the order parameter.  the id field is always the last sort key,
+// so that the order of the results is deterministic,
+// and paging thru them with offset is stable.
+func mkOrderClause(params map[string]string) string {
+	keys := sortKeys(params)
+	strs := make([]string, len(keys))
+	for i, oi := range keys {
+		dir := "ASC"
+		if oi.desc {
+			dir = "DESC"
+		}
+		strs[i] = quoteIdent(oi.field) + " " + dir
+	}
+	return "ORDER BY " + strings.Join(strs, ",")
+}
+
+// mkCountString() returns a query that counts the records
+// selected by the id, ids, and filter parameters.
+func mkCountString(params map[string]string) (string, []interface{}, error) {
+	where, args, err := mkWhereClause(params)
+	if err != nil {
+		return "", args, err
+	}
+	q := newSQL("SELECT count(*) FROM ").ident(params["table_name"]).
+		clause(where, args)
+	return q.String(), q.args, nil
+}
+
+// countRecords() returns the number of records selected by the
+// id, ids, and filter parameters, ignoring limit and offset.
+func countRecords(db dbType, params map[string]string) (int64, error) {
+	qstring, args, err := mkCountString(params)
+	if err != nil {
+		return 0, err
+	}
+	log.Debugf("query = %s", qstring)
+	var n int64
+	err = db.handle.QueryRow(qstring, args...).Scan(&n)
+	return n, err
+}
+
+// moreRecords() returns true iff there is at least one record
+// beyond the page selected by the limit and offset parameters.
+func moreRecords(db dbType, params map[string]string) (bool, error) {
+	xparams := map[string]string{}
+	for k, v := range params {
+		xparams[k] = v
+	}
+	xparams["fields"] = idFieldName(params)
+	xparams["limit"] = "1"
+	xparams["offset"] = idTypeToA(aToIdType(params["offset"]) +
+		aToIdType(params["limit"]))
+	qstring, args, err := mkSelectString(xparams)
+	if err != nil {
+		return false, err
+	}
+	result, err := runQuery(db, "", qstring, args)
+	return len(result) > 0, err
+}
+
+// mkPageLink() returns a link to the page at the given offset,
+// with the other query parameters unchanged.
+func mkPageLink(self string, query url.Values, offset int64) string {
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set("offset", idTypeToA(offset))
+	return self + "?" + q.Encode()
+}
+
+// mkCursorLink() returns a link to the page after the given cursor,
+// with the other query parameters unchanged.
+func mkCursorLink(self string, query url.Values, cursor string) string {
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Del("offset")
+	q.Set("cursor", cursor)
+	return self + "?" + q.Encode()
+}
+
+// setPageInfo() fills in the paging-related properties of resp:
+// the effective limit and offset, the total number of matching
+// records if include_count was requested, and if query is non-nil,
+// the cursor for the next page and the links to the adjacent pages.
+// when paging by cursor, the next link uses the cursor,
+// and there is no previous link.
+func setPageInfo(resp *RecordsResponse,
+	self string,
+	params map[string]string,
+	query url.Values) error {
+	limit := aToIdType(params["limit"])
+	offset := aToIdType(params["offset"])
+	resp.Limit = limit
+	resp.Offset = offset
+
+	nrecs := int64(len(resp.Records))
+	more := false
+	if params["include_count"] == "true" {
+		total, err := countRecords(db, params)
+		if err != nil {
+			return err
+		}
+		resp.Total = &total
+		more = offset + nrecs < total
+	}
+	if params["cursor"] != "" || params["include_count"] != "true" {
+		more = false
+		if nrecs >= limit {
+			var err error
+			more, err = moreRecords(db, params)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if query == nil {
+		return nil
+	}
+	if more && nrecs > 0 {
+		cursor, err := mkNextCursor(db, params,
+			resp.Records[nrecs-1].id)
+		if err != nil {
+			return err
+		}
+		resp.NextCursor = cursor
+	}
+	if params["cursor"] != "" {
+		if more {
+			resp.Next = mkCursorLink(self, query, resp.NextCursor)
+		}
+		return nil
+	}
+	if more {
+		resp.Next = mkPageLink(self, query, offset + nrecs)
+	}
+	if offset > 0 {
+		prev := offset - limit
+		if prev < 0 {
+			prev = 0
+		}
+		resp.Prev = mkPageLink(self, query, prev)
+	}
+	return nil
+}
+
+// tableSelf() returns the URL of the given table's records,
+// the prefix of the self links of the records.
+func tableSelf(harg *apiHandlerArg, tabName string) string {
+	u := harg.req.URL
+	return fmt.Sprintf("%s://%s%s%s/%s",
+		u.Scheme, u.Host, basePath, "/db/_table", tabName)
+}
+
+// fetchWriteParams() is like fetchParams(), for the APIs that write
+// records.  it also fetches the fields parameter, which is left empty
+// if it is not given, since by default those APIs return no records.
+func fetchWriteParams(harg *apiHandlerArg,
+	names ...string) (map[string]string, error) {
+	params, err := fetchParams(harg, append(names, "fields")...)
+	if harg.formValue("fields") == "" {
+		params["fields"] = ""
+	}
+	setCaller(harg, params)
+	return params, err
+}
+
+// matchingIds() returns the ids of the records that the id, ids,
+// and filter parameters select.
+func matchingIds(db dbType, params map[string]string) ([]int64, error) {
+	idclause, idlist := mkIdClause(params)
+	where, args, err := mkFilterClause(params, idclause, idlist)
+	if err != nil || where == "" {
+		return nil, err
+	}
+	return selectIds(db, params, andSoftDelete(where, params), args)
+}
 
-	return qstring, idlist
+// selectIds() returns the ids of the records that the given
+// WHERE clause selects.
+func selectIds(db dbType,
+	params map[string]string,
+	where string,
+	args []interface{}) ([]int64, error) {
+	q := newSQL("SELECT ").ident(idFieldName(params)).
+		sql(" FROM ").ident(params["table_name"]).
+		clause(where, args)
+	log.Debugf("query = %s", q)
+	rows, err := db.runner().Query(q.String(), q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close() // nolint
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// fetchRecords() returns the records with the given ids, with the
+// fields given by the fields parameter, in the order of the ids.
+// negative ids, for records that were not written, are skipped.
+// if the fields parameter is not given, it returns nil.
+func fetchRecords(db dbType,
+	self string,
+	params map[string]string,
+	ids []int64) ([]*KVResponse, error) {
+	if params["fields"] == "" {
+		return nil, nil
+	}
+	args := []interface{}{}
+	for _, id := range ids {
+		if id >= 0 {
+			args = append(args, id)
+		}
+	}
+	ret := []*KVResponse{}
+	if len(args) == 0 {
+		return ret, nil
+	}
+	idfield := idFieldName(params)
+	q := newSQL("SELECT ").ident(idfield).
+		sql("," + quoteFields(params["fields"])).
+		sql(" FROM ").ident(params["table_name"]).
+		sql(" WHERE ").ident(idfield).
+		sql(" in (").values(args).sql(")")
+	result, err := runQuery(db, self, q.String(), q.args)
+	if err == nil {
+		err = setEtags(db, params, result)
+	}
+	if err != nil {
+		return nil, err
+	}
+	byId := map[int64]*KVResponse{}
+	for _, rec := range result {
+		byId[rec.id] = rec
+	}
+	for _, id := range ids {
+		if rec, ok := byId[id]; ok {
+			ret = append(ret, rec)
+		}
+	}
+	return ret, nil
 }
 
 // getCommon() is common code for selection APIs.
-func getCommon(self string, params map[string]string) apiHandlerRet {
-	qstring, idlist := mkSelectString(params)
+// query holds the request's query parameters, for use in
+// page links; it may be nil if page links are not wanted.
+func getCommon(self string,
+	params map[string]string,
+	query url.Values) apiHandlerRet {
+	err := setTableOptions(db, params)
+	if err != nil {
+		return errorRet(dbErrorStatus(err), err, "after setTableOptions")
+	}
+	err = validateParamFields(db, params)
+	if err != nil {
+		return errorRet(badStat, err, "after validateParamFields")
+	}
+	qstring, idlist, err := mkSelectString(params)
+	if err != nil {
+		return errorRet(badStat, err, "after mkSelectString")
+	}
 	result, err := runQuery(db, self, qstring, idlist)
 	if err != nil {
 		return errorRet(badStat, err, "after runQuery")
@@ -575,10 +1832,17 @@ func getCommon(self string, params map[string]string) apiHandlerRet {
 	if len(result) == 0 {
 		return errorRet(badStat, fmt.Errorf("no matching record"), "")
 	}
+	err = setEtags(db, params, result)
+	if err != nil {
+		return errorRet(badStat, err, "after setEtags")
+	}
 
-	// TODO: support "page"-related properties
-	return apiHandlerRet{http.StatusOK,
-		RecordsResponse{Records: result, Kind: "Collection"}}
+	resp := RecordsResponse{Records: result, Kind: "Collection"}
+	err = setPageInfo(&resp, self, params, query)
+	if err != nil {
+		return errorRet(badStat, err, "after setPageInfo")
+	}
+	return apiHandlerRet{http.StatusOK, resp}
 }
 
 // updateCommon() is common code for update APIs.
@@ -592,12 +1856,368 @@ func updateCommon(harg *apiHandlerArg, params map[string]string) apiHandlerRet {
 			fmt.Errorf("update: no data records in body"), "")
 	}
 
-	ra, err := updateRec(db, params, body)
+	// the filter may come from the body instead of the URL.
+	if params["filter"] == "" && body.Filter != "" {
+		params["filter"], err = validate_filter(body.Filter)
+		if err != nil {
+			return errorRet(badStat, err, "after validate_filter")
+		}
+	}
+	err = validateRecords(body.Records)
 	if err != nil {
-		return errorRet(badStat, err, "after updateRec")
+		return errorRet(badStat, err, "after validateRecords")
+	}
+	err = setTableOptions(db, params)
+	if err != nil {
+		return errorRet(dbErrorStatus(err), err, "after setTableOptions")
+	}
+	err = validateParamFields(db, params)
+	if err == nil {
+		err = validateRecordFields(db, params, body.Records)
+	}
+	if err != nil {
+		return errorRet(badStat, err, "after validateRecordFields")
+	}
+	pre := getPreconditions(harg)
+
+	self := tableSelf(harg, params["table_name"])
+	_, hasId := params["id"]
+	if !hasId && params["ids"] == "" && params["filter"] == "" {
+		return updateEach(self, params, pre, body.Records)
+	}
+	if len(body.Records) != 1 {
+		return errorRet(badStat,
+			fmt.Errorf("update: only one record is allowed with id, ids, or filter"), "")
+	}
+
+	// the records to return are found before the update,
+	// which may change whether they match the filter.
+	var ra idType
+	var recs []*KVResponse
+	err = withTx(db, func(txdb dbType) error {
+		if hasId {
+			err := checkPreconditions(txdb, params, pre,
+				aToIdType(params["id"]))
+			if err != nil {
+				return err
+			}
+		}
+		ids, err := matchingIds(txdb, params)
+		if err != nil {
+			return err
+		}
+		if !hasId {
+			err = checkEachPrecondition(txdb, params, pre, ids)
+			if err != nil {
+				return err
+			}
+		}
+		err = recordChange(txdb, params, "update", ids,
+			func() ([]int64, error) {
+				var err error
+				ra, err = updateRec(txdb, params, body)
+				return nil, err
+			})
+		if err != nil {
+			return err
+		}
+		if hasId {
+			err = setEtagHeader(harg, txdb, params,
+				aToIdType(params["id"]))
+			if err != nil {
+				return err
+			}
+		}
+		recs, err = fetchRecords(txdb, self, params, ids)
+		return err
+	})
+	if err != nil {
+		return errorRet(dbErrorStatus(err), err, "after updateRec")
+	}
+	return apiHandlerRet{http.StatusOK,
+		NumChangedResponse{NumChanged: int64(ra),
+			Kind: "NumChangedResponse", Records: recs}}
+}
+
+// updateEach() is the part of updateCommon() for a body in which each
+// record has its own id field and its own changes.  the records are
+// updated in one transaction, and the number of records changed by
+// each is returned, 0 if there is no record with its id.
+func updateEach(self string,
+	params map[string]string,
+	pre preconditions,
+	records []KVRecord) apiHandlerRet {
+	idfield := idFieldName(params)
+	counts := make([]int64, 0, len(records))
+	ids := make([]int64, 0, len(records))
+	var total int64
+	var recs []*KVResponse
+	err := withTx(db, func(txdb dbType) error {
+		for i, rec := range records {
+			id, err := recordId(params, rec)
+			if err != nil {
+				return recordError{i, statusError{badStat, err}}
+			}
+			changes := KVRecord{}
+			for j, k := range rec.Keys {
+				if k != idfield {
+					changes.Keys = append(changes.Keys, k)
+					changes.Values = append(changes.Values,
+						rec.Values[j])
+				}
+			}
+			if len(changes.Keys) == 0 {
+				return recordError{i, statusError{badStat,
+					fmt.Errorf("no fields to update")}}
+			}
+			rparams := copyParams(params)
+			rparams["id"] = strconv.FormatInt(id, 10)
+			err = checkPreconditions(txdb, params, pre, id)
+			if err != nil {
+				return recordError{i, err}
+			}
+			var ra idType
+			err = recordChange(txdb, rparams, "update", []int64{id},
+				func() ([]int64, error) {
+					var err error
+					ra, err = updateRec(txdb, rparams,
+						BodyRecord{Records: []KVRecord{changes}})
+					return nil, err
+				})
+			if err != nil {
+				return recordError{i, err}
+			}
+			counts = append(counts, int64(ra))
+			ids = append(ids, id)
+			total += int64(ra)
+		}
+		var err error
+		recs, err = fetchRecords(txdb, self, params, ids)
+		return err
+	})
+	if err != nil {
+		return errorRet(dbErrorStatus(err), err, "after updateRec")
+	}
+	return apiHandlerRet{http.StatusOK,
+		NumChangedResponse{NumChanged: total, Counts: counts,
+			Kind: "NumChangedResponse", Records: recs}}
+}
+
+// copyParams() returns a copy of the given params map.
+func copyParams(params map[string]string) map[string]string {
+	ret := make(map[string]string, len(params))
+	for k, v := range params {
+		ret[k] = v
+	}
+	return ret
+}
+
+// restoreCommon() is common code for the restore APIs.
+func restoreCommon(harg *apiHandlerArg, params map[string]string) apiHandlerRet {
+	err := setTableOptions(db, params)
+	if err != nil {
+		return errorRet(dbErrorStatus(err), err, "after setTableOptions")
+	}
+	err = validateParamFields(db, params)
+	if err != nil {
+		return errorRet(badStat, err, "after validateParamFields")
+	}
+	setCaller(harg, params)
+	var nc idType
+	err = withTx(db, func(txdb dbType) error {
+		var err error
+		nc, err = restoreRecs(txdb, params)
+		return err
+	})
+	if err != nil {
+		return errorRet(dbErrorStatus(err), err, "after restoreRecs")
+	}
+	if _, single := params["id"]; single && nc == 0 {
+		return errorRet(http.StatusNotFound,
+			fmt.Errorf("no deleted record with %s %s",
+				idFieldName(params), params["id"]), "")
 	}
 	return apiHandlerRet{http.StatusOK,
-		NumChangedResponse{int64(ra), "NumChangedResponse"}}
+		NumChangedResponse{NumChanged: int64(nc),
+			Kind: "NumChangedResponse"}}
+}
+
+// replaceCommon() is common code for the replace APIs.
+// each record in the body replaces the record with the same id,
+// which comes from the record's id field or, for a single record,
+// from the id parameter.  all the records are replaced in one
+// transaction.  the status is 201 if any record was created.
+func replaceCommon(harg *apiHandlerArg, params map[string]string) apiHandlerRet {
+	body, err := getBodyRecord(harg)
+	if err != nil {
+		return errorRet(badStat, err, "after getBodyRecord")
+	}
+	records := body.Records
+	_, single := params["id"]
+	if len(records) < 1 || (single && len(records) != 1) {
+		return errorRet(badStat,
+			fmt.Errorf("replace: wrong number of records in body"), "")
+	}
+	err = validateRecords(records)
+	if err != nil {
+		return errorRet(badStat, err, "after validateRecords")
+	}
+	pre := getPreconditions(harg)
+	err = setTableOptions(db, params)
+	if err != nil {
+		return errorRet(dbErrorStatus(err), err, "after setTableOptions")
+	}
+	err = validateParamFields(db, params)
+	if err == nil {
+		err = validateRecordFields(db, params, records)
+	}
+	if err != nil {
+		return errorRet(badStat, err, "after validateRecordFields")
+	}
+
+	self := tableSelf(harg, params["table_name"])
+	code := http.StatusOK
+	ids := make([]int64, 0, len(records))
+	var recs []*KVResponse
+	err = withTx(db, func(txdb dbType) error {
+		cols, err := tableColumnInfo(txdb, params["table_name"])
+		if err != nil {
+			return err
+		}
+		for i, rec := range records {
+			id, err := recordId(params, rec)
+			if err != nil {
+				return recordError{i, statusError{badStat, err}}
+			}
+			err = checkPreconditions(txdb, params, pre, id)
+			if err != nil {
+				if single {
+					return err
+				}
+				return recordError{i, err}
+			}
+			var created bool
+			err = recordChange(txdb, params, "replace", []int64{id},
+				func() ([]int64, error) {
+					var err error
+					created, err = replaceRecord(txdb, params,
+						cols, id, rec)
+					return nil, err
+				})
+			if err != nil {
+				return recordError{i, err}
+			}
+			if created {
+				code = http.StatusCreated
+			}
+			ids = append(ids, id)
+		}
+		if single {
+			err = setEtagHeader(harg, txdb, params, ids[0])
+			if err != nil {
+				return err
+			}
+		}
+		recs, err = fetchRecords(txdb, self, params, ids)
+		return err
+	})
+	if err != nil {
+		return errorRet(dbErrorStatus(err), err, "after replaceRecord")
+	}
+	return apiHandlerRet{code,
+		NumChangedResponse{NumChanged: int64(len(ids)),
+			Kind: "NumChangedResponse", Records: recs}}
+}
+
+// recordId() returns the id of the given record, from its id field,
+// or if it has none, from the id parameter.  if both are given,
+// they must be the same.
+func recordId(params map[string]string, rec KVRecord) (int64, error) {
+	idfield := idFieldName(params)
+	pid, single := params["id"]
+	for i, k := range rec.Keys {
+		if k != idfield {
+			continue
+		}
+		id, ok := rec.Values[i].(int64)
+		if !ok {
+			return -1, fmt.Errorf("invalid %s value", idfield)
+		}
+		if single && id != aToIdType(pid) {
+			return -1, fmt.Errorf("%s does not match the id", idfield)
+		}
+		return id, nil
+	}
+	if !single {
+		return -1, fmt.Errorf("record has no %s field", idfield)
+	}
+	return aToIdType(pid), nil
+}
+
+// replaceRecord() replaces all the fields, other than the id field,
+// of the record with the given id.  the fields that are not in rec
+// are reset to their defaults, or to NULL.  if there is no such record,
+// it is created if create_if_missing is true, else that is a 404 error.
+// the boolean result tells whether the record was created.
+func replaceRecord(db dbType,
+	params map[string]string,
+	cols []columnInfo,
+	id int64,
+	rec KVRecord) (bool, error) {
+	idfield := idFieldName(params)
+	colmap := map[string]int{}
+	for _, col := range cols {
+		colmap[col.name] = 1
+	}
+	vals := map[string]interface{}{}
+	for i, k := range rec.Keys {
+		if colmap[k] == 0 {
+			return false, statusError{badStat,
+				fmt.Errorf("unknown field %s", k)}
+		}
+		vals[k] = rec.Values[i]
+	}
+	sets := []string{}
+	args := []interface{}{}
+	for _, col := range cols {
+		v, ok := vals[col.name]
+		switch {
+		case col.name == idfield:
+			continue
+		case ok:
+			sets = append(sets, quoteIdent(col.name) + " = ?")
+			args = append(args, v)
+		case col.dflt.Valid:
+			// the default is an expression from the table's schema.
+			sets = append(sets, quoteIdent(col.name) +
+				" = (" + col.dflt.String + ")")
+		default:
+			sets = append(sets, quoteIdent(col.name) + " = NULL")
+		}
+	}
+	if len(sets) == 0 {
+		sets = append(sets, quoteIdent(idfield) + " = " + quoteIdent(idfield))
+	}
+	q := newSQL("UPDATE ").ident(params["table_name"]).
+		sql(" SET " + strings.Join(sets, ", ")).
+		clause(andSoftDelete("WHERE " + quoteIdent(idfield) + " = ?",
+			params), append(args, id))
+	exres, err := runExec(db, q.String(), q.args)
+	if err != nil || exres.rowsAffected > 0 {
+		return false, err
+	}
+
+	if params["create_if_missing"] != "true" {
+		return false, statusError{http.StatusNotFound,
+			fmt.Errorf("no record with %s %d", idfield, id)}
+	}
+	keys, values := rec.Keys, rec.Values
+	if listToMap(keys)[idfield] == 0 {
+		keys = append([]string{idfield}, keys...)
+		values = append([]interface{}{id}, values...)
+	}
+	_, err = runInsert(db, params["table_name"], keys, values)
+	return err == nil, err
 }
 
 // convTableNames() converts the return format from runQuery()
@@ -635,79 +2255,316 @@ func validateRecords(records []KVRecord) error {
 	return nil
 }
 
-// convValues() converts masked *sql.RawBytes to masked strings.
+// convValues() converts masked *interface{}, as scanned from a row,
+// to masked values of the appropriate type for the declared column
+// types.  types may be shorter than vals, or nil, in which case
+// the values are converted according to their storage class.
 // the slice is changed in-place.
-func convValues(vals []interface{}) error {
+func convValues(vals []interface{}, types []string) error {
 	N := len(vals)
 	for i := 0; i < N; i++ {
 		v := vals[i]
-		rbp, ok := v.(*sql.RawBytes)
+		ip, ok := v.(*interface{})
 		if !ok {
 			return fmt.Errorf("SQL conversion error")
 		}
-		vals[i] = string(*rbp)
+		dtype := ""
+		if i < len(types) {
+			dtype = types[i]
+		}
+		vals[i] = convValue(*ip, dtype)
 	}
 	return nil
 }
 
-// listToMap() turns a list of property strings into a property map.
-func listToMap(strList []string) map[string]int {
-	ret := map[string]int{}
-	if strList == nil {
-		return ret
+// convValue() converts one value from the database according to the
+// declared type of its column.  the result is one of nil, int64,
+// float64, bool, string, or []byte (which is returned as base64 in JSON).
+func convValue(v interface{}, dtype string) interface{} {
+	aff := typeAffinity(dtype)
+	switch x := v.(type) {
+	case []byte:
+		if aff == "blob" {
+			return x
+		}
+		return string(x)
+	case int64:
+		switch aff {
+		case "boolean":
+			return x != 0
+		case "real":
+			return float64(x)
+		}
+	case time.Time:
+		return x.Format(time.RFC3339Nano)
+	case string:
+		// an expression such as max() of a time field has no
+		// declared type, so the driver leaves its value as text.
+		if timeTypes[strings.ToLower(dtype)] {
+			if t, ok := parseDbTime(x); ok {
+				return t.Format(time.RFC3339Nano)
+			}
+		}
 	}
-	for _, s := range strList {
-		ret[s] = 1
+	return v
+}
+
+// parseDbTime() parses a time as stored in the database,
+// in one of the formats that the sqlite driver converts to time.Time .
+func parseDbTime(s string) (time.Time, bool) {
+	s = strings.TrimSuffix(s, "Z")
+	for _, f := range sqlite3.SQLiteTimestampFormats {
+		t, err := time.ParseInLocation(f, s, time.UTC)
+		if err == nil {
+			return t, true
+		}
 	}
-	return ret
+	return time.Time{}, false
+}
+
+// typeAffinity() returns the sqlite type affinity of the given
+// declared column type, following the rules in the sqlite docs,
+// except that "boolean" is treated as its own affinity.
+func typeAffinity(dtype string) string {
+	dt := strings.ToUpper(dtype)
+	switch {
+	case dt == "BOOLEAN" || dt == "BOOL":
+		return "boolean"
+	case strings.Contains(dt, "INT"):
+		return "integer"
+	case strings.Contains(dt, "CHAR") ||
+		strings.Contains(dt, "CLOB") ||
+		strings.Contains(dt, "TEXT"):
+		return "text"
+	case dt == "" || strings.Contains(dt, "BLOB"):
+		return "blob"
+	case strings.Contains(dt, "REAL") ||
+		strings.Contains(dt, "FLOA") ||
+		strings.Contains(dt, "DOUB"):
+		return "real"
+	}
+	return "numeric"
 }
 
 // deleteTable() does the guts of table deletion.
 func deleteTable(tabName string) error {
+	defer invalidateTable(tabName)
+	err := checkTableRegistered(db, tabName)
+	if err != nil {
+		return err
+	}
+
 	// x1 deletes the actual table requested in the API.
-	x1 := newXCmd(fmt.Sprintf("drop table %s", tabName))
+	x1 := newSQL("drop table ").ident(tabName).xcmd()
 
 	// x2 deletes the table's entry in our internal table of tables.
 	x2 := newXCmd(fmt.Sprintf("delete from %s where (name) in (?)",
 		tableOfTables), tabName)
-	return execN(db, x1, x2)
-}
-
-// mkSchemaClause() constructs the SQL schema string
-// for the given list of fields.
-func mkSchemaClause(sch TableSchema) string {
-	var guts bytes.Buffer
-	sep := ""
-	for _, field := range sch.Fields {
-		guts.WriteString(sep)
-		guts.WriteString(field.Name)
-		props := listToMap(field.Properties)
-		// more properties should be added
-		if props["is_primary_key"] != 0 {
-			guts.WriteString(" integer primary key autoincrement")
-		} else {
-			guts.WriteString(" text not null")
-		}
-		sep = ", "
-	}
-	return guts.String()
+
+	// x3 and x4 delete the table's webhooks and their undelivered payloads.
+	x3 := newXCmd(fmt.Sprintf("delete from %s where hook_id in "+
+		"(select id from %s where table_name = ?)",
+		outboxTable, hooksTable), tabName)
+	x4 := newXCmd(fmt.Sprintf("delete from %s where table_name = ?",
+		hooksTable), tabName)
+	return execN(db, x1, x2, x3, x4)
 }
 
 // createTable() runs SQL commands to create a table.
 func createTable(params map[string]string, sch TableSchema) error {
 	tabName := params["table_name"]
 	log.Debugf("... tabName = %s, sch = %v", tabName, sch)
+	defer invalidateTable(tabName)
 
-	jschema, _ := json.Marshal(sch) // schema as json
-	fieldStr := mkSchemaClause(sch) // schema in SQL
+	sch, err := applySoftDelete(sch)
+	if err != nil {
+		return err
+	}
+	jschema, err := schemaText(sch) // schema as json
+	if err != nil {
+		return err
+	}
+	fieldStr, err := mkSchemaClause(sch) // schema in SQL
+	if err != nil {
+		return err
+	}
 
 	// x1 creates the actual table requested in the API.
-	x1 := newXCmd(fmt.Sprintf("create table %s(%s)", tabName, fieldStr))
+	x1 := newSQL("create table ").ident(tabName).
+		sql("(" + fieldStr + ")").xcmd()
 
 	// x2 updates our internal table of tables.
 	x2 := newXCmd(fmt.Sprintf("insert into %s (name,schema) values (?,?)",
 		tableOfTables), tabName, jschema)
-	return execN(db, x1, x2)
+
+	// the rest create the indexes.
+	icmds, err := mkIndexCmds(tabName, sch)
+	if err != nil {
+		return err
+	}
+	return execN(db, append([]*xCmd{x1, x2}, icmds...)...)
+}
+
+// getTableSchema() returns the schema of the given table,
+// as stored in the table of tables.  it is read from the database,
+// not the schema cache, for a change to the schema.
+func getTableSchema(db dbType, tabName string) (TableSchema, error) {
+	jschema, err := getSchemaText(db, tabName)
+	if err != nil {
+		return TableSchema{}, err
+	}
+	return parseSchema(tabName, jschema)
+}
+
+// getSchemaText() returns the schema of the given table, as the json
+// stored in the table of tables.
+func getSchemaText(db dbType, tabName string) (string, error) {
+	var jschema string
+	err := db.runner().QueryRow(fmt.Sprintf(
+		"select schema from %s where name = ?", tableOfTables),
+		tabName).Scan(&jschema)
+	if err == sql.ErrNoRows {
+		return "", noSuchTable(tabName)
+	}
+	return jschema, err
+}
+
+
+// noSuchTable() returns the 404 error for a table that is not
+// in the table of tables.
+func noSuchTable(tabName string) error {
+	return statusError{http.StatusNotFound,
+		fmt.Errorf("no such table %s", tabName)}
+}
+
+// checkTableRegistered() returns a 404 error if the given table is not
+// in the table of tables.  the table of tables is authoritative: a
+// table that exists in the database, but not there, cannot be used.
+func checkTableRegistered(db dbType, tabName string) error {
+	_, err := lookupTable(db, tabName)
+	return err
+}
+
+// setTableOptions() sets the entries of params for the options in
+// the schema of the table named by the table_name parameter:
+// soft_delete and audit are set to "true" if the table has them.
+// time_fields is set to the names of the table's datetime fields,
+// and not_null_fields to the names of the fields that cannot be NULL.
+// returns a 404 error if the table is not in the table of tables.
+// a table without a usable schema has no options.
+func setTableOptions(db dbType, params map[string]string) error {
+	ct, err := lookupTable(db, params["table_name"])
+	if err != nil {
+		return err
+	}
+	timeFields := []string{}
+	notNullFields := []string{}
+	for _, ci := range ct.cols {
+		if timeTypes[strings.ToLower(ci.dtype)] {
+			timeFields = append(timeFields, ci.name)
+		}
+		// an integer primary key is the rowid, which is never NULL.
+		if ci.notNull ||
+			(ci.pk > 0 && strings.EqualFold(ci.dtype, "integer")) {
+			notNullFields = append(notNullFields, ci.name)
+		}
+	}
+	params["time_fields"] = strings.Join(timeFields, ",")
+	params["not_null_fields"] = strings.Join(notNullFields, ",")
+	if ct.schErr != nil {
+		return nil
+	}
+	if ct.sch.SoftDelete {
+		params["soft_delete"] = "true"
+	}
+	if ct.sch.Audit {
+		params["audit"] = "true"
+	}
+	return nil
+}
+
+// alterTable() runs SQL commands to make the given changes to a table,
+// and to update its schema in the table of tables, in one transaction.
+func alterTable(params map[string]string, req AlterTableRequest) error {
+	tabName := params["table_name"]
+	defer invalidateTable(tabName)
+	sch, err := getTableSchema(db, tabName)
+	if err != nil {
+		return err
+	}
+	nsch, fromMap, err := alterSchema(sch, req)
+	if err != nil {
+		return err
+	}
+	cmds, err := mkAlterCmds(tabName, nsch, fromMap, req)
+	if err != nil {
+		return err
+	}
+	ucmd, err := mkSchemaUpdateCmd(tabName, nsch)
+	if err != nil {
+		return err
+	}
+	return execNWithoutFKs(db, tabName, append(cmds, ucmd)...)
+}
+
+// mkSchemaUpdateCmd() returns the SQL command that stores
+// the given schema for the given table in the table of tables,
+// after checking it.
+func mkSchemaUpdateCmd(tabName string, sch TableSchema) (*xCmd, error) {
+	jschema, err := schemaText(sch)
+	if err != nil {
+		return nil, err
+	}
+	return newXCmd(fmt.Sprintf("update %s set schema = ? where name = ?",
+		tableOfTables), jschema, tabName), nil
+}
+
+// createIndex() runs SQL commands to create an index on a table,
+// and to record it in the table's schema, in one transaction.
+func createIndex(params map[string]string, idx IndexSchema) error {
+	tabName := params["table_name"]
+	defer invalidateTable(tabName)
+	sch, err := getTableSchema(db, tabName)
+	if err != nil {
+		return err
+	}
+	err = validateIndex(sch, idx)
+	if err != nil {
+		return err
+	}
+	sch.Indexes = append(sch.Indexes, idx)
+	ucmd, err := mkSchemaUpdateCmd(tabName, sch)
+	if err != nil {
+		return err
+	}
+	return execN(db, mkIndexCmd(tabName, idx), ucmd)
+}
+
+// deleteIndex() runs SQL commands to drop an index from a table,
+// and to remove it from the table's schema, in one transaction.
+func deleteIndex(params map[string]string) error {
+	tabName := params["table_name"]
+	defer invalidateTable(tabName)
+	indexName := params["index_name"]
+	sch, err := getTableSchema(db, tabName)
+	if err != nil {
+		return err
+	}
+	i := indexIndex(sch, indexName)
+	if i < 0 {
+		return fmt.Errorf("no such index %s", indexName)
+	}
+	sch.Indexes = append(sch.Indexes[:i], sch.Indexes[i+1:]...)
+	if len(sch.Indexes) == 0 {
+		sch.Indexes = nil
+	}
+	ucmd, err := mkSchemaUpdateCmd(tabName, sch)
+	if err != nil {
+		return err
+	}
+	x1 := newSQL("drop index ").
+		ident(sqlIndexName(tabName, indexName)).xcmd()
+	return execN(db, x1, ucmd)
 }
 
 // newXCmd() constructs an xCmd object from the given string and arguments.
@@ -717,17 +2574,14 @@ func newXCmd(cmd string, args ...interface{}) *xCmd {
 
 // execN() runs multiple execs as a transaction.
 func execN(db dbType, cmdList ...*xCmd) error {
-	tx, err := db.handle.Begin()
-	if err != nil {
-		return err
-	}
-	for i, xCmd := range cmdList {
-		log.Debugf("cmd%d = %s", i, xCmd)
-		_, err = tx.Exec(xCmd.cmd, xCmd.args...)
-		if err != nil {
-			_ = tx.Rollback()
-			return err
+	return withTx(db, func(txdb dbType) error {
+		for i, xCmd := range cmdList {
+			log.Debugf("cmd%d = %s", i, xCmd)
+			_, err := txdb.tx.Exec(xCmd.cmd, xCmd.args...)
+			if err != nil {
+				return err
+			}
 		}
-	}
-	return tx.Commit()
+		return nil
+	})
 }
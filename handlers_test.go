@@ -3,11 +3,10 @@ package apidCRUD
 import (
 	"testing"
 	"fmt"
-	"strconv"
 	"strings"
 	"sort"
 	"net/http"
-	"database/sql"
+	"time"
 )
 
 // mySplit() is like strings.Split() except that
@@ -29,7 +28,7 @@ func mkSQLRow_Checker(cx *testContext, N int) {
 	res := mkSQLRow(N)
 	cx.assertEqual(N, len(res), "number of rows")
 	for _, v := range res {
-		_, ok := v.(*sql.RawBytes)
+		_, ok := v.(*interface{})
 		if !cx.assertTrue(ok, "sql conversion error") {
 			return
 		}
@@ -189,9 +188,9 @@ type idclause_TC struct {
 }
 
 var idclause_Tab = []idclause_TC {
-	{ "id_field=id&id=123", "WHERE id = ?", "123", true },
-	{ "id_field=id&ids=123", "WHERE id in (?)", "123", true },
-	{ "id_field=id&ids=123,456", "WHERE id in (?,?)", "123,456", true },
+	{ "id_field=id&id=123", "WHERE `id` = ?", "123", true },
+	{ "id_field=id&ids=123", "WHERE `id` in (?)", "123", true },
+	{ "id_field=id&ids=123,456", "WHERE `id` in (?,?)", "123,456", true },
 	{ "id_field=id", "", "", true },
 }
 
@@ -240,29 +239,6 @@ func Test_mkIdClause(t *testing.T) {
 	}
 }
 
-// ----- unit tests for mkIdClauseUpdate()
-
-var mkIdClauseUpdate_Tab = []idclause_TC {
-	{ "id_field=id&id=123", "WHERE id = 123", "", true },
-	{ "id_field=id&ids=123", "WHERE id in (123)", "", true },
-	{ "id_field=id&ids=123,456", "WHERE id in (123,456)", "", true },
-	{ "id_field=id", "", "", true },
-}
-
-func mkIdClauseUpdate_Checker(cx *testContext, tc *idclause_TC) {
-	params := fakeParams(tc.paramstr)
-	res := mkIdClauseUpdate(params)
-	cx.assertEqual(tc.xres, res, "result")
-}
-
-func Test_mkIdClauseUpdate(t *testing.T) {
-	cx := newTestContext(t, "mkIdClauseUpdate_Tab")
-	for _, tc := range mkIdClauseUpdate_Tab {
-		mkIdClauseUpdate_Checker(cx, &tc)
-		cx.bump()
-	}
-}
-
 // ----- unit tests for idTypesToInterface()
 
 type idTypesToInterface_TC struct {
@@ -306,17 +282,35 @@ type mkSelectString_TC struct {
 
 var mkSelectString_Tab = []mkSelectString_TC {
 	{"table_name=T&id_field=id&id=456&fields=a&limit=1&offset=0",
-		"SELECT id,a FROM T WHERE id = ? LIMIT 1 OFFSET 0",
-		"456", true},
+		"SELECT `id`,`a` FROM `T` WHERE `id` = ? ORDER BY `id` ASC LIMIT ? OFFSET ?",
+		"456,1,0", true},
 	{"table_name=T&id_field=id&ids=123,456&fields=a,b,c&limit=1&offset=0",
-		"SELECT id,a,b,c FROM T WHERE id in (?,?) LIMIT 1 OFFSET 0",
-		"123,456", true},
+		"SELECT `id`,`a`,`b`,`c` FROM `T` WHERE `id` in (?,?) ORDER BY `id` ASC LIMIT ? OFFSET ?",
+		"123,456,1,0", true},
+	{"table_name=T&id_field=id&ids=123&fields=a&limit=1&offset=0&filter=a > 7",
+		"SELECT `id`,`a` FROM `T` WHERE `id` in (?) AND `a` > ? ORDER BY `id` ASC LIMIT ? OFFSET ?",
+		"123,7,1,0", true},
+	{"table_name=T&id_field=id&fields=a&limit=1&offset=0&filter=a > 7 OR a < 3",
+		"SELECT `id`,`a` FROM `T` WHERE (`a` > ? OR `a` < ?) ORDER BY `id` ASC LIMIT ? OFFSET ?",
+		"7,3,1,0", true},
+	{"table_name=T&id_field=id&fields=a&limit=1&offset=0&filter=a >",
+		"",
+		"", false},
+	{"table_name=T&id_field=id&fields=a&limit=1&offset=0&order=a DESC",
+		"SELECT `id`,`a` FROM `T` ORDER BY `a` DESC,`id` ASC LIMIT ? OFFSET ?",
+		"1,0", true},
+	{"table_name=T&id_field=key&fields=a&limit=1&offset=0&order=key DESC,a ASC",
+		"SELECT `key`,`a` FROM `T` ORDER BY `key` DESC,`a` ASC LIMIT ? OFFSET ?",
+		"1,0", true},
 }
 
 // run one tc case
 func mkSelectString_Checker(cx *testContext, tc *mkSelectString_TC) {
 	params := fakeParams(tc.paramstr)
-	res, idlist := mkSelectString(params)
+	res, idlist, err := mkSelectString(params)
+	if !cx.assertEqual(tc.xsucc, err == nil, "success") || !tc.xsucc {
+		return
+	}
 	if !cx.assertEqual(tc.xres, res, "result") {
 		return
 	}
@@ -442,7 +436,7 @@ func mimicTableNamesQuery(names []string) []*KVResponse {
 			ival = interface{}(val)
 		}
 		Values := []interface{}{ival}
-		ret[i] = &KVResponse{Keys, Values, "KVResponse", ""}
+		ret[i] = &KVResponse{Keys: Keys, Values: Values, Kind: "KVResponse"}
 	}
 	return ret
 }
@@ -513,43 +507,48 @@ func Test_validateRecords(t *testing.T) {
 
 // inputs and outputs for one convValues testcase.
 type convValues_TC struct {
-	arg string
-	xres bool
+	vals []interface{}
+	types string
+	xvals []interface{}
 }
 
 // table of convValues testcases.
 var convValues_Tab = []convValues_TC {
-	{ "", true },
-	{ "abc", true },
-	{ "abc,def", true },
-	{ "abc,def,ghi", true },
-	{ "1,def,ghi", false },
-}
-
-func strToSQLValues(arg string) []interface{} {
-	args := mySplit(arg, ",")
-	N := len(args)
-	ret := make([]interface{}, N)
-	for i, s := range args {
-		j, err := strconv.Atoi(s)
-		if err == nil {
-			// an unconvertible value
-			ret[i] = &j
-		} else {
-			rb := sql.RawBytes(s)
-			ret[i] = &rb;
-		}
+	{ []interface{}{}, "", []interface{}{} },
+	{ []interface{}{"abc", "def"}, "text,text",
+		[]interface{}{"abc", "def"} },
+	{ []interface{}{int64(1), 2.5, nil}, "integer,real,text",
+		[]interface{}{int64(1), 2.5, nil} },
+	{ []interface{}{int64(3)}, "real", []interface{}{float64(3)} },
+	{ []interface{}{int64(0), int64(1), true}, "boolean,BOOLEAN,boolean",
+		[]interface{}{false, true, true} },
+	{ []interface{}{[]byte("ab"), []byte("cd")}, "blob,varchar(10)",
+		[]interface{}{[]byte("ab"), "cd"} },
+	{ []interface{}{[]byte("ab"), int64(1)}, "",
+		[]interface{}{[]byte("ab"), int64(1)} },
+	{ []interface{}{time.Date(2017, 3, 4, 5, 6, 7, 0, time.UTC)},
+		"datetime",
+		[]interface{}{"2017-03-04T05:06:07Z"} },
+}
+
+// sqlValues() returns the given values masked as *interface{},
+// as they would be after being scanned from a row.
+func sqlValues(vals []interface{}) []interface{} {
+	ret := mkSQLRow(len(vals))
+	for i, v := range vals {
+		*(ret[i].(*interface{})) = v
 	}
 	return ret
 }
 
 // run one testcase for function convValues.
 func convValues_Checker(cx *testContext, tc *convValues_TC) {
-	argInter := strToSQLValues(tc.arg)
-	err := convValues(argInter)
-	ok := (err == nil &&
-		tc.arg == strings.Join(unmaskStrings(argInter), ","))
-	cx.assertEqual(tc.xres, ok, "conversion result")
+	vals := sqlValues(tc.vals)
+	err := convValues(vals, mySplit(tc.types, ","))
+	if !cx.assertErrorNil(err, "convValues") {
+		return
+	}
+	cx.assertEqualObj(tc.xvals, vals, "converted values")
 }
 
 // main test suite for convValues().
@@ -561,6 +560,49 @@ func Test_convValues(t *testing.T) {
 	}
 }
 
+// convValues should fail on a value that was not scanned.
+func Test_convValues_bad(t *testing.T) {
+	cx := newTestContext(t, "convValues_bad")
+	vals := []interface{}{new(interface{}), 1}
+	err := convValues(vals, nil)
+	cx.assertTrue(err != nil, "convValues should fail")
+}
+
+// ----- unit tests for typeAffinity()
+
+// inputs and outputs for one typeAffinity testcase.
+type typeAffinity_TC struct {
+	dtype string
+	xaff string
+}
+
+// table of typeAffinity testcases.
+var typeAffinity_Tab = []typeAffinity_TC {
+	{"integer", "integer"},
+	{"INT", "integer"},
+	{"bigint", "integer"},
+	{"text", "text"},
+	{"varchar(10)", "text"},
+	{"CLOB", "text"},
+	{"blob", "blob"},
+	{"", "blob"},
+	{"real", "real"},
+	{"double precision", "real"},
+	{"float", "real"},
+	{"boolean", "boolean"},
+	{"datetime", "numeric"},
+	{"decimal(10,5)", "numeric"},
+}
+
+// the typeAffinity test suite.
+func Test_typeAffinity(t *testing.T) {
+	cx := newTestContext(t, "typeAffinity_Tab")
+	for _, tc := range typeAffinity_Tab {
+		cx.assertEqual(tc.xaff, typeAffinity(tc.dtype), tc.dtype)
+		cx.bump()
+	}
+}
+
 // ----- unit tests for support for testing of api calls
 
 type apiCall_TC struct {
@@ -678,7 +720,7 @@ var createDbRecords_Tab = []apiCall_TC {
 		deleteDbRecordHandler,
 		http.MethodDelete,
 		`/test/db/_table/tabname|table_name=bogus|id=1`,
-		http.StatusBadRequest, noCheck},
+		http.StatusNotFound, noCheck},
 
 	{"get record 2 expecting failure",
 		getDbRecordHandler,
@@ -726,7 +768,7 @@ var createDbRecords_Tab = []apiCall_TC {
 		updateDbRecordsHandler,
 		http.MethodPatch,
 		`/test/db/_table/tabname|table_name=bogus&id=1||{"records":[{"keys":["name", "uri"], "values":["name9", "uri9"]}]}`,
-		http.StatusBadRequest, noCheck},
+		http.StatusNotFound, noCheck},
 
 	{"update records bogus field name",
 		updateDbRecordsHandler,
@@ -832,7 +874,7 @@ func getDbTables_Checker(cx *testContext, tc *apiCall_TC) {
 	// if the code was success, data should be of this type.
 	data, ok := result.data.(TablesResponse)
 	cx.assertTrue(ok, "TablesResponse data type")
-	xtabNames := "bundles,nothing,users"
+	xtabNames := "bundles,nothing,toomany,typed,users,xxx"
 	dataNames := data.Names
 	sort.Strings(dataNames)
 	resNames := strings.Join(dataNames, ",")
@@ -949,9 +991,7 @@ func retrieveValues(cx *testContext,
 	if !cx.assertEqual(1, nr, "number of records") {
 		return nil, false
 	}
-	ivals := recs[0].Values
-	_ = convValues(ivals)
-	return unmaskStrings(ivals), true
+	return unmaskStrings(recs[0].Values), true
 }
 
 // ----- unit tests for updateDbRecordsHandler()
@@ -1043,7 +1083,6 @@ func readNamesWithOffset(cx *testContext,
 	// grab the name field from each record
 	ret = make([]string, len(resp.Records))
 	for i, rec := range resp.Records {
-		_ = convValues(rec.Values)
 		svals := unmaskStrings(rec.Values)
 		ret[i] = svals[0]
 	}
@@ -1072,6 +1111,259 @@ func Test_getDbRecordHandler_offset(t *testing.T) {
 	cx.assertEqualObj(xnames, names, "names from #2 batch")
 }
 
+// ----- unit tests for the page-related properties of getDbRecordsHandler()
+
+// inputs and outputs for one getDbRecords paging testcase.
+// xtotal is -1 if no total is expected.
+type paging_TC struct {
+	query string
+	xlimit int64
+	xoffset int64
+	xnrecs int
+	xtotal int64
+	xnext string
+	xprev string
+}
+
+// table of paging testcases, all on the table toomany (16 records).
+var paging_Tab = []paging_TC {
+	{"fields=name&limit=5&offset=5&include_count=true", 5, 5, 5, 16,
+		"fields=name&include_count=true&limit=5&offset=10",
+		"fields=name&include_count=true&limit=5&offset=0"},
+	{"limit=5&offset=14&include_count=true", 5, 14, 2, 16,
+		"",
+		"include_count=true&limit=5&offset=9"},
+	{"limit=5&offset=11", 5, 11, 5, -1,
+		"",
+		"limit=5&offset=6"},
+	{"limit=5&offset=2", 5, 2, 5, -1,
+		"limit=5&offset=7",
+		"limit=5&offset=0"},
+	// limit is clamped to apidCRUD_max_recs (7) from utConfData.
+	{"limit=100", 7, 0, 7, -1,
+		"limit=100&offset=7",
+		""},
+}
+
+// run one paging testcase.
+func paging_Checker(cx *testContext, tc *paging_TC) {
+	self := "http://localhost/test/db/_table/toomany"
+	argDesc := self + "|table_name=toomany|" + tc.query
+	result := callApiHandler(getDbRecordsHandler, http.MethodGet, argDesc)
+	if !cx.assertEqual(http.StatusOK, result.code, "returned code") {
+		return
+	}
+	resp, ok := result.data.(RecordsResponse)
+	if !cx.assertTrue(ok, "data of type RecordsResponse") {
+		return
+	}
+	cx.assertEqual(tc.xlimit, resp.Limit, "limit")
+	cx.assertEqual(tc.xoffset, resp.Offset, "offset")
+	cx.assertEqual(tc.xnrecs, len(resp.Records), "number of records")
+	if tc.xtotal < 0 {
+		cx.assertTrue(resp.Total == nil, "total should be absent")
+	} else if cx.assertTrue(resp.Total != nil, "total should be present") {
+		cx.assertEqual(tc.xtotal, *resp.Total, "total")
+	}
+	xnext := ""
+	if tc.xnext != "" {
+		xnext = self + "?" + tc.xnext
+	}
+	cx.assertEqual(xnext, resp.Next, "next link")
+	xprev := ""
+	if tc.xprev != "" {
+		xprev = self + "?" + tc.xprev
+	}
+	cx.assertEqual(xprev, resp.Prev, "prev link")
+}
+
+// the paging test suite.  run all paging testcases.
+func Test_getDbRecordsHandler_paging(t *testing.T) {
+	cx := newTestContext(t, "paging_Tab")
+	for _, tc := range paging_Tab {
+		paging_Checker(cx, &tc)
+		cx.bump()	// increment testno.
+	}
+}
+
+// ----- unit tests for paging thru getDbRecordsHandler() by cursor
+
+// walkPages() gets all the pages of the given table with the
+// given query, following either the cursor or the offset,
+// and returns the self links of the records in order.
+func walkPages(cx *testContext,
+	tabName string,
+	query string,
+	byCursor bool) []string {
+	self := "http://localhost/test/db/_table/" + tabName
+	ret := []string{}
+	q := query
+	for npages := 0; npages < 10; npages++ {
+		argDesc := self + "|table_name=" + tabName + "|" + q
+		result := callApiHandler(getDbRecordsHandler,
+			http.MethodGet, argDesc)
+		if !cx.assertEqual(http.StatusOK, result.code, "returned code") {
+			return ret
+		}
+		resp := result.data.(RecordsResponse)
+		for _, rec := range resp.Records {
+			ret = append(ret, rec.Self)
+		}
+		if resp.Next == "" {
+			cx.assertEqual("", resp.NextCursor, "nextCursor on last page")
+			return ret
+		}
+		if byCursor {
+			q = query + "&cursor=" + resp.NextCursor
+		} else {
+			q = query + "&offset=" + idTypeToA(int64(len(ret)))
+		}
+	}
+	cx.Errorf("too many pages")
+	return ret
+}
+
+// orders to be used for walking the table.
+var cursorPaging_Tab = []string {
+	"limit=5",
+	"limit=5&order=name DESC",
+	"limit=3&order=uri ASC,name DESC",
+	"limit=7&order=id DESC",
+}
+
+// the cursor paging test suite.  paging by cursor should return
+// the same records, in the same order, as paging by offset.
+func Test_getDbRecordsHandler_cursor(t *testing.T) {
+	cx := newTestContext(t, "cursorPaging_Tab")
+	for _, query := range cursorPaging_Tab {
+		xrecs := walkPages(cx, "toomany", query, false)
+		recs := walkPages(cx, "toomany", query, true)
+		cx.assertEqual(16, len(xrecs), "number of records by offset")
+		cx.assertEqualObj(xrecs, recs, "records by cursor")
+		cx.bump()	// increment testno.
+	}
+}
+
+// paging by cursor on a datetime field, whose values are returned
+// in a different format than they are stored, should return the
+// same records as paging by offset.
+func Test_getDbRecordsHandler_cursorDatetime(t *testing.T) {
+	cx := newTestContext(t)
+	res := callApiHandler(createDbTableHandler, http.MethodPost,
+		`/test/db/_schema/CDT|table_name=CDT||{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"d","db_type":"datetime"}]}`)
+	cx.assertEqual(http.StatusCreated, res.code, "create table CDT")
+	defer callApiHandler(deleteDbTableHandler, http.MethodDelete,
+		`/test/db/_schema/CDT|table_name=CDT`)
+	recs := []string{}
+	for i := 6; i > 0; i-- {
+		recs = append(recs, fmt.Sprintf(
+			`{"keys":["d"],"values":["2017-03-01 02:06:0%d"]}`, i))
+	}
+	res = callApiHandler(createDbRecordsHandler, http.MethodPost,
+		`/test/db/_table/CDT|table_name=CDT||{"records":[` +
+		strings.Join(recs, ",") + `]}`)
+	cx.assertEqual(http.StatusCreated, res.code, "create records")
+
+	query := "order=d&limit=2"
+	xrecs := walkPages(cx, "CDT", query, false)
+	cx.assertEqual(6, len(xrecs), "number of records by offset")
+	cx.assertEqualObj(xrecs, walkPages(cx, "CDT", query, true),
+		"records by cursor")
+}
+
+// values of datetime fields must be times in one of the accepted
+// formats, and are stored in UTC.
+func Test_datetimeValues(t *testing.T) {
+	cx := newTestContext(t)
+	res := callApiHandler(createDbTableHandler, http.MethodPost,
+		`/test/db/_schema/DTV|table_name=DTV||{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"d","db_type":"datetime","allow_null":true}]}`)
+	cx.assertEqual(http.StatusCreated, res.code, "create table DTV")
+	defer callApiHandler(deleteDbTableHandler, http.MethodDelete,
+		`/test/db/_schema/DTV|table_name=DTV`)
+
+	tab := []apiCall_TC {
+		{"create record w/ time",
+			createDbRecordsHandler,
+			http.MethodPost,
+			`/test/db/_table/DTV|table_name=DTV||{"records":[{"keys":["d"],"values":["2017-03-01T02:06:00+01:00"]},{"keys":["d"],"values":[null]}]}`,
+			http.StatusCreated, noCheck},
+		{"create record w/ invalid time",
+			createDbRecordsHandler,
+			http.MethodPost,
+			`/test/db/_table/DTV|table_name=DTV||{"records":[{"keys":["d"],"values":["yesterday"]}]}`,
+			http.StatusBadRequest,
+			`{"code":400,"message":"record 0: field d: invalid time yesterday","kind":"ErrorResponse"}`},
+		{"create record w/ number for time",
+			createDbRecordsHandler,
+			http.MethodPost,
+			`/test/db/_table/DTV|table_name=DTV||{"records":[{"keys":["d"],"values":[12]}]}`,
+			http.StatusBadRequest, noCheck},
+		{"create records w/ invalid time and atomic=false",
+			createDbRecordsHandler,
+			http.MethodPost,
+			`/test/db/_table/DTV|table_name=DTV|atomic=false|{"records":[{"keys":["d"],"values":["2017-03-02"]},{"keys":["d"],"values":[""]}]}`,
+			http.StatusMultiStatus, noCheck},
+		{"update record w/ invalid time",
+			updateDbRecordHandler,
+			http.MethodPatch,
+			`/test/db/_table/DTV|table_name=DTV&id=1||{"records":[{"keys":["d"],"values":["2017-13-01"]}]}`,
+			http.StatusBadRequest, noCheck},
+		{"replace record w/ invalid time",
+			replaceDbRecordHandler,
+			http.MethodPut,
+			`/test/db/_table/DTV|table_name=DTV&id=1||{"records":[{"keys":["d"],"values":["03/01/2017"]}]}`,
+			http.StatusBadRequest, noCheck},
+		{"get records w/ times",
+			getDbRecordsHandler,
+			http.MethodGet,
+			`/test/db/_table/DTV|table_name=DTV|fields=d`,
+			http.StatusOK, noCheck},
+	}
+	results := make([]apiHandlerRet, len(tab))
+	for i, tc := range tab {
+		results[i] = apiCall_Checker(cx, &tc)
+		cx.bump()
+	}
+	resp, ok := results[len(tab)-1].data.(RecordsResponse)
+	if cx.assertTrue(ok, "records response") {
+		vals := []interface{}{}
+		for _, rec := range resp.Records {
+			vals = append(vals, rec.Values[0])
+		}
+		cx.assertEqualObj([]interface{}{"2017-03-01T01:06:00Z", nil,
+			"2017-03-02T00:00:00Z"}, vals, "stored times")
+	}
+}
+
+// table of getDbRecords testcases with cursors.
+var cursorCalls_Tab = []apiCall_TC {
+	{"get records w/ invalid cursor",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`/test/db/_table/toomany|table_name=toomany|cursor=bogus!`,
+		http.StatusBadRequest, noCheck},
+	{"get records w/ cursor for a different order",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`/test/db/_table/toomany|table_name=toomany|order=name&cursor=eyJvIjoiaWQgQVNDIiwiayI6W10sImkiOjV9`,
+		http.StatusBadRequest, noCheck},
+	{"get records w/ cursor and offset",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`/test/db/_table/toomany|table_name=toomany|offset=2&cursor=eyJvIjoiaWQgQVNDIiwiayI6W10sImkiOjV9`,
+		http.StatusBadRequest, noCheck},
+	{"get records w/ cursor",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`/test/db/_table/toomany|table_name=toomany|fields=name&limit=2&cursor=eyJvIjoiaWQgQVNDIiwiayI6W10sImkiOjV9`,
+		http.StatusOK, noCheck},
+}
+
+// the cursor calls test suite.
+func Test_getDbRecordsHandler_cursorCalls(t *testing.T) {
+	apiCalls_Runner(t, "cursorCalls_Tab", cursorCalls_Tab)
+}
+
 // ----- unit tests for createDbTableHandler()
 
 var users_schema = `{"fields":[{"name":"id","properties":["is_primary_key","int32"]},{"name":"uri","properties":[]},{"name":"name","properties":[]}]}`
@@ -1093,6 +1385,16 @@ var createDbTable_Tab = []apiCall_TC {
 		http.MethodPost,
 		`/test/db/_schema/ABC|table_name=ABC||bogus`+users_schema,
 		http.StatusBadRequest, noCheck},
+	{"create table w/ unknown schema key",
+		createDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/ABC|table_name=ABC||{"files":[{"name":"line"}]}`,
+		http.StatusBadRequest, noCheck},
+	{"create table w/ duplicate field",
+		createDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/ABC|table_name=ABC||{"fields":[{"name":"a"},{"name":"a"}]}`,
+		http.StatusBadRequest, noCheck},
 	{"create table ABC expecting success",
 		createDbTableHandler,
 		http.MethodPost,
@@ -1125,130 +1427,964 @@ func Test_createDbTableHandler(t *testing.T) {
 	apiCalls_Runner(t, "createDbTable_Tab", createDbTable_Tab)
 }
 
-// ----- unit tests for deleteDbTableHandler()
+// a schema using all the FieldSchema properties, in canonical form.
+var full_schema = `{"fields":[{"name":"id","db_type":"integer","auto_increment":true,"is_primary_key":true},{"name":"name","db_type":"text","length":5},{"name":"score","db_type":"real","allow_null":true},{"name":"active","db_type":"boolean","default":true},{"name":"data","db_type":"blob","allow_null":true},{"name":"seen","db_type":"datetime","allow_null":true,"default":"2017-01-02 03:04:05"}]}`
 
-// table of deleteDbTableHandler testcases.
-var deleteDbTable_Tab = []apiCall_TC {
-	{"delete table ABC missing table_name",
-		deleteDbTableHandler,
-		http.MethodDelete,
-		`/test/db/_schema`,
+// table of createDbTable testcases using the full FieldSchema.
+var createDbTableFull_Tab = []apiCall_TC {
+	{"create table FULL",
+		createDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/FULL|table_name=FULL||`+full_schema,
+		http.StatusCreated, noCheck},
+	{"describe table FULL returns the schema as given",
+		describeDbTableHandler,
+		http.MethodGet,
+		`/test/db/_schema/FULL|table_name=FULL`,
+		http.StatusOK,
+		`{"schema":`+full_schema+`,"columns":[{"name":"id","type":"INTEGER","allow_null":false,"is_primary_key":true},{"name":"name","type":"TEXT","allow_null":false,"is_primary_key":false},{"name":"score","type":"REAL","allow_null":true,"is_primary_key":false},{"name":"active","type":"boolean","allow_null":false,"is_primary_key":false,"default":"1"},{"name":"data","type":"BLOB","allow_null":true,"is_primary_key":false},{"name":"seen","type":"datetime","allow_null":true,"is_primary_key":false,"default":"'2017-01-02 03:04:05'"}],"indexes":[],"rowCount":0,"kind":"SchemaResponse","self":"/test/db/_schema/FULL?"}`},
+	{"create record in FULL w/ defaults",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/FULL|table_name=FULL||{"records":[{"keys":["name"],"values":["abc"]}]}`,
+		http.StatusCreated, `{"ids":[1],"kind":"Collection"}`},
+	{"create record in FULL w/ all values",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/FULL|table_name=FULL||{"records":[{"keys":["name","score","active","seen"],"values":["abcde",1.5,false,null]}]}`,
+		http.StatusCreated, `{"ids":[2],"kind":"Collection"}`},
+	{"create record in FULL w/ name too long",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/FULL|table_name=FULL||{"records":[{"keys":["name"],"values":["abcdef"]}]}`,
 		http.StatusBadRequest, noCheck},
-	{"delete table ABC empty table_name",
-		deleteDbTableHandler,
-		http.MethodDelete,
-		`/test/db/_schema|table_name=`,
+	{"create record in FULL w/ null name",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/FULL|table_name=FULL||{"records":[{"keys":["name"],"values":[null]}]}`,
 		http.StatusBadRequest, noCheck},
-	{"delete table ABCD expecting failure",
-		deleteDbTableHandler,
-		http.MethodDelete,
-		`/test/db/_schema/ABCD|table_name=ABCD`,
+	{"update record in FULL w/ name too long",
+		updateDbRecordHandler,
+		http.MethodPatch,
+		`/test/db/_table/FULL|table_name=FULL&id=1||{"records":[{"keys":["name"],"values":["abcdef"]}]}`,
 		http.StatusBadRequest, noCheck},
-	{"create table ABCD expecting success",
+	{"get records in FULL",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`http://localhost/test/db/_table/FULL|table_name=FULL|fields=name,score,active,seen`,
+		http.StatusOK, `{"records":[{"keys":["name","score","active","seen"],"values":["abc",null,true,"2017-01-02T03:04:05Z"],"kind":"KVResponse","self":"http://localhost/test/db/_table/FULL/1","etag":"\"f34a82b1c307b065\""},{"keys":["name","score","active","seen"],"values":["abcde",1.5,false,null],"kind":"KVResponse","self":"http://localhost/test/db/_table/FULL/2","etag":"\"a4a240a862ec5dee\""}],"kind":"Collection","limit":7,"offset":0}`},
+	{"create table w/ invalid db_type",
 		createDbTableHandler,
 		http.MethodPost,
-		`/test/db/_schema/ABCD|table_name=ABCD||{"fields":[{"name":"id","properties":["is_primary_key","int32"]},{"name":"uri","properties":[]},{"name":"name","properties":[]}]}`,
-		http.StatusCreated, noCheck},
-	{"delete table ABCD expecting success",
+		`/test/db/_schema/BADTYPE|table_name=BADTYPE||{"fields":[{"name":"a","db_type":"varchar"}]}`,
+		http.StatusBadRequest, noCheck},
+	{"teardown: delete table FULL",
 		deleteDbTableHandler,
 		http.MethodDelete,
-		`/test/db/_schema/ABCD|table_name=ABCD`,
+		`/test/db/_schema/FULL|table_name=FULL`,
 		http.StatusOK, noCheck},
-	{"delete table ABCD expecting failure",
-		deleteDbTableHandler,
-		http.MethodDelete,
-		`/test/db/_schema/ABCD|table_name=ABCD`,
-		http.StatusBadRequest, noCheck},
-}
-
-// the deleteDbTable test suite.  run all deleteDbTable testcases.
-func Test_deleteDbTableHandler(t *testing.T) {
-	apiCalls_Runner(t, "deleteDbTable_Tab", deleteDbTable_Tab)
 }
 
-// ----- unit tests for schemaQuery().
-
-// inputs and outputs for one schemaQuery testcase.
-type schemaQuery_TC struct {
-	self string
-	tableName string
-	fieldName string
-	selector string
-	item string
-	xcode int
-	xdata string
+// the full FieldSchema test suite.
+func Test_createDbTableHandler_full(t *testing.T) {
+	apiCalls_Runner(t, "createDbTableFull_Tab", createDbTableFull_Tab)
 }
 
-// table of schemaQuery testcases.
-var schemaQuery_Tab = []schemaQuery_TC {
-	// a good request
-	{ "http://abc", "_tables_", "schema", "name", "users", http.StatusOK, "{users_schema SchemaResponse http://abc}" },
-
-	// a good request
-	{ "http://abc", "_tables_", "schema", "name", "bundles", http.StatusOK, "{bundles_schema SchemaResponse http://abc}" },
-
-	// bogus table
-	{ "http://abc", "bogus", "schema", "name", "users", http.StatusBadRequest, "xxx" },
-
-	// bogus field
-	{ "http://abc", "_tables_", "schema", "bogus", "users", http.StatusBadRequest, "xxx" },
+// ----- unit tests for alterDbTableHandler()
 
-	// bogus item
-	{ "http://abc", "_tables_", "schema", "name", "bogus", http.StatusBadRequest, "xxx" },
-}
-
-// run one testcase for function schemaQuery.
-func schemaQuery_Checker(cx *testContext, tc *schemaQuery_TC) {
-	res := schemaQuery(tc.self, tc.tableName, tc.fieldName,
-			tc.selector, tc.item)
-	cx.assertEqual(tc.xcode, res.code, "returned code")
-	if tc.xcode == http.StatusOK {
-		dataStr := fmt.Sprintf("%v", res.data)
-		cx.assertEqual(tc.xdata, dataStr, "returned data")
-	}
+// table of alterDbTable testcases.
+var alterDbTable_Tab = []apiCall_TC {
+	{"setup: create table ALT",
+		createDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/ALT|table_name=ALT||{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"},{"name":"uri"}]}`,
+		http.StatusCreated, noCheck},
+	{"setup: create records in ALT",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/ALT|table_name=ALT||{"records":[{"keys":["name","uri"],"values":["n1","u1"]},{"keys":["name","uri"],"values":["n2","u2"]}]}`,
+		http.StatusCreated, `{"ids":[1,2],"kind":"Collection"}`},
+	{"alter table ALT add nullable field",
+		alterDbTableHandler,
+		http.MethodPatch,
+		`/test/db/_schema/ALT|table_name=ALT||{"add":[{"name":"score","db_type":"real","allow_null":true}]}`,
+		http.StatusOK, `{"schema":{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"},{"name":"uri"},{"name":"score","db_type":"real","allow_null":true}]},"columns":[{"name":"id","type":"INTEGER","allow_null":false,"is_primary_key":true},{"name":"name","type":"TEXT","allow_null":false,"is_primary_key":false},{"name":"uri","type":"TEXT","allow_null":false,"is_primary_key":false},{"name":"score","type":"REAL","allow_null":true,"is_primary_key":false}],"indexes":[],"rowCount":2,"kind":"SchemaResponse","self":"/test/db/_schema/ALT?"}`},
+	{"alter table ALT rename field",
+		alterDbTableHandler,
+		http.MethodPatch,
+		`/test/db/_schema/ALT|table_name=ALT||{"rename":[{"from":"uri","to":"url"}]}`,
+		http.StatusOK, noCheck},
+	{"alter table ALT drop field, add not null field",
+		alterDbTableHandler,
+		http.MethodPatch,
+		`/test/db/_schema/ALT|table_name=ALT||{"drop":["name"],"add":[{"name":"rank","db_type":"integer","default":0}]}`,
+		http.StatusOK, noCheck},
+	{"alter table ALT drop bogus field",
+		alterDbTableHandler,
+		http.MethodPatch,
+		`/test/db/_schema/ALT|table_name=ALT||{"drop":["bogus"]}`,
+		http.StatusBadRequest, noCheck},
+	{"alter table ALT add field that fails on existing records",
+		alterDbTableHandler,
+		http.MethodPatch,
+		`/test/db/_schema/ALT|table_name=ALT||{"drop":["rank"],"add":[{"name":"x"}]}`,
+		http.StatusBadRequest, noCheck},
+	{"create record in altered ALT",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/ALT|table_name=ALT||{"records":[{"keys":["url","score"],"values":["u3",1.5]}]}`,
+		http.StatusCreated, `{"ids":[3],"kind":"Collection"}`},
+	{"get records in altered ALT",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`http://localhost/test/db/_table/ALT|table_name=ALT`,
+		http.StatusOK, `{"records":[{"keys":["id","url","score","rank"],"values":[1,"u1",null,0],"kind":"KVResponse","self":"http://localhost/test/db/_table/ALT/1","etag":"\"42d7801a47384c94\""},{"keys":["id","url","score","rank"],"values":[2,"u2",null,0],"kind":"KVResponse","self":"http://localhost/test/db/_table/ALT/2","etag":"\"41a7c54c0a4685ea\""},{"keys":["id","url","score","rank"],"values":[3,"u3",1.5,0],"kind":"KVResponse","self":"http://localhost/test/db/_table/ALT/3","etag":"\"760d66bb5576c9a0\""}],"kind":"Collection","limit":7,"offset":0}`},
+	{"describe altered ALT",
+		describeDbTableHandler,
+		http.MethodGet,
+		`/test/db/_schema/ALT|table_name=ALT`,
+		http.StatusOK, `{"schema":{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"url"},{"name":"score","db_type":"real","allow_null":true},{"name":"rank","db_type":"integer","default":0}]},"columns":[{"name":"id","type":"INTEGER","allow_null":false,"is_primary_key":true},{"name":"url","type":"TEXT","allow_null":false,"is_primary_key":false},{"name":"score","type":"REAL","allow_null":true,"is_primary_key":false},{"name":"rank","type":"INTEGER","allow_null":false,"is_primary_key":false,"default":"0"}],"indexes":[],"rowCount":3,"kind":"SchemaResponse","self":"/test/db/_schema/ALT?"}`},
+	{"alter table w/o usable schema",
+		alterDbTableHandler,
+		http.MethodPatch,
+		`/test/db/_schema/bundles|table_name=bundles||{"drop":["uri"]}`,
+		http.StatusBadRequest, noCheck},
+	{"alter nonexistent table",
+		alterDbTableHandler,
+		http.MethodPatch,
+		`/test/db/_schema/NOSUCH|table_name=NOSUCH||{"drop":["uri"]}`,
+		http.StatusNotFound, noCheck},
+	{"alter table w/ malformed body",
+		alterDbTableHandler,
+		http.MethodPatch,
+		`/test/db/_schema/ALT|table_name=ALT||bogus`,
+		http.StatusBadRequest, noCheck},
+	{"teardown: delete table ALT",
+		deleteDbTableHandler,
+		http.MethodDelete,
+		`/test/db/_schema/ALT|table_name=ALT`,
+		http.StatusOK, noCheck},
 }
 
-// the schemaQuery test suite.  run all schemaQuery testcases.
-func Test_schemaQuery(t *testing.T) {
-	cx := newTestContext(t, "schemaQuery_Tab")
-	for _, tc := range schemaQuery_Tab {
-		schemaQuery_Checker(cx, &tc)
-		cx.bump()
-	}
+// the alterDbTable test suite.  run all alterDbTable testcases.
+func Test_alterDbTableHandler(t *testing.T) {
+	apiCalls_Runner(t, "alterDbTable_Tab", alterDbTable_Tab)
 }
 
-// ----- unit tests for describeDbTableHandler().
+// ----- unit tests for the index handlers
 
-// table of describeDbTable testcases.
-var describeDbTable_Tab = []apiCall_TC {
-	{"get schema for users table",
-		describeDbTableHandler,
+// table of index handler testcases.
+var dbIndex_Tab = []apiCall_TC {
+	{"setup: create table IDX w/ index",
+		createDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/IDX|table_name=IDX||{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"},{"name":"uri"}],"indexes":[{"name":"by_name","fields":["name"]}]}`,
+		http.StatusCreated, noCheck},
+	{"list indexes of IDX",
+		listDbIndexesHandler,
 		http.MethodGet,
-		`/test/db/_schema/users|table_name=users`,
+		`/test/db/_schema/IDX/_index|table_name=IDX`,
+		http.StatusOK, `{"indexes":[{"name":"by_name","fields":["name"]}],"kind":"IndexesResponse","self":"/test/db/_schema/IDX/_index?"}`},
+	{"create unique index on IDX",
+		createDbIndexHandler,
+		http.MethodPost,
+		`/test/db/_schema/IDX/_index|table_name=IDX||{"name":"by_uri","fields":["uri","name"],"unique":true}`,
+		http.StatusCreated, noCheck},
+	{"create duplicate index on IDX",
+		createDbIndexHandler,
+		http.MethodPost,
+		`/test/db/_schema/IDX/_index|table_name=IDX||{"name":"by_uri","fields":["uri"]}`,
+		http.StatusBadRequest, noCheck},
+	{"create index on bogus field of IDX",
+		createDbIndexHandler,
+		http.MethodPost,
+		`/test/db/_schema/IDX/_index|table_name=IDX||{"name":"by_bogus","fields":["bogus"]}`,
+		http.StatusBadRequest, noCheck},
+	{"create index w/ malformed body",
+		createDbIndexHandler,
+		http.MethodPost,
+		`/test/db/_schema/IDX/_index|table_name=IDX||bogus`,
+		http.StatusBadRequest, noCheck},
+	{"create index on table w/o usable schema",
+		createDbIndexHandler,
+		http.MethodPost,
+		`/test/db/_schema/bundles/_index|table_name=bundles||{"name":"by_uri","fields":["uri"]}`,
+		http.StatusBadRequest, noCheck},
+	{"create record in IDX",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/IDX|table_name=IDX||{"records":[{"keys":["name","uri"],"values":["n1","u1"]}]}`,
+		http.StatusCreated, noCheck},
+	{"create record in IDX violating unique index",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/IDX|table_name=IDX||{"records":[{"keys":["name","uri"],"values":["n1","u1"]}]}`,
+		http.StatusConflict, noCheck},
+	{"alter IDX renaming an indexed field",
+		alterDbTableHandler,
+		http.MethodPatch,
+		`/test/db/_schema/IDX|table_name=IDX||{"rename":[{"from":"uri","to":"url"}]}`,
 		http.StatusOK, noCheck},
-	{"get schema for bundles table",
-		describeDbTableHandler,
-		http.MethodGet,
-		`/test/db/_schema/bundles|table_name=bundles`,
+	{"alter IDX dropping an indexed field",
+		alterDbTableHandler,
+		http.MethodPatch,
+		`/test/db/_schema/IDX|table_name=IDX||{"drop":["name"]}`,
+		http.StatusBadRequest, noCheck},
+	{"alter IDX dropping a field before adding it",
+		alterDbTableHandler,
+		http.MethodPatch,
+		`/test/db/_schema/IDX|table_name=IDX||{"add":[{"name":"x"}],"drop":["x"]}`,
+		http.StatusBadRequest, noCheck},
+	{"alter IDX adding a field",
+		alterDbTableHandler,
+		http.MethodPatch,
+		`/test/db/_schema/IDX|table_name=IDX||{"add":[{"name":"x","allow_null":false,"default":"d"},{"name":"y","allow_null":true}]}`,
 		http.StatusOK, noCheck},
-	{"get schema for bogus table",
-		describeDbTableHandler,
+	{"alter IDX dropping a field, by copying",
+		alterDbTableHandler,
+		http.MethodPatch,
+		`/test/db/_schema/IDX|table_name=IDX||{"drop":["y"]}`,
+		http.StatusOK, noCheck},
+	{"list indexes of altered IDX",
+		listDbIndexesHandler,
 		http.MethodGet,
-		`/test/db/_schema/bogus|table_name=bogus`,
+		`/test/db/_schema/IDX/_index|table_name=IDX`,
+		http.StatusOK, `{"indexes":[{"name":"by_name","fields":["name"]},{"name":"by_uri","fields":["url","name"],"unique":true}],"kind":"IndexesResponse","self":"/test/db/_schema/IDX/_index?"}`},
+	{"create record in altered IDX violating unique index",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/IDX|table_name=IDX||{"records":[{"keys":["name","url"],"values":["n1","u1"]}]}`,
+		http.StatusConflict, noCheck},
+	{"delete index of IDX",
+		deleteDbIndexHandler,
+		http.MethodDelete,
+		`/test/db/_schema/IDX/_index/by_uri|table_name=IDX&index_name=by_uri`,
+		http.StatusOK, noCheck},
+	{"delete index of IDX again",
+		deleteDbIndexHandler,
+		http.MethodDelete,
+		`/test/db/_schema/IDX/_index/by_uri|table_name=IDX&index_name=by_uri`,
 		http.StatusBadRequest, noCheck},
-	{"get schema for no table_name",
+	{"delete index w/ invalid name",
+		deleteDbIndexHandler,
+		http.MethodDelete,
+		`/test/db/_schema/IDX/_index/x-y|table_name=IDX&index_name=x-y`,
+		http.StatusBadRequest, noCheck},
+	{"create record in IDX no longer violating unique index",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/IDX|table_name=IDX||{"records":[{"keys":["name","url"],"values":["n1","u1"]}]}`,
+		http.StatusCreated, noCheck},
+	{"describe IDX",
 		describeDbTableHandler,
 		http.MethodGet,
-		`/test/db/_schema/|`,
-		http.StatusBadRequest, noCheck},
+		`/test/db/_schema/IDX|table_name=IDX`,
+		http.StatusOK, `{"schema":{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"},{"name":"url"},{"name":"x","allow_null":false,"default":"d"}],"indexes":[{"name":"by_name","fields":["name"]}]},"columns":[{"name":"id","type":"INTEGER","allow_null":false,"is_primary_key":true},{"name":"name","type":"TEXT","allow_null":false,"is_primary_key":false},{"name":"url","type":"TEXT","allow_null":false,"is_primary_key":false},{"name":"x","type":"TEXT","allow_null":false,"is_primary_key":false,"default":"'d'"}],"indexes":[{"name":"IDX__by_name","fields":["name"],"unique":false}],"rowCount":2,"kind":"SchemaResponse","self":"/test/db/_schema/IDX?"}`},
+	{"teardown: delete table IDX",
+		deleteDbTableHandler,
+		http.MethodDelete,
+		`/test/db/_schema/IDX|table_name=IDX`,
+		http.StatusOK, noCheck},
 }
 
-// the describeDbTable test suite.  run all describeDbTable testcases.
-func Test_describeDbTableHandler(t *testing.T) {
-	apiCalls_Runner(t, "describeDbTable_Tab", describeDbTable_Tab)
+// the index handlers test suite.  run all index testcases.
+func Test_dbIndexHandlers(t *testing.T) {
+	apiCalls_Runner(t, "dbIndex_Tab", dbIndex_Tab)
 }
 
-// ----- unit tests for execN()
+// ----- unit tests for unique and foreign key constraints
+
+// table of constraint testcases.
+var constraints_Tab = []apiCall_TC {
+	{"setup: create table PARENT",
+		createDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/PARENT|table_name=PARENT||{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name","unique":true}]}`,
+		http.StatusCreated, noCheck},
+	{"setup: create table KID",
+		createDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/KID|table_name=KID||{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"pid","db_type":"integer","allow_null":true,"references":{"table":"PARENT","field":"id","on_delete":"cascade"}}]}`,
+		http.StatusCreated, noCheck},
+	{"setup: create table PET",
+		createDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/PET|table_name=PET||{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"pid","db_type":"integer","allow_null":true,"references":{"table":"PARENT","field":"id","on_delete":"restrict"}}]}`,
+		http.StatusCreated, noCheck},
+	{"create parents",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/PARENT|table_name=PARENT||{"records":[{"keys":["name"],"values":["p1"]},{"keys":["name"],"values":["p2"]}]}`,
+		http.StatusCreated, `{"ids":[1,2],"kind":"Collection"}`},
+	{"create parent w/ duplicate name",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/PARENT|table_name=PARENT||{"records":[{"keys":["name"],"values":["p1"]}]}`,
+		http.StatusConflict, noCheck},
+	{"create parent w/ duplicate id",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/PARENT|table_name=PARENT||{"records":[{"keys":["id","name"],"values":[1,"p3"]}]}`,
+		http.StatusConflict, noCheck},
+	{"update parent to duplicate name",
+		updateDbRecordHandler,
+		http.MethodPatch,
+		`/test/db/_table/PARENT|table_name=PARENT&id=2||{"records":[{"keys":["name"],"values":["p1"]}]}`,
+		http.StatusConflict, noCheck},
+	{"create kid of parent 1",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/KID|table_name=KID||{"records":[{"keys":["pid"],"values":[1]}]}`,
+		http.StatusCreated, noCheck},
+	{"create kid of nonexistent parent",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/KID|table_name=KID||{"records":[{"keys":["pid"],"values":[99]}]}`,
+		http.StatusConflict, noCheck},
+	{"create pet of parent 2",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/PET|table_name=PET||{"records":[{"keys":["pid"],"values":[2]}]}`,
+		http.StatusCreated, noCheck},
+	{"alter PARENT by copying keeps the kids",
+		alterDbTableHandler,
+		http.MethodPatch,
+		`/test/db/_schema/PARENT|table_name=PARENT||{"add":[{"name":"note","allow_null":true,"unique":true}]}`,
+		http.StatusOK, noCheck},
+	{"get kids after alter",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`http://localhost/test/db/_table/KID|table_name=KID|fields=pid`,
+		http.StatusOK, `{"records":[{"keys":["pid"],"values":[1],"kind":"KVResponse","self":"http://localhost/test/db/_table/KID/1","etag":"\"080a9ed428559ef6\""}],"kind":"Collection","limit":7,"offset":0}`},
+	{"alter PET adding a reference to no parent",
+		alterDbTableHandler,
+		http.MethodPatch,
+		`/test/db/_schema/PET|table_name=PET||{"add":[{"name":"qid","db_type":"integer","default":99,"references":{"table":"PARENT","field":"id"}}]}`,
+		http.StatusConflict,
+		`{"code":409,"message":"FOREIGN KEY constraint failed: record 1 of PET refers to no record of PARENT","kind":"ErrorResponse"}`},
+	{"delete parent w/ restricted pet",
+		deleteDbRecordHandler,
+		http.MethodDelete,
+		`/test/db/_table/PARENT|table_name=PARENT&id=2`,
+		http.StatusConflict, noCheck},
+	{"delete parent w/ cascaded kid",
+		deleteDbRecordHandler,
+		http.MethodDelete,
+		`/test/db/_table/PARENT|table_name=PARENT&id=1`,
+		http.StatusOK, noCheck},
+	{"get kids after cascade",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`http://localhost/test/db/_table/KID|table_name=KID|fields=pid`,
+		http.StatusBadRequest, noCheck},
+	{"create table w/ invalid on_delete",
+		createDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/BADREF|table_name=BADREF||{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"pid","references":{"table":"PARENT","field":"id","on_delete":"bogus"}}]}`,
+		http.StatusBadRequest, noCheck},
+	{"teardown: delete table PET",
+		deleteDbTableHandler,
+		http.MethodDelete,
+		`/test/db/_schema/PET|table_name=PET`,
+		http.StatusOK, noCheck},
+	{"teardown: delete table KID",
+		deleteDbTableHandler,
+		http.MethodDelete,
+		`/test/db/_schema/KID|table_name=KID`,
+		http.StatusOK, noCheck},
+	{"teardown: delete table PARENT",
+		deleteDbTableHandler,
+		http.MethodDelete,
+		`/test/db/_schema/PARENT|table_name=PARENT`,
+		http.StatusOK, noCheck},
+}
+
+// the constraints test suite.  run all constraint testcases.
+func Test_constraints(t *testing.T) {
+	apiCalls_Runner(t, "constraints_Tab", constraints_Tab)
+}
+
+// ----- unit tests for createDbRecordsHandler() with on_conflict
+
+// table of upsert testcases.
+var upsert_Tab = []apiCall_TC {
+	{"setup: create table UPS",
+		createDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/UPS|table_name=UPS||{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"email","unique":true},{"name":"n","db_type":"integer","allow_null":true}]}`,
+		http.StatusCreated, noCheck},
+	{"create records",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/UPS|table_name=UPS||{"records":[{"keys":["email","n"],"values":["a",1]},{"keys":["email","n"],"values":["b",2]}]}`,
+		http.StatusCreated, `{"ids":[1,2],"kind":"Collection"}`},
+	{"create duplicate w/ on_conflict=error",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/UPS|table_name=UPS|on_conflict=error|{"records":[{"keys":["email","n"],"values":["a",3]}]}`,
+		http.StatusConflict, noCheck},
+	// note that sqlite consumes an autoincrement id
+	// even when a conflicting insertion does nothing.
+	{"create w/ on_conflict=ignore",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/UPS|table_name=UPS|on_conflict=ignore&conflict_target=email|{"records":[{"keys":["email","n"],"values":["a",3]},{"keys":["email","n"],"values":["c",4]}]}`,
+		http.StatusCreated, `{"ids":[1,4],"kind":"Collection","results":[{"id":1,"outcome":"skipped"},{"id":4,"outcome":"inserted"}]}`},
+	{"create w/ on_conflict=update",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/UPS|table_name=UPS|on_conflict=update&conflict_target=email|{"records":[{"keys":["email","n"],"values":["b",5]},{"keys":["email"],"values":["c"]},{"keys":["email","n"],"values":["d",6]}]}`,
+		http.StatusCreated, `{"ids":[2,4,7],"kind":"Collection","results":[{"id":2,"outcome":"updated"},{"id":4,"outcome":"skipped"},{"id":7,"outcome":"inserted"}]}`},
+	{"create w/ on_conflict=update on the id field",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/UPS|table_name=UPS|on_conflict=update|{"records":[{"keys":["id","email","n"],"values":[1,"aa",7]}]}`,
+		http.StatusCreated, `{"ids":[1],"kind":"Collection","results":[{"id":1,"outcome":"updated"}]}`},
+	{"create w/ on_conflict=replace",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/UPS|table_name=UPS|on_conflict=replace&conflict_target=email|{"records":[{"keys":["email","n"],"values":["d",8]},{"keys":["email","n"],"values":["e",9]}]}`,
+		http.StatusCreated, `{"ids":[7,8],"kind":"Collection","results":[{"id":7,"outcome":"updated"},{"id":8,"outcome":"inserted"}]}`},
+	{"get records after upserts",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`http://localhost/test/db/_table/UPS|table_name=UPS|fields=email,n`,
+		http.StatusOK, `{"records":[{"keys":["email","n"],"values":["aa",7],"kind":"KVResponse","self":"http://localhost/test/db/_table/UPS/1","etag":"\"ef58ff52d1329ab6\""},{"keys":["email","n"],"values":["b",5],"kind":"KVResponse","self":"http://localhost/test/db/_table/UPS/2","etag":"\"165e7b54ece588b2\""},{"keys":["email","n"],"values":["c",4],"kind":"KVResponse","self":"http://localhost/test/db/_table/UPS/4","etag":"\"4c4bf527356c4e86\""},{"keys":["email","n"],"values":["d",8],"kind":"KVResponse","self":"http://localhost/test/db/_table/UPS/7","etag":"\"b887e1a2ca35311c\""},{"keys":["email","n"],"values":["e",9],"kind":"KVResponse","self":"http://localhost/test/db/_table/UPS/8","etag":"\"cdb1adfd0e3f109a\""}],"kind":"Collection","limit":7,"offset":0}`},
+	{"setup: create table UPSREF",
+		createDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/UPSREF|table_name=UPSREF||{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"ups","db_type":"integer","references":{"table":"UPS","field":"id","on_delete":"cascade"}}]}`,
+		http.StatusCreated, noCheck},
+	{"create record that refers to UPS",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/UPSREF|table_name=UPSREF||{"records":[{"keys":["ups"],"values":[7]}]}`,
+		http.StatusCreated, `{"ids":[1],"kind":"Collection"}`},
+	{"create w/ on_conflict=replace of a referred-to record",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/UPS|table_name=UPS|on_conflict=replace&conflict_target=email|{"records":[{"keys":["email"],"values":["d"]}]}`,
+		http.StatusCreated, `{"ids":[7],"kind":"Collection","results":[{"id":7,"outcome":"updated"}]}`},
+	{"get records after replace",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`http://localhost/test/db/_table/UPS|table_name=UPS|ids=7&fields=email,n`,
+		http.StatusOK, `{"records":[{"keys":["email","n"],"values":["d",null],"kind":"KVResponse","self":"http://localhost/test/db/_table/UPS/7","etag":"\"230d4a53b3a949ab\""}],"kind":"Collection","limit":7,"offset":0}`},
+	{"referring record is not deleted by replace",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`http://localhost/test/db/_table/UPSREF|table_name=UPSREF|fields=ups`,
+		http.StatusOK, `{"records":[{"keys":["ups"],"values":[7],"kind":"KVResponse","self":"http://localhost/test/db/_table/UPSREF/1","etag":"\"589ffd3acf522ae8\""}],"kind":"Collection","limit":7,"offset":0}`},
+	{"teardown: delete table UPSREF",
+		deleteDbTableHandler,
+		http.MethodDelete,
+		`/test/db/_schema/UPSREF|table_name=UPSREF`,
+		http.StatusOK, noCheck},
+	{"create w/ on_conflict=update on a non-unique target",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/UPS|table_name=UPS|on_conflict=update&conflict_target=n|{"records":[{"keys":["email","n"],"values":["f",9]}]}`,
+		http.StatusBadRequest, noCheck},
+	{"create w/ invalid on_conflict",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/UPS|table_name=UPS|on_conflict=merge|{"records":[{"keys":["email"],"values":["g"]}]}`,
+		http.StatusBadRequest, noCheck},
+	{"create w/ invalid conflict_target",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/UPS|table_name=UPS|on_conflict=update&conflict_target=a-b|{"records":[{"keys":["email"],"values":["g"]}]}`,
+		http.StatusBadRequest, noCheck},
+	{"teardown: delete table UPS",
+		deleteDbTableHandler,
+		http.MethodDelete,
+		`/test/db/_schema/UPS|table_name=UPS`,
+		http.StatusOK, noCheck},
+}
+
+// the upsert test suite.  run all upsert testcases.
+func Test_createDbRecordsHandler_upsert(t *testing.T) {
+	apiCalls_Runner(t, "upsert_Tab", upsert_Tab)
+}
+
+// ----- unit tests for createDbRecordsHandler() with atomic
+
+// table of atomic testcases.
+var atomic_Tab = []apiCall_TC {
+	{"setup: create table ATOM",
+		createDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/ATOM|table_name=ATOM||{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"email","unique":true}]}`,
+		http.StatusCreated, noCheck},
+	{"create records",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/ATOM|table_name=ATOM||{"records":[{"keys":["email"],"values":["a"]}]}`,
+		http.StatusCreated, `{"ids":[1],"kind":"Collection"}`},
+	{"create batch w/ a duplicate is rolled back",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/ATOM|table_name=ATOM||{"records":[{"keys":["email"],"values":["b"]},{"keys":["email"],"values":["a"]},{"keys":["email"],"values":["c"]}]}`,
+		http.StatusConflict, `{"code":409,"message":"record 1: UNIQUE constraint failed: ATOM.email","kind":"ErrorResponse"}`},
+	{"get records after rollback",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`http://localhost/test/db/_table/ATOM|table_name=ATOM|fields=email`,
+		http.StatusOK, `{"records":[{"keys":["email"],"values":["a"],"kind":"KVResponse","self":"http://localhost/test/db/_table/ATOM/1","etag":"\"0eb5b8d6f81bc677\""}],"kind":"Collection","limit":7,"offset":0}`},
+	{"create batch w/ a duplicate and atomic=false",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/ATOM|table_name=ATOM|atomic=false|{"records":[{"keys":["email"],"values":["b"]},{"keys":["email"],"values":["a"]},{"keys":["bogus"],"values":["c"]}]}`,
+		http.StatusMultiStatus, `{"ids":[2,-1,-1],"kind":"Collection","results":[{"id":2,"outcome":"inserted"},{"id":-1,"outcome":"failed","error":"UNIQUE constraint failed: ATOM.email"},{"id":-1,"outcome":"failed","error":"keys: unknown field bogus (valid fields: id,email)"}]}`},
+	{"create batch w/ atomic=false and no failures",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/ATOM|table_name=ATOM|atomic=false&on_conflict=ignore&conflict_target=email|{"records":[{"keys":["email"],"values":["c"]},{"keys":["email"],"values":["a"]}]}`,
+		http.StatusCreated, `{"ids":[3,1],"kind":"Collection","results":[{"id":3,"outcome":"inserted"},{"id":1,"outcome":"skipped"}]}`},
+	{"create batch w/ invalid atomic",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/ATOM|table_name=ATOM|atomic=maybe|{"records":[{"keys":["email"],"values":["d"]}]}`,
+		http.StatusBadRequest, noCheck},
+	{"teardown: delete table ATOM",
+		deleteDbTableHandler,
+		http.MethodDelete,
+		`/test/db/_schema/ATOM|table_name=ATOM`,
+		http.StatusOK, noCheck},
+}
+
+// the atomic test suite.  run all atomic testcases.
+func Test_createDbRecordsHandler_atomic(t *testing.T) {
+	apiCalls_Runner(t, "atomic_Tab", atomic_Tab)
+}
+
+// ----- unit tests for the fields parameter of the write APIs
+
+// table of write fields testcases.
+var writeFields_Tab = []apiCall_TC {
+	{"setup: create table WF",
+		createDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/WF|table_name=WF||{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"},{"name":"state","default":"new"}]}`,
+		http.StatusCreated, noCheck},
+	{"create records w/o fields",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/WF|table_name=WF||{"records":[{"keys":["name"],"values":["a"]}]}`,
+		http.StatusCreated, `{"ids":[1],"kind":"Collection"}`},
+	{"create records w/ fields",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`http://localhost/test/db/_table/WF|table_name=WF|fields=name,state|{"records":[{"keys":["name"],"values":["b"]},{"keys":["name","state"],"values":["c","old"]}]}`,
+		http.StatusCreated, `{"ids":[2,3],"kind":"Collection","records":[{"keys":["name","state"],"values":["b","new"],"kind":"KVResponse","self":"http://localhost/test/db/_table/WF/2","etag":"\"8ac1a240353019ab\""},{"keys":["name","state"],"values":["c","old"],"kind":"KVResponse","self":"http://localhost/test/db/_table/WF/3","etag":"\"efca7c6f057c9bfe\""}]}`},
+	{"create records w/ fields and atomic=false",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`http://localhost/test/db/_table/WF|table_name=WF|fields=name&atomic=false|{"records":[{"keys":["bogus"],"values":["x"]},{"keys":["name"],"values":["d"]}]}`,
+		http.StatusMultiStatus, `{"ids":[-1,4],"kind":"Collection","results":[{"id":-1,"outcome":"failed","error":"keys: unknown field bogus (valid fields: id,name,state)"},{"id":4,"outcome":"inserted"}],"records":[{"keys":["name"],"values":["d"],"kind":"KVResponse","self":"http://localhost/test/db/_table/WF/4","etag":"\"05710def18fb1b1c\""}]}`},
+	{"create records w/ unknown field is rolled back",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`http://localhost/test/db/_table/WF|table_name=WF|fields=bogus|{"records":[{"keys":["name"],"values":["e"]}]}`,
+		http.StatusBadRequest, noCheck},
+	{"update records by filter w/ fields",
+		updateDbRecordsHandler,
+		http.MethodPatch,
+		`http://localhost/test/db/_table/WF|table_name=WF|filter=state+%3D+'new'&fields=id,state|{"records":[{"keys":["state"],"values":["done"]}]}`,
+		http.StatusOK, `{"numChanged":3,"kind":"NumChangedResponse","records":[{"keys":["id","state"],"values":[1,"done"],"kind":"KVResponse","self":"http://localhost/test/db/_table/WF/1","etag":"\"ffc42d5e0c29aeea\""},{"keys":["id","state"],"values":[2,"done"],"kind":"KVResponse","self":"http://localhost/test/db/_table/WF/2","etag":"\"501f6e057ff6c2f9\""},{"keys":["id","state"],"values":[4,"done"],"kind":"KVResponse","self":"http://localhost/test/db/_table/WF/4","etag":"\"d17a9ce78ffef2d6\""}]}`},
+	{"update record w/o fields",
+		updateDbRecordHandler,
+		http.MethodPatch,
+		`http://localhost/test/db/_table/WF|table_name=WF&id=3||{"records":[{"keys":["state"],"values":["x"]}]}`,
+		http.StatusOK, `{"numChanged":1,"kind":"NumChangedResponse"}`},
+	{"update record w/ fields",
+		updateDbRecordHandler,
+		http.MethodPatch,
+		`http://localhost/test/db/_table/WF|table_name=WF&id=3|fields=name|{"records":[{"keys":["name"],"values":["cc"]}]}`,
+		http.StatusOK, `{"numChanged":1,"kind":"NumChangedResponse","records":[{"keys":["name"],"values":["cc"],"kind":"KVResponse","self":"http://localhost/test/db/_table/WF/3","etag":"\"03ae4e47d6a69637\""}]}`},
+	{"delete record w/ fields",
+		deleteDbRecordHandler,
+		http.MethodDelete,
+		`http://localhost/test/db/_table/WF|table_name=WF&id=3|fields=name,state`,
+		http.StatusOK, `{"numChanged":1,"kind":"NumChangedResponse","records":[{"keys":["name","state"],"values":["cc","x"],"kind":"KVResponse","self":"http://localhost/test/db/_table/WF/3","etag":"\"03ae4e47d6a69637\""}]}`},
+	{"delete records w/ fields",
+		deleteDbRecordsHandler,
+		http.MethodDelete,
+		`http://localhost/test/db/_table/WF|table_name=WF|ids=4,1&fields=name`,
+		http.StatusOK, `{"numChanged":2,"kind":"NumChangedResponse","records":[{"keys":["name"],"values":["a"],"kind":"KVResponse","self":"http://localhost/test/db/_table/WF/1","etag":"\"ffc42d5e0c29aeea\""},{"keys":["name"],"values":["d"],"kind":"KVResponse","self":"http://localhost/test/db/_table/WF/4","etag":"\"d17a9ce78ffef2d6\""}]}`},
+	{"teardown: delete table WF",
+		deleteDbTableHandler,
+		http.MethodDelete,
+		`/test/db/_schema/WF|table_name=WF`,
+		http.StatusOK, noCheck},
+}
+
+// the write fields test suite.  run all write fields testcases.
+func Test_writeFields(t *testing.T) {
+	apiCalls_Runner(t, "writeFields_Tab", writeFields_Tab)
+}
+
+// ----- unit tests for replaceDbRecordHandler() and replaceDbRecordsHandler()
+
+// table of replace testcases.
+var replace_Tab = []apiCall_TC {
+	{"setup: create table REP",
+		createDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/REP|table_name=REP||{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"},{"name":"state","default":"new"},{"name":"note","allow_null":true}]}`,
+		http.StatusCreated, noCheck},
+	{"create records",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/REP|table_name=REP||{"records":[{"keys":["name","state","note"],"values":["a","old","n1"]},{"keys":["name","state","note"],"values":["b","old","n2"]}]}`,
+		http.StatusCreated, `{"ids":[1,2],"kind":"Collection"}`},
+	{"replace record",
+		replaceDbRecordHandler,
+		http.MethodPut,
+		`http://localhost/test/db/_table/REP|table_name=REP&id=1|fields=name,state,note|{"records":[{"keys":["name"],"values":["aa"]}]}`,
+		http.StatusOK, `{"numChanged":1,"kind":"NumChangedResponse","records":[{"keys":["name","state","note"],"values":["aa","new",null],"kind":"KVResponse","self":"http://localhost/test/db/_table/REP/1","etag":"\"b10eac16a5b92601\""}]}`},
+	{"replace record w/ matching id field",
+		replaceDbRecordHandler,
+		http.MethodPut,
+		`/test/db/_table/REP|table_name=REP&id=1||{"records":[{"keys":["id","name","note"],"values":[1,"aa","n3"]}]}`,
+		http.StatusOK, `{"numChanged":1,"kind":"NumChangedResponse"}`},
+	{"replace record w/ mismatched id field",
+		replaceDbRecordHandler,
+		http.MethodPut,
+		`/test/db/_table/REP|table_name=REP&id=1||{"records":[{"keys":["id","name"],"values":[2,"x"]}]}`,
+		http.StatusBadRequest, noCheck},
+	{"replace missing record",
+		replaceDbRecordHandler,
+		http.MethodPut,
+		`/test/db/_table/REP|table_name=REP&id=9||{"records":[{"keys":["name"],"values":["x"]}]}`,
+		http.StatusNotFound, `{"code":404,"message":"record 0: no record with id 9","kind":"ErrorResponse"}`},
+	{"replace missing record w/ create_if_missing",
+		replaceDbRecordHandler,
+		http.MethodPut,
+		`http://localhost/test/db/_table/REP|table_name=REP&id=9|create_if_missing=true&fields=name,state|{"records":[{"keys":["name"],"values":["i"]}]}`,
+		http.StatusCreated, `{"numChanged":1,"kind":"NumChangedResponse","records":[{"keys":["name","state"],"values":["i","new"],"kind":"KVResponse","self":"http://localhost/test/db/_table/REP/9","etag":"\"4cceacaed70b941e\""}]}`},
+	{"replace record w/o required field",
+		replaceDbRecordHandler,
+		http.MethodPut,
+		`/test/db/_table/REP|table_name=REP&id=1||{"records":[{"keys":["note"],"values":["x"]}]}`,
+		http.StatusBadRequest, noCheck},
+	{"replace record w/ unknown field",
+		replaceDbRecordHandler,
+		http.MethodPut,
+		`/test/db/_table/REP|table_name=REP&id=1||{"records":[{"keys":["name","bogus"],"values":["x","y"]}]}`,
+		http.StatusBadRequest, noCheck},
+	{"replace record w/ two records",
+		replaceDbRecordHandler,
+		http.MethodPut,
+		`/test/db/_table/REP|table_name=REP&id=1||{"records":[{"keys":["name"],"values":["x"]},{"keys":["name"],"values":["y"]}]}`,
+		http.StatusBadRequest, noCheck},
+	{"replace records",
+		replaceDbRecordsHandler,
+		http.MethodPut,
+		`http://localhost/test/db/_table/REP|table_name=REP|fields=name,state,note|{"records":[{"keys":["id","name","state"],"values":[2,"bb","old"]},{"keys":["id","name"],"values":[1,"a"]}]}`,
+		http.StatusOK, `{"numChanged":2,"kind":"NumChangedResponse","records":[{"keys":["name","state","note"],"values":["bb","old",null],"kind":"KVResponse","self":"http://localhost/test/db/_table/REP/2","etag":"\"e2adc331d6f7b646\""},{"keys":["name","state","note"],"values":["a","new",null],"kind":"KVResponse","self":"http://localhost/test/db/_table/REP/1","etag":"\"ea6908cf645a2c52\""}]}`},
+	{"replace records w/ a missing one is rolled back",
+		replaceDbRecordsHandler,
+		http.MethodPut,
+		`/test/db/_table/REP|table_name=REP||{"records":[{"keys":["id","name"],"values":[2,"x"]},{"keys":["id","name"],"values":[8,"y"]}]}`,
+		http.StatusNotFound, noCheck},
+	{"replace records w/o id field",
+		replaceDbRecordsHandler,
+		http.MethodPut,
+		`/test/db/_table/REP|table_name=REP||{"records":[{"keys":["name"],"values":["x"]}]}`,
+		http.StatusBadRequest, `{"code":400,"message":"record 0: record has no id field","kind":"ErrorResponse"}`},
+	{"get records after replace",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`http://localhost/test/db/_table/REP|table_name=REP|fields=name`,
+		http.StatusOK, `{"records":[{"keys":["name"],"values":["a"],"kind":"KVResponse","self":"http://localhost/test/db/_table/REP/1","etag":"\"ea6908cf645a2c52\""},{"keys":["name"],"values":["bb"],"kind":"KVResponse","self":"http://localhost/test/db/_table/REP/2","etag":"\"e2adc331d6f7b646\""},{"keys":["name"],"values":["i"],"kind":"KVResponse","self":"http://localhost/test/db/_table/REP/9","etag":"\"4cceacaed70b941e\""}],"kind":"Collection","limit":7,"offset":0}`},
+	{"replace record w/ large id",
+		replaceDbRecordHandler,
+		http.MethodPut,
+		`/test/db/_table/REP|table_name=REP&id=9007199254740993|create_if_missing=true|{"records":[{"keys":["name"],"values":["big"]}]}`,
+		http.StatusCreated, noCheck},
+	{"replace records w/ large id field",
+		replaceDbRecordsHandler,
+		http.MethodPut,
+		`/test/db/_table/REP|table_name=REP||{"records":[{"keys":["id","name"],"values":[9007199254740993,"bigger"]}]}`,
+		http.StatusOK, `{"numChanged":1,"kind":"NumChangedResponse"}`},
+	{"replace records w/ non-integer id field",
+		replaceDbRecordsHandler,
+		http.MethodPut,
+		`/test/db/_table/REP|table_name=REP||{"records":[{"keys":["id","name"],"values":[1.5,"x"]}]}`,
+		http.StatusBadRequest, noCheck},
+	{"get record w/ large id",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`http://localhost/test/db/_table/REP|table_name=REP|ids=9007199254740992,9007199254740993&fields=name`,
+		http.StatusOK, `{"records":[{"keys":["name"],"values":["bigger"],"kind":"KVResponse","self":"http://localhost/test/db/_table/REP/9007199254740993","etag":"\"2b8cec3c544f1465\""}],"kind":"Collection","limit":7,"offset":0}`},
+	{"teardown: delete table REP",
+		deleteDbTableHandler,
+		http.MethodDelete,
+		`/test/db/_schema/REP|table_name=REP`,
+		http.StatusOK, noCheck},
+}
+
+// the replace test suite.  run all replace testcases.
+func Test_replaceDbRecordHandlers(t *testing.T) {
+	apiCalls_Runner(t, "replace_Tab", replace_Tab)
+}
+
+// ----- unit tests for updateDbRecordsHandler() with per-record bodies
+
+// table of per-record update testcases.
+var updateEach_Tab = []apiCall_TC {
+	{"setup: create table UPE",
+		createDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/UPE|table_name=UPE||{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name","unique":true},{"name":"n","db_type":"integer","default":0}]}`,
+		http.StatusCreated, noCheck},
+	{"create records",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/UPE|table_name=UPE||{"records":[{"keys":["name"],"values":["a"]},{"keys":["name"],"values":["b"]},{"keys":["name"],"values":["c"]}]}`,
+		http.StatusCreated, `{"ids":[1,2,3],"kind":"Collection"}`},
+	{"update records w/ per-record bodies",
+		updateDbRecordsHandler,
+		http.MethodPatch,
+		`http://localhost/test/db/_table/UPE|table_name=UPE|fields=name,n|{"records":[{"keys":["id","n"],"values":[3,30]},{"keys":["id","name","n"],"values":[1,"aa",10]},{"keys":["id","n"],"values":[9,90]}]}`,
+		http.StatusOK, `{"numChanged":2,"counts":[1,1,0],"kind":"NumChangedResponse","records":[{"keys":["name","n"],"values":["c",30],"kind":"KVResponse","self":"http://localhost/test/db/_table/UPE/3","etag":"\"a7a63831b5c326c7\""},{"keys":["name","n"],"values":["aa",10],"kind":"KVResponse","self":"http://localhost/test/db/_table/UPE/1","etag":"\"88025a8b8fe2ac30\""}]}`},
+	{"update records w/ a conflict is rolled back",
+		updateDbRecordsHandler,
+		http.MethodPatch,
+		`/test/db/_table/UPE|table_name=UPE||{"records":[{"keys":["id","n"],"values":[2,20]},{"keys":["id","name"],"values":[3,"aa"]}]}`,
+		http.StatusConflict, `{"code":409,"message":"record 1: UNIQUE constraint failed: UPE.name","kind":"ErrorResponse"}`},
+	{"update records w/o id field",
+		updateDbRecordsHandler,
+		http.MethodPatch,
+		`/test/db/_table/UPE|table_name=UPE||{"records":[{"keys":["n"],"values":[5]}]}`,
+		http.StatusBadRequest, `{"code":400,"message":"record 0: record has no id field","kind":"ErrorResponse"}`},
+	{"update records w/ nothing to update",
+		updateDbRecordsHandler,
+		http.MethodPatch,
+		`/test/db/_table/UPE|table_name=UPE||{"records":[{"keys":["id"],"values":[1]}]}`,
+		http.StatusBadRequest, noCheck},
+	{"update records w/ ids and two records",
+		updateDbRecordsHandler,
+		http.MethodPatch,
+		`/test/db/_table/UPE|table_name=UPE|ids=1,2|{"records":[{"keys":["n"],"values":[5]},{"keys":["n"],"values":[6]}]}`,
+		http.StatusBadRequest, noCheck},
+	{"update records w/ an invalid key",
+		updateDbRecordsHandler,
+		http.MethodPatch,
+		`/test/db/_table/UPE|table_name=UPE|ids=1|{"records":[{"keys":["n=n"],"values":[5]}]}`,
+		http.StatusBadRequest, noCheck},
+	{"get records after updates",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`http://localhost/test/db/_table/UPE|table_name=UPE|fields=n`,
+		http.StatusOK, `{"records":[{"keys":["n"],"values":[10],"kind":"KVResponse","self":"http://localhost/test/db/_table/UPE/1","etag":"\"88025a8b8fe2ac30\""},{"keys":["n"],"values":[0],"kind":"KVResponse","self":"http://localhost/test/db/_table/UPE/2","etag":"\"244c0bb5a9f5a0bc\""},{"keys":["n"],"values":[30],"kind":"KVResponse","self":"http://localhost/test/db/_table/UPE/3","etag":"\"a7a63831b5c326c7\""}],"kind":"Collection","limit":7,"offset":0}`},
+	{"teardown: delete table UPE",
+		deleteDbTableHandler,
+		http.MethodDelete,
+		`/test/db/_schema/UPE|table_name=UPE`,
+		http.StatusOK, noCheck},
+}
+
+// the per-record update test suite.
+func Test_updateDbRecordsHandler_each(t *testing.T) {
+	apiCalls_Runner(t, "updateEach_Tab", updateEach_Tab)
+}
+
+// ----- unit tests for soft deletion
+
+// table of soft deletion testcases.
+var softDelete_Tab = []apiCall_TC {
+	{"setup: create table SD",
+		createDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/SD|table_name=SD||{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"}],"soft_delete":true}`,
+		http.StatusCreated, noCheck},
+	{"create soft_delete table w/ non-null deleted_at",
+		createDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/SDX|table_name=SDX||{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"deleted_at"}],"soft_delete":true}`,
+		http.StatusBadRequest, `{"code":400,"message":"field deleted_at must allow null","kind":"ErrorResponse"}`},
+	{"create records",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/SD|table_name=SD||{"records":[{"keys":["name"],"values":["a"]},{"keys":["name"],"values":["b"]},{"keys":["name"],"values":["c"]}]}`,
+		http.StatusCreated, `{"ids":[1,2,3],"kind":"Collection"}`},
+	{"delete records",
+		deleteDbRecordsHandler,
+		http.MethodDelete,
+		`/test/db/_table/SD|table_name=SD|ids=1,2`,
+		http.StatusOK, `{"numChanged":2,"kind":"NumChangedResponse"}`},
+	{"delete deleted record",
+		deleteDbRecordHandler,
+		http.MethodDelete,
+		`/test/db/_table/SD|table_name=SD&id=1`,
+		http.StatusBadRequest, `{"code":400,"message":"mismatch in rows affected","kind":"ErrorResponse"}`},
+	{"get records hides deleted",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`/test/db/_table/SD|table_name=SD|fields=id,name&include_count=true`,
+		http.StatusOK, `{"records":[{"keys":["id","name"],"values":[3,"c"],"kind":"KVResponse","self":":///test/db/_table/SD/3","etag":"\"000992bb0dd4935c\""}],"kind":"Collection","limit":7,"offset":0,"total":1}`},
+	{"get deleted record",
+		getDbRecordHandler,
+		http.MethodGet,
+		`/test/db/_table/SD|table_name=SD&id=1|fields=name`,
+		http.StatusBadRequest, noCheck},
+	{"get deleted record w/ include_deleted",
+		getDbRecordHandler,
+		http.MethodGet,
+		`/test/db/_table/SD|table_name=SD&id=1|fields=name&include_deleted=true`,
+		http.StatusOK, noCheck},
+	{"get records w/ include_deleted",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`/test/db/_table/SD|table_name=SD|fields=id&include_count=true&include_deleted=true`,
+		http.StatusOK, noCheck},
+	{"update deleted record",
+		updateDbRecordsHandler,
+		http.MethodPatch,
+		`/test/db/_table/SD|table_name=SD|ids=1,3|{"records":[{"keys":["name"],"values":["x"]}]}`,
+		http.StatusOK, `{"numChanged":1,"kind":"NumChangedResponse"}`},
+	{"restore record",
+		restoreDbRecordHandler,
+		http.MethodPost,
+		`/test/db/_table/SD|table_name=SD&id=1`,
+		http.StatusOK, `{"numChanged":1,"kind":"NumChangedResponse"}`},
+	{"restore record that is not deleted",
+		restoreDbRecordHandler,
+		http.MethodPost,
+		`/test/db/_table/SD|table_name=SD&id=1`,
+		http.StatusNotFound, noCheck},
+	{"restore records w/o selection",
+		restoreDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/SD|table_name=SD`,
+		http.StatusBadRequest, noCheck},
+	{"restore records by filter",
+		restoreDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/SD|table_name=SD|filter=name = 'b'`,
+		http.StatusOK, `{"numChanged":1,"kind":"NumChangedResponse"}`},
+	{"delete record again",
+		deleteDbRecordHandler,
+		http.MethodDelete,
+		`/test/db/_table/SD|table_name=SD&id=2`,
+		http.StatusOK, `{"numChanged":1,"kind":"NumChangedResponse"}`},
+	{"purge recent deletions w/ older_than",
+		purgeDbRecordsHandler,
+		http.MethodDelete,
+		`/test/db/_table/SD|table_name=SD|older_than=1d`,
+		http.StatusOK, `{"numChanged":0,"kind":"NumChangedResponse"}`},
+	{"purge",
+		purgeDbRecordsHandler,
+		http.MethodDelete,
+		`/test/db/_table/SD|table_name=SD`,
+		http.StatusOK, `{"numChanged":1,"kind":"NumChangedResponse"}`},
+	{"get records after purge",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`/test/db/_table/SD|table_name=SD|fields=id&include_count=true&include_deleted=true`,
+		http.StatusOK, `{"records":[{"keys":["id"],"values":[1],"kind":"KVResponse","self":":///test/db/_table/SD/1","etag":"\"aebb0474c57695b8\""},{"keys":["id"],"values":[3],"kind":"KVResponse","self":":///test/db/_table/SD/3","etag":"\"4ac8fed3583c2c52\""}],"kind":"Collection","limit":7,"offset":0,"total":2}`},
+	{"drop deleted_at",
+		alterDbTableHandler,
+		http.MethodPatch,
+		`/test/db/_schema/SD|table_name=SD||{"drop":["deleted_at"]}`,
+		http.StatusBadRequest, noCheck},
+	{"restore in table w/o soft_delete",
+		restoreDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/bundles|table_name=bundles|ids=1`,
+		http.StatusBadRequest, noCheck},
+	{"purge table w/o soft_delete",
+		purgeDbRecordsHandler,
+		http.MethodDelete,
+		`/test/db/_table/bundles|table_name=bundles`,
+		http.StatusBadRequest, noCheck},
+	{"teardown: delete table SD",
+		deleteDbTableHandler,
+		http.MethodDelete,
+		`/test/db/_schema/SD|table_name=SD`,
+		http.StatusOK, noCheck},
+}
+
+// the soft deletion test suite.  run all soft deletion testcases.
+func Test_softDelete(t *testing.T) {
+	apiCalls_Runner(t, "softDelete_Tab", softDelete_Tab)
+}
+
+// ----- unit tests for deleteDbTableHandler()
+
+// table of deleteDbTableHandler testcases.
+var deleteDbTable_Tab = []apiCall_TC {
+	{"delete table ABC missing table_name",
+		deleteDbTableHandler,
+		http.MethodDelete,
+		`/test/db/_schema`,
+		http.StatusBadRequest, noCheck},
+	{"delete table ABC empty table_name",
+		deleteDbTableHandler,
+		http.MethodDelete,
+		`/test/db/_schema|table_name=`,
+		http.StatusBadRequest, noCheck},
+	{"delete table ABCD expecting failure",
+		deleteDbTableHandler,
+		http.MethodDelete,
+		`/test/db/_schema/ABCD|table_name=ABCD`,
+		http.StatusNotFound, noCheck},
+	{"create table ABCD expecting success",
+		createDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/ABCD|table_name=ABCD||{"fields":[{"name":"id","properties":["is_primary_key","int32"]},{"name":"uri","properties":[]},{"name":"name","properties":[]}]}`,
+		http.StatusCreated, noCheck},
+	{"delete table ABCD expecting success",
+		deleteDbTableHandler,
+		http.MethodDelete,
+		`/test/db/_schema/ABCD|table_name=ABCD`,
+		http.StatusOK, noCheck},
+	{"delete table ABCD expecting failure",
+		deleteDbTableHandler,
+		http.MethodDelete,
+		`/test/db/_schema/ABCD|table_name=ABCD`,
+		http.StatusNotFound, noCheck},
+}
+
+// the deleteDbTable test suite.  run all deleteDbTable testcases.
+func Test_deleteDbTableHandler(t *testing.T) {
+	apiCalls_Runner(t, "deleteDbTable_Tab", deleteDbTable_Tab)
+}
+
+// ----- unit tests for describeDbTableHandler().
+
+// table of describeDbTable testcases.
+var describeDbTable_Tab = []apiCall_TC {
+	{"get schema for users table",
+		describeDbTableHandler,
+		http.MethodGet,
+		`/test/db/_schema/users|table_name=users`,
+		http.StatusOK, noCheck},
+	{"get schema for bundles table",
+		describeDbTableHandler,
+		http.MethodGet,
+		`/test/db/_schema/bundles|table_name=bundles`,
+		http.StatusOK, noCheck},
+	{"get schema for bogus table",
+		describeDbTableHandler,
+		http.MethodGet,
+		`/test/db/_schema/bogus|table_name=bogus`,
+		http.StatusNotFound, noCheck},
+	{"get schema for no table_name",
+		describeDbTableHandler,
+		http.MethodGet,
+		`/test/db/_schema/|`,
+		http.StatusBadRequest, noCheck},
+}
+
+// the describeDbTable test suite.  run all describeDbTable testcases.
+func Test_describeDbTableHandler(t *testing.T) {
+	apiCalls_Runner(t, "describeDbTable_Tab", describeDbTable_Tab)
+}
+
+// ----- unit tests for execN()
 
 // execN() is pretty well tested thru API test cases.
 // this test is only to exercise an error condition
@@ -1401,7 +2537,7 @@ var getDbRecordHandler_Tab = []apiCall_TC {
 		getDbRecordHandler,
 		http.MethodGet,
 		`http://localhost/test/db/_table/xxxget|table_name=xxxget&id=1`,
-		http.StatusOK, `{"records":[{"keys":["id","uri","name"],"values":["1","uri-a","name-a"],"kind":"KVResponse","self":"http://localhost/test/db/_table/xxxget/1"}],"kind":"Collection"}`},
+		http.StatusOK, `{"records":[{"keys":["id","uri","name"],"values":[1,"uri-a","name-a"],"kind":"KVResponse","self":"http://localhost/test/db/_table/xxxget/1","etag":"\"21c00a5758e6d802\""}],"kind":"Collection","limit":1,"offset":0}`},
 	{"teardown: delete table xxxget",
 		deleteDbTableHandler,
 		http.MethodDelete,
@@ -1409,6 +2545,35 @@ var getDbRecordHandler_Tab = []apiCall_TC {
 		http.StatusOK, noCheck},
 }
 
+// table of getDbRecords testcases on the typed table.
+var typedValues_Tab = []apiCall_TC {
+	{"get typed values",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`http://localhost/test/db/_table/typed|table_name=typed|fields=i,r,t,b,f,d`,
+		http.StatusOK, `{"records":[{"keys":["i","r","t","b","f","d"],"values":[1,2.5,"x","AQID",true,"2017-03-04T05:06:07Z"],"kind":"KVResponse","self":"http://localhost/test/db/_table/typed/1","etag":"\"c63f195ad2e4fc98\""},{"keys":["i","r","t","b","f","d"],"values":[null,3,"",null,false,null],"kind":"KVResponse","self":"http://localhost/test/db/_table/typed/2","etag":"\"f3321b780b5c03fa\""}],"kind":"Collection","limit":7,"offset":0}`},
+	{"get typed values by filter on NULL",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`http://localhost/test/db/_table/typed|table_name=typed|fields=r&filter=i IS NULL`,
+		http.StatusOK, `{"records":[{"keys":["r"],"values":[3],"kind":"KVResponse","self":"http://localhost/test/db/_table/typed/2","etag":"\"f3321b780b5c03fa\""}],"kind":"Collection","limit":7,"offset":0}`},
+	{"get typed values by filter on a datetime as returned",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`http://localhost/test/db/_table/typed|table_name=typed|fields=i&filter=d = '2017-03-04T05:06:07Z'`,
+		http.StatusOK, `{"records":[{"keys":["i"],"values":[1],"kind":"KVResponse","self":"http://localhost/test/db/_table/typed/1","etag":"\"c63f195ad2e4fc98\""}],"kind":"Collection","limit":7,"offset":0}`},
+	{"get typed values by filter on a datetime range",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`http://localhost/test/db/_table/typed|table_name=typed|fields=i&filter=d > '2017-03-04T05:06:06.5Z' AND d IN ('2017-03-04 05:06:07')`,
+		http.StatusOK, `{"records":[{"keys":["i"],"values":[1],"kind":"KVResponse","self":"http://localhost/test/db/_table/typed/1","etag":"\"c63f195ad2e4fc98\""}],"kind":"Collection","limit":7,"offset":0}`},
+}
+
+// the typed values test suite.
+func Test_typedValues(t *testing.T) {
+	apiCalls_Runner(t, "typedValues_Tab", typedValues_Tab)
+}
+
 // the getDbRecord test suite.  run all getDbRecord testcases.
 func Test_getDbRecordHandler(t *testing.T) {
 	apiCalls_Runner(t, "getDbRecordHandler_Tab", getDbRecordHandler_Tab)
@@ -1441,7 +2606,7 @@ var getDbRecordsHandler_Tab = []apiCall_TC {
 		http.MethodGet,
 		`http://localhost/db/_table/xxxget|table_name=xxxget|ids=1,2`,
 		http.StatusOK,
-		`{"records":[{"keys":["id","uri","name"],"values":["1","uri-a","name-a"],"kind":"KVResponse","self":"http://localhost/test/db/_table/xxxget/1"},{"keys":["id","uri","name"],"values":["2","uri-b","name-b"],"kind":"KVResponse","self":"http://localhost/test/db/_table/xxxget/2"}],"kind":"Collection"}`},
+		`{"records":[{"keys":["id","uri","name"],"values":[1,"uri-a","name-a"],"kind":"KVResponse","self":"http://localhost/test/db/_table/xxxget/1","etag":"\"21c00a5758e6d802\""},{"keys":["id","uri","name"],"values":[2,"uri-b","name-b"],"kind":"KVResponse","self":"http://localhost/test/db/_table/xxxget/2","etag":"\"24e4e2f4e148453d\""}],"kind":"Collection","limit":7,"offset":0}`},
 	{"teardown: delete table xxxget",
 		deleteDbTableHandler,
 		http.MethodDelete,
@@ -1454,6 +2619,84 @@ func Test_getDbRecordsHandler(t *testing.T) {
 	apiCalls_Runner(t, "getDbRecordsHandler_Tab", getDbRecordsHandler_Tab)
 }
 
+// ----- unit tests for the filter and order parameters.
+
+// table of testcases for APIs using the filter and order parameters.
+var filterParam_Tab = []apiCall_TC {
+	{"setup: create table xxxfilt",
+		createDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/xxxfilt|table_name=xxxfilt||`+users_schema,
+		http.StatusCreated, noCheck},
+	{"setup: create db records",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/xxxfilt|table_name=xxxfilt||{"records":[{"keys":["name","uri"],"values":["a","http://a"]},{"keys":["name","uri"],"values":["b","ftp://b"]},{"keys":["name","uri"],"values":["c","http://c"]}]}`,
+		http.StatusCreated, noCheck},
+	{"get records by filter",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`http://localhost/db/_table/xxxfilt|table_name=xxxfilt|fields=name&filter=name+%3D+'b'+OR+(uri+LIKE+'http%25'+AND+id+>+2)`,
+		http.StatusOK,
+		`{"records":[{"keys":["name"],"values":["b"],"kind":"KVResponse","self":"http://localhost/test/db/_table/xxxfilt/2","etag":"\"1c8953ec6918cc70\""},{"keys":["name"],"values":["c"],"kind":"KVResponse","self":"http://localhost/test/db/_table/xxxfilt/3","etag":"\"d25bdc814166ab66\""}],"kind":"Collection","limit":7,"offset":0}`},
+	{"get records by filter with bad syntax",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`/test/db/_table/xxxfilt|table_name=xxxfilt|filter=name+%3D`,
+		http.StatusBadRequest, noCheck},
+	{"get records by filter with unknown field",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`/test/db/_table/xxxfilt|table_name=xxxfilt|filter=bogus+%3D+1`,
+		http.StatusBadRequest, noCheck},
+	{"get records in order",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`http://localhost/db/_table/xxxfilt|table_name=xxxfilt|fields=name&order=uri+desc`,
+		http.StatusOK,
+		`{"records":[{"keys":["name"],"values":["c"],"kind":"KVResponse","self":"http://localhost/test/db/_table/xxxfilt/3","etag":"\"d25bdc814166ab66\""},{"keys":["name"],"values":["a"],"kind":"KVResponse","self":"http://localhost/test/db/_table/xxxfilt/1","etag":"\"047ddd308410fc6d\""},{"keys":["name"],"values":["b"],"kind":"KVResponse","self":"http://localhost/test/db/_table/xxxfilt/2","etag":"\"1c8953ec6918cc70\""}],"kind":"Collection","limit":7,"offset":0}`},
+	{"get records in order with unknown field",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`/test/db/_table/xxxfilt|table_name=xxxfilt|order=bogus`,
+		http.StatusBadRequest, noCheck},
+	{"get records in order with bad direction",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`/test/db/_table/xxxfilt|table_name=xxxfilt|order=name+up`,
+		http.StatusBadRequest, noCheck},
+	{"update records by filter",
+		updateDbRecordsHandler,
+		http.MethodPatch,
+		`/test/db/_table/xxxfilt|table_name=xxxfilt|filter=uri+LIKE+'http%25'|{"records":[{"keys":["name"],"values":["h"]}]}`,
+		http.StatusOK, `{"numChanged":2,"kind":"NumChangedResponse"}`},
+	{"update records by filter in body",
+		updateDbRecordsHandler,
+		http.MethodPatch,
+		`/test/db/_table/xxxfilt|table_name=xxxfilt||{"records":[{"keys":["name"],"values":["f"]}],"filter":"uri LIKE 'ftp%'"}`,
+		http.StatusOK, `{"numChanged":1,"kind":"NumChangedResponse"}`},
+	{"delete records by filter",
+		deleteDbRecordsHandler,
+		http.MethodDelete,
+		`/test/db/_table/xxxfilt|table_name=xxxfilt|filter=name+%3D+'h'`,
+		http.StatusOK, `{"numChanged":2,"kind":"NumChangedResponse"}`},
+	{"delete records by filter with unknown field",
+		deleteDbRecordsHandler,
+		http.MethodDelete,
+		`/test/db/_table/xxxfilt|table_name=xxxfilt|filter=bogus+IS+NULL`,
+		http.StatusBadRequest, noCheck},
+	{"teardown: delete table xxxfilt",
+		deleteDbTableHandler,
+		http.MethodDelete,
+		`/test/db/_schema/xxxfilt|table_name=xxxfilt`,
+		http.StatusOK, noCheck},
+}
+
+// the filter and order parameter test suite.
+func Test_filterParam(t *testing.T) {
+	apiCalls_Runner(t, "filterParam_Tab", filterParam_Tab)
+}
+
 // ----- unit tests for listToMap().
 
 // inputs and outputs for one listToMap testcase.
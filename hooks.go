@@ -0,0 +1,550 @@
+package apidCRUD
+
+// this module implements webhooks.  a webhook subscribes a URL to the
+// changes to the records of a table.  when a change is recorded, a
+// payload for each matching webhook is put in the outbox table, in the
+// same transaction as the change, so that it is delivered if and only
+// if the change is made, even if apid restarts.  the payloads are
+// delivered asynchronously, and retried with exponential backoff until
+// they are accepted or hookMaxAttempts is reached.  each payload is
+// signed with an HMAC of the webhook's secret, so that the receiver
+// can check that it came from apidCRUD.
+//
+// so that a client cannot use the deliveries to reach internal
+// services, a payload is only delivered to a public address, unless
+// the host is in hookAllowHosts.  this is checked when the webhook is
+// created, and again whenever a connection is made for a delivery,
+// since the host may resolve to a different address by then.
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// hooksCmds are the commands that create the webhooks table and
+// the outbox table, if they do not exist yet.  %[1]s is the name of
+// the webhooks table, and %[2]s is the name of the outbox table.
+var hooksCmds = []string {
+	`CREATE TABLE IF NOT EXISTS %[1]s (id integer not null primary key autoincrement, table_name text not null, url text not null, events text not null, secret text not null)`,
+	`CREATE TABLE IF NOT EXISTS %[2]s (id integer not null primary key autoincrement, hook_id integer not null, payload text not null, attempts integer not null default 0, next_attempt text not null, last_error text, delivered_at text)`,
+	`CREATE INDEX IF NOT EXISTS %[2]s_due ON %[2]s (delivered_at, next_attempt)`,
+	`CREATE INDEX IF NOT EXISTS %[2]s_hook ON %[2]s (hook_id, id)`,
+}
+
+// hookEvents are the operations that a webhook can subscribe to.
+var hookEvents = map[string]int {
+	"insert": 1,
+	"update": 1,
+	"replace": 1,
+	"delete": 1,
+	"restore": 1,
+	"purge": 1,
+}
+
+// signatureHeader is the request header that has the signature of
+// a webhook payload: "sha256=" and the hex HMAC-SHA256 of the body,
+// keyed with the webhook's secret.
+const signatureHeader = "X-Apid-Signature"
+
+// hookMaxAttempts is the number of attempts to deliver a payload,
+// after which it is left undelivered in the outbox.
+const hookMaxAttempts = 10
+
+// hookMaxBackoff is the longest wait between attempts to deliver
+// a payload.
+const hookMaxBackoff = time.Hour
+
+// hookTimeout is the longest that one attempt at a delivery may take.
+const hookTimeout = 10 * time.Second
+
+// hookClient is the http client used to deliver payloads.
+// it does not use a proxy, and only connects to allowed addresses.
+var hookClient = &http.Client{Timeout: hookTimeout,
+	Transport: &http.Transport{DialContext: hookDialContext}}
+
+// hookWorkers is the most deliveries that are made at once.
+const hookWorkers = 8
+
+// hookSlots has a slot for each delivery being made.
+var hookSlots = make(chan struct{}, hookWorkers)
+
+// deliveryMutex protects hooksInFlight.
+var deliveryMutex sync.Mutex
+
+// hooksInFlight has the ids of the webhooks whose payloads are being
+// delivered, so that a payload is not sent twice at once, and so that
+// the payloads of a webhook are delivered in order.
+var hooksInFlight = map[int64]bool{}
+
+// ensureHooksTables() creates the webhooks table and the outbox table
+// if they do not exist.
+func ensureHooksTables(db dbType) error {
+	for _, cmd := range hooksCmds {
+		_, err := db.runner().Exec(fmt.Sprintf(cmd, hooksTable, outboxTable))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateHook() checks the given webhook request.
+func validateHook(req HookRequest) error {
+	if !isValidIdent(req.Table) {
+		return fmt.Errorf("invalid table name %s", req.Table)
+	}
+	if err := reservedTableError(req.Table); err != nil {
+		return err
+	}
+	u, err := url.Parse(req.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") ||
+		u.Host == "" {
+		return fmt.Errorf("invalid url %s", req.Url)
+	}
+	if err := checkHookHost(u.Hostname()); err != nil {
+		return err
+	}
+	for _, ev := range req.Events {
+		if hookEvents[ev] == 0 {
+			return fmt.Errorf("invalid event %s", ev)
+		}
+	}
+	if req.Secret == "" {
+		return fmt.Errorf("a secret is required")
+	}
+	return nil
+}
+
+// createHook() stores the given webhook, and returns its id.
+func createHook(db dbType, req HookRequest) (idType, error) {
+	err := validateHook(req)
+	if err != nil {
+		return dbErrorRet(err)
+	}
+	err = checkTableRegistered(db, req.Table)
+	if err != nil {
+		return dbErrorRet(err)
+	}
+	exres, err := runExec(db, fmt.Sprintf(
+		"INSERT INTO %s (table_name,url,events,secret) VALUES (?,?,?,?)",
+		hooksTable),
+		[]interface{}{req.Table, req.Url, strings.Join(req.Events, ","),
+			req.Secret})
+	return exres.lastInsertId, err
+}
+
+// queryHooks() returns the webhooks with the id given by the hook_id
+// parameter, if any, on the table given by the table parameter, if any.
+// the secrets are not returned.
+func queryHooks(db dbType, params map[string]string) ([]Hook, error) {
+	ret := []Hook{}
+	where := ""
+	args := []interface{}{}
+	if id, ok := params["hook_id"]; ok {
+		where = andWhere(where, "id = ?")
+		args = append(args, aToIdType(id))
+	}
+	if params["table"] != "" {
+		where = andWhere(where, "table_name = ?")
+		args = append(args, params["table"])
+	}
+	qstring := fmt.Sprintf("SELECT id,table_name,url,events FROM %s %s ORDER BY id", // nolint
+		hooksTable, where)
+	rows, err := db.runner().Query(qstring, args...)
+	if err != nil {
+		return ret, err
+	}
+	defer rows.Close() // nolint
+	for rows.Next() {
+		h := Hook{Kind: "Hook"}
+		var events string
+		err = rows.Scan(&h.Id, &h.Table, &h.Url, &events)
+		if err != nil {
+			return ret, err
+		}
+		h.Events = []string{}
+		if events != "" {
+			h.Events = strings.Split(events, ",")
+		}
+		ret = append(ret, h)
+	}
+	return ret, rows.Err()
+}
+
+// deleteHook() removes the webhook with the given id, and its
+// undelivered payloads.  it returns the number of webhooks removed.
+func deleteHook(db dbType, id int64) (idType, error) {
+	_, err := runExec(db, fmt.Sprintf("DELETE FROM %s WHERE hook_id = ?",
+		outboxTable), []interface{}{id})
+	if err != nil {
+		return dbErrorRet(err)
+	}
+	exres, err := runExec(db, fmt.Sprintf("DELETE FROM %s WHERE id = ?",
+		hooksTable), []interface{}{id})
+	return exres.rowsAffected, err
+}
+
+// hookInfo is what recordChange() needs to know about a webhook.
+type hookInfo struct {
+	id int64
+	events map[string]int
+}
+
+// hookHostAllowed() returns true if the given host is in
+// hookAllowHosts.
+func hookHostAllowed(host string) bool {
+	for _, h := range strings.Split(hookAllowHosts, ",") {
+		h = strings.TrimSpace(h)
+		if h != "" && strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPublicIP() returns true if the given address is a public one,
+// not loopback, private, link-local, multicast, or unspecified.
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		ip.IsUnspecified())
+}
+
+// checkHookHost() returns an error if payloads may not be delivered
+// to the given host: if it is not in hookAllowHosts, and it does not
+// resolve, or resolves to an address that is not public.
+func checkHookHost(host string) error {
+	if hookHostAllowed(host) {
+		return nil
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return fmt.Errorf("cannot resolve host %s", host)
+	}
+	for _, ip := range ips {
+		if !isPublicIP(ip) {
+			return fmt.Errorf("host %s has an address that is not public: %s",
+				host, ip)
+		}
+	}
+	return nil
+}
+
+// hookDialContext() makes the connections for hookClient.  unless the
+// host is in hookAllowHosts, it refuses to connect to an address
+// that is not public.
+func hookDialContext(ctx context.Context,
+	network string,
+	addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: hookTimeout}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if !hookHostAllowed(host) {
+		dialer.Control = func(network string,
+			address string,
+			c syscall.RawConn) error {
+			ahost, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(ahost)
+			if ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("address %s of host %s is not public",
+					ahost, host)
+			}
+			return nil
+		}
+	}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// tableHooks() returns the webhooks on the given table.
+func tableHooks(db dbType, tabName string) ([]hookInfo, error) {
+	ret := []hookInfo{}
+	rows, err := db.runner().Query(fmt.Sprintf(
+		"SELECT id,events FROM %s WHERE table_name = ?", hooksTable),
+		tabName)
+	if err != nil {
+		return ret, err
+	}
+	defer rows.Close() // nolint
+	for rows.Next() {
+		var h hookInfo
+		var events string
+		err = rows.Scan(&h.id, &events)
+		if err != nil {
+			return ret, err
+		}
+		if events != "" {
+			h.events = listToMap(strings.Split(events, ","))
+		}
+		ret = append(ret, h)
+	}
+	return ret, rows.Err()
+}
+
+// queueHooks() puts the payload for the given change in the outbox,
+// once for each of the given webhooks that subscribes to it.
+// a webhook with no events subscribes to all of them.
+func queueHooks(db dbType,
+	hooks []hookInfo,
+	payload HookPayload,
+	ts string) error {
+	for _, h := range hooks {
+		if h.events != nil && h.events[payload.Op] == 0 {
+			continue
+		}
+		payload.HookId = h.id
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		_, err = runExec(db, fmt.Sprintf(
+			"INSERT INTO %s (hook_id,payload,next_attempt) VALUES (?,?,?)",
+			outboxTable),
+			[]interface{}{h.id, string(data), ts})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hookSignature() returns the signature of the given payload,
+// for the signature header.
+func hookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body) // nolint
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// hookBackoff() returns how long to wait before the next attempt to
+// deliver a payload, after the given number of failed attempts.
+func hookBackoff(attempts int) time.Duration {
+	d := time.Second
+	for i := 1; i < attempts && d < hookMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > hookMaxBackoff {
+		d = hookMaxBackoff
+	}
+	return d
+}
+
+// sendHook() makes one attempt to deliver a payload.
+// any status other than 2xx is a failure.
+func sendHook(client *http.Client,
+	hookUrl string,
+	secret string,
+	outboxId int64,
+	payload string) error {
+	body := []byte(payload)
+	req, err := http.NewRequest(http.MethodPost, hookUrl,
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(signatureHeader, hookSignature(secret, body))
+	req.Header.Set("X-Apid-Delivery", strconv.FormatInt(outboxId, 10))
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close() // nolint
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// dueDelivery is a payload in the outbox that is due to be delivered.
+type dueDelivery struct {
+	id int64
+	hookId int64
+	payload string
+	attempts int
+	url string
+	secret string
+}
+
+// dueDeliveries() returns the payloads that are due to be delivered
+// at the given time, oldest first.  the payloads of a webhook are
+// delivered in order, so a payload that is waiting for the backoff
+// after a failure holds up the later payloads of its webhook.
+// a payload whose attempts are used up no longer holds them up.
+func dueDeliveries(db dbType, now time.Time) ([]dueDelivery, error) {
+	ret := []dueDelivery{}
+	rows, err := db.runner().Query(fmt.Sprintf(
+		"SELECT o.id,o.hook_id,o.payload,o.attempts,h.url,h.secret FROM %[1]s o JOIN %[2]s h ON h.id = o.hook_id WHERE o.delivered_at IS NULL AND o.attempts < ? AND NOT EXISTS (SELECT 1 FROM %[1]s p WHERE p.hook_id = o.hook_id AND p.id <= o.id AND p.delivered_at IS NULL AND p.attempts < ? AND p.next_attempt > ?) ORDER BY o.id LIMIT ?",
+		outboxTable, hooksTable),
+		hookMaxAttempts, hookMaxAttempts, dbTime(now), maxRecs)
+	if err != nil {
+		return ret, err
+	}
+	defer rows.Close() // nolint
+	for rows.Next() {
+		var d dueDelivery
+		err = rows.Scan(&d.id, &d.hookId, &d.payload, &d.attempts,
+			&d.url, &d.secret)
+		if err != nil {
+			return ret, err
+		}
+		ret = append(ret, d)
+	}
+	return ret, rows.Err()
+}
+
+// deliverDue() makes one attempt to deliver each payload that is due
+// at the given time, and records the outcome in the outbox.  the
+// payloads of different webhooks are delivered concurrently, by at
+// most hookWorkers at once, so that a slow receiver does not hold up
+// the others.  the payloads of one webhook are delivered in order,
+// up to the first that fails.  a webhook whose payloads are already
+// being delivered, by an earlier call, is skipped.
+// it returns the number of payloads delivered.
+func deliverDue(db dbType, client *http.Client, now time.Time) (int, error) {
+	groups, err := claimDeliveries(db, now)
+	if err != nil {
+		return 0, err
+	}
+	var wg sync.WaitGroup
+	counts := make([]int, len(groups))
+	errs := make([]error, len(groups))
+	for i, group := range groups {
+		wg.Add(1)
+		go func(i int, group []dueDelivery) {
+			defer wg.Done()
+			hookSlots <- struct{}{}
+			counts[i], errs[i] = deliverGroup(db, client, now, group)
+			<-hookSlots
+			deliveryMutex.Lock()
+			delete(hooksInFlight, group[0].hookId)
+			deliveryMutex.Unlock()
+		}(i, group)
+	}
+	wg.Wait()
+	n := 0
+	for i := range groups {
+		n += counts[i]
+		if err == nil {
+			err = errs[i]
+		}
+	}
+	return n, err
+}
+
+// claimDeliveries() returns the payloads that are due to be delivered
+// at the given time, in groups by webhook, skipping the webhooks that
+// are in flight, and marks the webhooks of the groups as in flight.
+func claimDeliveries(db dbType, now time.Time) ([][]dueDelivery, error) {
+	deliveryMutex.Lock()
+	defer deliveryMutex.Unlock()
+	due, err := dueDeliveries(db, now)
+	if err != nil {
+		return nil, err
+	}
+	groups := [][]dueDelivery{}
+	index := map[int64]int{}
+	for _, d := range due {
+		if hooksInFlight[d.hookId] {
+			continue
+		}
+		i, ok := index[d.hookId]
+		if !ok {
+			i = len(groups)
+			index[d.hookId] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], d)
+	}
+	for hookId := range index {
+		hooksInFlight[hookId] = true
+	}
+	return groups, nil
+}
+
+// deliverGroup() delivers the given payloads of one webhook in order,
+// recording the outcome of each, and stops after the first failure.
+// it returns the number of payloads delivered.
+func deliverGroup(db dbType,
+	client *http.Client,
+	now time.Time,
+	group []dueDelivery) (int, error) {
+	n := 0
+	for _, d := range group {
+		err := sendHook(client, d.url, d.secret, d.id, d.payload)
+		if err != nil {
+			log.Debugf("delivery %d failed [%s]", d.id, err)
+			next := now.Add(hookBackoff(d.attempts + 1))
+			_, err = runExec(db, fmt.Sprintf(
+				"UPDATE %s SET attempts = attempts + 1, next_attempt = ?, last_error = ? WHERE id = ?",
+				outboxTable),
+				[]interface{}{dbTime(next), err.Error(), d.id})
+			return n, err
+		}
+		n++
+		_, err = runExec(db, fmt.Sprintf(
+			"UPDATE %s SET attempts = attempts + 1, delivered_at = ?, last_error = NULL WHERE id = ?",
+			outboxTable),
+			[]interface{}{dbTime(time.Now()), d.id})
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// deliverHooks() delivers payloads from the outbox, forever.
+// it is woken by the commit of a change, and polls for payloads
+// whose retries are due, or that another apid instance queued.
+// each round of deliveries runs on its own, so that a round that
+// is waiting for a slow receiver does not hold up the next one.
+func deliverHooks(db dbType, client *http.Client) {
+	for {
+		signal := changeWaiter()
+		go func() {
+			_, err := deliverDue(db, client, time.Now())
+			if err != nil {
+				log.Errorf("webhook delivery: %s", err)
+			}
+		}()
+		timer := time.NewTimer(changePollInterval)
+		select {
+		case <-signal:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// hookOutbox() returns the state of the payloads for the given webhook,
+// for the hook's status.
+func hookOutbox(db dbType, id int64) (HookStatus, error) {
+	var st HookStatus
+	var lastError sql.NullString
+	err := db.runner().QueryRow(fmt.Sprintf(
+		"SELECT COUNT(delivered_at), COUNT(*) - COUNT(delivered_at), COALESCE(SUM(attempts >= ? AND delivered_at IS NULL),0), (SELECT last_error FROM %[1]s WHERE hook_id = ? AND last_error IS NOT NULL ORDER BY id DESC LIMIT 1) FROM %[1]s WHERE hook_id = ?",
+		outboxTable), hookMaxAttempts, id, id).Scan(
+		&st.Delivered, &st.Pending, &st.Failed, &lastError)
+	st.Pending -= st.Failed
+	st.LastError = lastError.String
+	return st, err
+}
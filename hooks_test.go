@@ -0,0 +1,392 @@
+package apidCRUD
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ----- unit tests for webhooks
+
+// hookReceiver is a local receiver of webhook deliveries.
+type hookReceiver struct {
+	mutex sync.Mutex
+	status int
+	payloads []HookPayload
+	signatures []string
+	bodies [][]byte
+}
+
+// ServeHTTP() records a delivery, and responds with the receiver's status.
+func (hr *hookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hr.mutex.Lock()
+	defer hr.mutex.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	var p HookPayload
+	_ = json.Unmarshal(body, &p)
+	hr.payloads = append(hr.payloads, p)
+	hr.signatures = append(hr.signatures, r.Header.Get(signatureHeader))
+	hr.bodies = append(hr.bodies, body)
+	w.WriteHeader(hr.status)
+}
+
+// ops() returns the operations of the payloads received.
+func (hr *hookReceiver) ops() []string {
+	hr.mutex.Lock()
+	defer hr.mutex.Unlock()
+	ret := []string{}
+	for _, p := range hr.payloads {
+		ret = append(ret, p.Op)
+	}
+	return ret
+}
+
+// the webhook test suite.
+func Test_hooks(t *testing.T) {
+	cx := newTestContext(t)
+	recv := &hookReceiver{status: http.StatusOK}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	setup := []apiCall_TC {
+		{"setup: create table HK",
+			createDbTableHandler,
+			http.MethodPost,
+			`/test/db/_schema/HK|table_name=HK||{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"}]}`,
+			http.StatusCreated, noCheck},
+		{"create hook w/ bad url",
+			createDbHookHandler,
+			http.MethodPost,
+			`/test/db/_hooks|||{"table":"HK","url":"ftp://x","secret":"s"}`,
+			http.StatusBadRequest, noCheck},
+		{"create hook w/ private url",
+			createDbHookHandler,
+			http.MethodPost,
+			`/test/db/_hooks|||{"table":"HK","url":"http://10.0.0.1/x","secret":"s"}`,
+			http.StatusBadRequest, noCheck},
+		{"create hook w/ link-local url",
+			createDbHookHandler,
+			http.MethodPost,
+			`/test/db/_hooks|||{"table":"HK","url":"http://169.254.169.254/","secret":"s"}`,
+			http.StatusBadRequest, noCheck},
+		{"create hook w/ bad event",
+			createDbHookHandler,
+			http.MethodPost,
+			`/test/db/_hooks|||{"table":"HK","url":"` + server.URL + `","events":["upsert"],"secret":"s"}`,
+			http.StatusBadRequest, noCheck},
+		{"create hook w/o secret",
+			createDbHookHandler,
+			http.MethodPost,
+			`/test/db/_hooks|||{"table":"HK","url":"` + server.URL + `"}`,
+			http.StatusBadRequest, noCheck},
+		{"create hook on missing table",
+			createDbHookHandler,
+			http.MethodPost,
+			`/test/db/_hooks|||{"table":"NOSUCH","url":"` + server.URL + `","secret":"s"}`,
+			http.StatusNotFound, noCheck},
+	}
+	for _, tc := range setup {
+		apiCall_Checker(cx, &tc)
+	}
+	defer callApiHandler(deleteDbTableHandler, http.MethodDelete,
+		`/test/db/_schema/HK|table_name=HK`)
+
+	res := callApiHandler(createDbHookHandler, http.MethodPost,
+		`/test/db/_hooks|||{"table":"HK","url":"` + server.URL +
+		`","events":["insert","update"],"secret":"sesame"}`)
+	cx.assertEqual(http.StatusCreated, res.code, "create hook")
+	ids, _ := res.data.(IdsResponse)
+	if !cx.assertEqual(1, len(ids.Ids), "number of hook ids") {
+		return
+	}
+	hookId := ids.Ids[0]
+
+	calls := []string{
+		`/test/db/_table/HK|table_name=HK||{"records":[{"keys":["name"],"values":["a"]}]}`,
+		`/test/db/_table/HK|table_name=HK&id=1||{"records":[{"keys":["name"],"values":["aa"]}]}`,
+		`/test/db/_table/HK|table_name=HK&id=1`,
+	}
+	hfs := []apiHandler{createDbRecordsHandler, updateDbRecordHandler,
+		deleteDbRecordHandler}
+	verbs := []string{http.MethodPost, http.MethodPatch, http.MethodDelete}
+	for i, desc := range calls {
+		res = callApiHandler(hfs[i], verbs[i], desc)
+		cx.assertTrue(res.code < 300, desc)
+	}
+
+	// the delete is not subscribed to.
+	now := time.Now()
+	n, err := deliverDue(db, server.Client(), now)
+	cx.assertErrorNil(err, "deliverDue")
+	cx.assertEqual(2, n, "number delivered")
+	cx.assertEqualObj([]string{"insert", "update"}, recv.ops(),
+		"ops delivered")
+	if len(recv.payloads) == 2 {
+		p := recv.payloads[1]
+		cx.assertEqual(hookId, p.HookId, "hookId of payload")
+		cx.assertEqual("HK", p.Table, "table of payload")
+		cx.assertEqual(int64(1), p.RecordId, "recordId of payload")
+		cx.assertTrue(p.Seq > recv.payloads[0].Seq, "seq of payload")
+		after := map[string]interface{}{}
+		err = json.Unmarshal(p.After, &after)
+		cx.assertErrorNil(err, "unmarshal after")
+		cx.assertEqual("aa", after["name"], "after of payload")
+		cx.assertEqual(hookSignature("sesame", recv.bodies[1]),
+			recv.signatures[1], "signature of payload")
+	}
+
+	// a failed delivery is retried after the backoff.
+	recv.status = http.StatusInternalServerError
+	res = callApiHandler(createDbRecordsHandler, http.MethodPost,
+		`/test/db/_table/HK|table_name=HK||{"records":[{"keys":["name"],"values":["b"]}]}`)
+	cx.assertEqual(http.StatusCreated, res.code, "create record b")
+	now = time.Now()
+	n, err = deliverDue(db, server.Client(), now)
+	cx.assertErrorNil(err, "deliverDue failing")
+	cx.assertEqual(0, n, "number delivered when failing")
+	cx.assertEqual(3, len(recv.ops()), "number of attempts")
+	n, _ = deliverDue(db, server.Client(), now)
+	cx.assertEqual(3, len(recv.ops()), "attempts before backoff")
+
+	hdesc := fmt.Sprintf(`/test/db/_hooks/%d|hook_id=%d`, hookId, hookId)
+	res = callApiHandler(getDbHookHandler, http.MethodGet, hdesc)
+	cx.assertEqual(http.StatusOK, res.code, "get hook")
+	hook, _ := res.data.(Hook)
+	if cx.assertTrue(hook.Status != nil, "hook status") {
+		cx.assertEqualObj(HookStatus{Delivered: 2, Pending: 1,
+			LastError: "status 500"}, *hook.Status, "hook status")
+	}
+	cx.assertEqualObj([]string{"insert", "update"}, hook.Events,
+		"hook events")
+
+	recv.status = http.StatusNoContent
+	n, err = deliverDue(db, server.Client(), now.Add(hookBackoff(1)))
+	cx.assertErrorNil(err, "deliverDue after backoff")
+	cx.assertEqual(1, n, "number delivered after backoff")
+	cx.assertEqual(4, len(recv.ops()), "attempts after backoff")
+
+	res = callApiHandler(getDbHooksHandler, http.MethodGet,
+		`/test/db/_hooks||table=HK`)
+	cx.assertEqual(http.StatusOK, res.code, "get hooks")
+	hooks, _ := res.data.(HooksResponse)
+	if cx.assertEqual(1, len(hooks.Hooks), "number of hooks") {
+		cx.assertEqual(server.URL, hooks.Hooks[0].Url, "url of hook")
+		cx.assertTrue(hooks.Hooks[0].Status == nil, "status of listed hook")
+	}
+
+	res = callApiHandler(deleteDbHookHandler, http.MethodDelete, hdesc)
+	cx.assertEqual(http.StatusOK, res.code, "delete hook")
+	res = callApiHandler(deleteDbHookHandler, http.MethodDelete, hdesc)
+	cx.assertEqual(http.StatusNotFound, res.code, "delete deleted hook")
+	res = callApiHandler(getDbHookHandler, http.MethodGet, hdesc)
+	cx.assertEqual(http.StatusNotFound, res.code, "get deleted hook")
+}
+
+// ----- unit tests for hookBackoff()
+
+func Test_hookBackoff(t *testing.T) {
+	cx := newTestContext(t)
+	cx.assertEqual(time.Second, hookBackoff(1), "backoff after 1")
+	cx.assertEqual(4*time.Second, hookBackoff(3), "backoff after 3")
+	cx.assertEqual(hookMaxBackoff, hookBackoff(hookMaxAttempts+20),
+		"maximum backoff")
+}
+
+// deleting a table deletes its webhooks and their undelivered payloads.
+func Test_deleteTableHooks(t *testing.T) {
+	cx := newTestContext(t)
+	res := callApiHandler(createDbTableHandler, http.MethodPost,
+		`/test/db/_schema/HKD|table_name=HKD||{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"}]}`)
+	cx.assertEqual(http.StatusCreated, res.code, "create table")
+	res = callApiHandler(createDbHookHandler, http.MethodPost,
+		`/test/db/_hooks|||{"table":"HKD","url":"http://127.0.0.1:1/","secret":"s"}`)
+	cx.assertEqual(http.StatusCreated, res.code, "create hook")
+	ids, _ := res.data.(IdsResponse)
+	if !cx.assertEqual(1, len(ids.Ids), "number of hook ids") {
+		return
+	}
+	res = callApiHandler(createDbRecordsHandler, http.MethodPost,
+		`/test/db/_table/HKD|table_name=HKD||{"records":[{"keys":["name"],"values":["a"]}]}`)
+	cx.assertEqual(http.StatusCreated, res.code, "create record")
+
+	count := func(table string, col string, arg interface{}) int {
+		var n int
+		err := db.handle.QueryRow(fmt.Sprintf(
+			"SELECT count(*) FROM %s WHERE %s = ?", table, col),
+			arg).Scan(&n)
+		cx.assertErrorNil(err, "count "+table)
+		return n
+	}
+	cx.assertEqual(1, count(outboxTable, "hook_id", ids.Ids[0]),
+		"payloads before delete")
+
+	res = callApiHandler(deleteDbTableHandler, http.MethodDelete,
+		`/test/db/_schema/HKD|table_name=HKD`)
+	cx.assertEqual(http.StatusOK, res.code, "delete table")
+	cx.assertEqual(0, count(hooksTable, "table_name", "HKD"),
+		"hooks after delete")
+	cx.assertEqual(0, count(outboxTable, "hook_id", ids.Ids[0]),
+		"payloads after delete")
+}
+
+// a payload that is waiting for its retry holds up the later payloads
+// of its webhook, so they are delivered in order.
+func Test_hookOrder(t *testing.T) {
+	cx := newTestContext(t)
+	recv := &hookReceiver{status: http.StatusInternalServerError}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	res := callApiHandler(createDbTableHandler, http.MethodPost,
+		`/test/db/_schema/HO|table_name=HO||{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"}]}`)
+	cx.assertEqual(http.StatusCreated, res.code, "create table HO")
+	defer callApiHandler(deleteDbTableHandler, http.MethodDelete,
+		`/test/db/_schema/HO|table_name=HO`)
+	res = callApiHandler(createDbHookHandler, http.MethodPost,
+		`/test/db/_hooks|||{"table":"HO","url":"` + server.URL +
+		`","secret":"s"}`)
+	cx.assertEqual(http.StatusCreated, res.code, "create hook")
+
+	create := func(name string) {
+		res := callApiHandler(createDbRecordsHandler, http.MethodPost,
+			`/test/db/_table/HO|table_name=HO||{"records":[{"keys":["name"],"values":["` +
+			name + `"]}]}`)
+		cx.assertEqual(http.StatusCreated, res.code, "create record "+name)
+	}
+	recordIds := func() []int64 {
+		recv.mutex.Lock()
+		defer recv.mutex.Unlock()
+		ret := []int64{}
+		for _, p := range recv.payloads {
+			ret = append(ret, p.RecordId)
+		}
+		return ret
+	}
+
+	create("a")
+	now := time.Now()
+	n, err := deliverDue(db, server.Client(), now)
+	cx.assertErrorNil(err, "deliverDue failing")
+	cx.assertEqual(0, n, "number delivered when failing")
+
+	// the later payload waits for the retry of the failed one.
+	recv.mutex.Lock()
+	recv.status = http.StatusOK
+	recv.mutex.Unlock()
+	create("b")
+	n, err = deliverDue(db, server.Client(), time.Now())
+	cx.assertErrorNil(err, "deliverDue during backoff")
+	cx.assertEqual(0, n, "number delivered during backoff")
+	cx.assertEqualObj([]int64{1}, recordIds(), "records sent during backoff")
+
+	n, err = deliverDue(db, server.Client(), now.Add(hookBackoff(1)))
+	cx.assertErrorNil(err, "deliverDue after backoff")
+	cx.assertEqual(2, n, "number delivered after backoff")
+	cx.assertEqualObj([]int64{1, 1, 2}, recordIds(), "records sent in order")
+}
+
+// ----- unit tests for the checks of webhook hosts
+
+func Test_checkHookHost(t *testing.T) {
+	cx := newTestContext(t)
+	saved := hookAllowHosts
+	defer func() { hookAllowHosts = saved }()
+	hookAllowHosts = "127.0.0.1, Internal.Example"
+	cx.assertErrorNil(checkHookHost("127.0.0.1"), "allowed address")
+	cx.assertErrorNil(checkHookHost("internal.example"), "allowed host")
+	cx.assertErrorNil(checkHookHost("93.184.216.34"), "public address")
+	for _, host := range []string{"::1", "10.1.2.3", "192.168.0.1",
+		"172.16.0.1", "169.254.169.254", "fe80::1", "0.0.0.0",
+		"224.0.0.1"} {
+		cx.assertTrue(checkHookHost(host) != nil, host)
+	}
+}
+
+func Test_hookClient(t *testing.T) {
+	cx := newTestContext(t)
+	saved := hookAllowHosts
+	defer func() { hookAllowHosts = saved }()
+	recv := &hookReceiver{status: http.StatusOK}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	// the server is on a loopback address.
+	hookAllowHosts = ""
+	err := sendHook(hookClient, server.URL, "s", 1, `{}`)
+	cx.assertTrue(err != nil, "delivery to loopback")
+	cx.assertEqual(0, len(recv.ops()), "payloads received")
+
+	hookAllowHosts = "127.0.0.1"
+	err = sendHook(hookClient, server.URL, "s", 1, `{}`)
+	cx.assertErrorNil(err, "delivery to allowed host")
+	cx.assertEqual(1, len(recv.ops()), "payloads received")
+}
+
+// inFlightCount() returns the number of webhooks in flight.
+func inFlightCount() int {
+	deliveryMutex.Lock()
+	defer deliveryMutex.Unlock()
+	return len(hooksInFlight)
+}
+
+// a webhook whose receiver is slow does not hold up the others.
+func Test_hookConcurrency(t *testing.T) {
+	cx := newTestContext(t)
+	fast := &hookReceiver{status: http.StatusOK}
+	fastServer := httptest.NewServer(fast)
+	defer fastServer.Close()
+	arrived := make(chan bool, 10)
+	release := make(chan bool)
+	slowServer := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			arrived <- true
+			<-release
+		}))
+	defer slowServer.Close()
+
+	res := callApiHandler(createDbTableHandler, http.MethodPost,
+		`/test/db/_schema/HC|table_name=HC||{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"}]}`)
+	cx.assertEqual(http.StatusCreated, res.code, "create table HC")
+	defer callApiHandler(deleteDbTableHandler, http.MethodDelete,
+		`/test/db/_schema/HC|table_name=HC`)
+	for _, u := range []string{slowServer.URL, fastServer.URL} {
+		res = callApiHandler(createDbHookHandler, http.MethodPost,
+			`/test/db/_hooks|||{"table":"HC","url":"` + u +
+			`","secret":"s"}`)
+		cx.assertEqual(http.StatusCreated, res.code, "create hook")
+	}
+	create := `/test/db/_table/HC|table_name=HC||{"records":[{"keys":["name"],"values":["a"]}]}`
+
+	res = callApiHandler(createDbRecordsHandler, http.MethodPost, create)
+	cx.assertEqual(http.StatusCreated, res.code, "create record")
+	done := make(chan int)
+	go func() {
+		n, _ := deliverDue(db, fastServer.Client(), time.Now())
+		done <- n
+	}()
+	<-arrived
+	for i := 0; i < 500 && inFlightCount() > 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cx.assertEqual(1, inFlightCount(), "number of hooks in flight")
+
+	// the slow webhook is in flight, and is skipped.
+	res = callApiHandler(createDbRecordsHandler, http.MethodPost, create)
+	cx.assertEqual(http.StatusCreated, res.code, "create record")
+	t0 := time.Now()
+	n, err := deliverDue(db, fastServer.Client(), time.Now())
+	cx.assertErrorNil(err, "deliverDue")
+	cx.assertTrue(time.Since(t0) < 5*time.Second, "not held up")
+	cx.assertEqual(1, n, "number delivered while slow")
+
+	close(release)
+	cx.assertEqual(2, <-done, "number delivered by first call")
+	cx.assertEqual(2, len(fast.ops()), "number received by fast hook")
+	n, _ = deliverDue(db, fastServer.Client(), time.Now())
+	cx.assertEqual(1, n, "number delivered after release")
+}
@@ -2,8 +2,10 @@ package apidCRUD
 
 import (
 	"fmt"
+	"net/http"
 	"strings"
 	"strconv"
+	"time"
 	"unicode"
 )
 
@@ -35,6 +37,28 @@ var validators = map[string]paramValidator {
 	"ids": validate_ids,
 	"limit": validate_limit,
 	"offset": validate_offset,
+	"filter": validate_filter,
+	"order": validate_order,
+	"include_count": validate_include_count,
+	"cursor": validate_cursor,
+	"index_name": validate_index_name,
+	"on_conflict": validate_on_conflict,
+	"atomic": validate_atomic,
+	"create_if_missing": validate_create_if_missing,
+	"include_deleted": validate_include_deleted,
+	"older_than": validate_older_than,
+	"table": validate_table,
+	"start_time": validate_start_time,
+	"end_time": validate_end_time,
+	"since": validate_since,
+	"hook_id": validate_hook_id,
+	"wait": validate_wait,
+	"conflict_target": validate_conflict_target,
+	"aggregate": validate_aggregate,
+	"group_by": validate_group_by,
+	"refresh": validate_refresh,
+	"new_name": validate_new_name,
+	"with_data": validate_with_data,
 }
 
 // paramType tells which parameters come from where.
@@ -42,6 +66,8 @@ var validators = map[string]paramValidator {
 var paramType = map[string]int {
 	"table_name": paramPathOnly,
 	"id": paramPathOrQuery,
+	"index_name": paramPathOnly,
+	"hook_id": paramPathOnly,
 }
 
 // ----- start of functions
@@ -112,7 +138,16 @@ func validate_table_name(table_name string) (string, error) {
 	if table_name == "" || ! isValidIdent(table_name) {
 		return table_name, fmt.Errorf("invalid table name %s", table_name)
 	}
-	return table_name, nil
+	return table_name, reservedTableError(table_name)
+}
+
+// validate_index_name() is the validator for the "index_name" parameter.
+func validate_index_name(index_name string) (string, error) {
+	log.Debugf("... index_name = %s", index_name)
+	if index_name == "" || ! isValidIdent(index_name) {
+		return index_name, fmt.Errorf("invalid index name %s", index_name)
+	}
+	return index_name, nil
 }
 
 // validate_id_field() is the validator for the "id_field" parameter.
@@ -201,8 +236,378 @@ func validate_offset(s string) (string, error) {
 	return idTypeToA(n), nil
 }
 
+// validate_filter() checks the syntax of the given filter expression.
+// the empty string is valid and means no filtering.
+// checking the filter's field names against the table is done later,
+// since that requires database access.
+func validate_filter(filter string) (string, error) {
+	log.Debugf("... filter = %s", filter)
+	_, err := parseFilter(filter)
+	if err != nil {
+		return filter, err
+	}
+	return strings.TrimSpace(filter), nil
+}
+
+// validate_order() is the validator for the "order" parameter,
+// a comma-separated list of field names, each optionally followed
+// by asc or desc.  the value is returned in normalized form,
+// eg "name ASC,id DESC".  the empty string is valid and means
+// the default order.
+func validate_order(order string) (string, error) {
+	log.Debugf("... order = %s", order)
+	items, err := parseOrder(order)
+	if err != nil {
+		return order, err
+	}
+	return orderToA(items), nil
+}
+
+// validate_include_count() is the validator for the "include_count"
+// parameter, a boolean that defaults to false.
+func validate_include_count(s string) (string, error) {
+	log.Debugf("... include_count = %s", s)
+	return validateBool(s, false)
+}
+
+// validate_atomic() is the validator for the "atomic"
+// parameter, a boolean that defaults to true.
+func validate_atomic(s string) (string, error) {
+	log.Debugf("... atomic = %s", s)
+	return validateBool(s, true)
+}
+
+// validate_create_if_missing() is the validator for the
+// "create_if_missing" parameter, a boolean that defaults to false.
+func validate_create_if_missing(s string) (string, error) {
+	log.Debugf("... create_if_missing = %s", s)
+	return validateBool(s, false)
+}
+
+// validate_include_deleted() is the validator for the
+// "include_deleted" parameter, a boolean that defaults to false.
+func validate_include_deleted(s string) (string, error) {
+	log.Debugf("... include_deleted = %s", s)
+	return validateBool(s, false)
+}
+
+// validate_older_than() is the validator for the "older_than"
+// parameter, a nonnegative duration such as 90m, 12h, or 30d.
+// the default is 0s.
+func validate_older_than(s string) (string, error) {
+	log.Debugf("... older_than = %s", s)
+	if s == "" {
+		return "0s", nil
+	}
+	ds := s
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return s, fmt.Errorf("invalid older_than %s", s)
+		}
+		ds = strconv.Itoa(days * 24) + "h"
+	}
+	d, err := time.ParseDuration(ds)
+	if err != nil || d < 0 {
+		return s, fmt.Errorf("invalid older_than %s", s)
+	}
+	return d.String(), nil
+}
+
+// validate_table() is the validator for the "table" parameter,
+// an optional table name.
+func validate_table(s string) (string, error) {
+	log.Debugf("... table = %s", s)
+	if s != "" && !isValidIdent(s) {
+		return s, fmt.Errorf("invalid table name %s", s)
+	}
+	if s != "" {
+		return s, reservedTableError(s)
+	}
+	return s, nil
+}
+
+// validate_start_time() is the validator for the "start_time" parameter.
+func validate_start_time(s string) (string, error) {
+	log.Debugf("... start_time = %s", s)
+	return validateTime(s)
+}
+
+// validate_end_time() is the validator for the "end_time" parameter.
+func validate_end_time(s string) (string, error) {
+	log.Debugf("... end_time = %s", s)
+	return validateTime(s)
+}
+
+// validate_hook_id() is the validator for the "hook_id" parameter.
+func validate_hook_id(s string) (string, error) {
+	log.Debugf("... hook_id = %s", s)
+	return validate_id(s)
+}
+
+// validate_since() is the validator for the "since" parameter,
+// a change sequence number.  the default is 0, before all changes.
+func validate_since(s string) (string, error) {
+	log.Debugf("... since = %s", s)
+	if s == "" {
+		return "0", nil
+	}
+	n, err := strconv.ParseInt(s, idTypeRadix, idTypeBits)
+	if err != nil || n < 0 {
+		return s, fmt.Errorf("invalid since %s", s)
+	}
+	return idTypeToA(n), nil
+}
+
+// validate_wait() is the validator for the "wait" parameter,
+// a nonnegative duration such as 30s, or a number of seconds.
+// the default is 0s.  longer durations are reduced to maxChangeWait.
+func validate_wait(s string) (string, error) {
+	log.Debugf("... wait = %s", s)
+	if s == "" {
+		return "0s", nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		n, nerr := strconv.Atoi(s)
+		d, err = time.Duration(n) * time.Second, nerr
+	}
+	if err != nil || d < 0 {
+		return s, fmt.Errorf("invalid wait %s", s)
+	}
+	if d > maxChangeWait {
+		d = maxChangeWait
+	}
+	return d.String(), nil
+}
+
+// timeFormats are the formats accepted for time parameters.
+var timeFormats = []string {
+	time.RFC3339Nano,
+	dbTimeFormat,
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// validateTime() checks the given string for validity as a time,
+// in one of timeFormats, and returns it as stored in the database.
+// the empty string is valid and means no time.
+func validateTime(s string) (string, error) {
+	if s == "" {
+		return s, nil
+	}
+	for _, f := range timeFormats {
+		t, err := time.Parse(f, s)
+		if err == nil {
+			return dbTime(t), nil
+		}
+	}
+	return s, fmt.Errorf("invalid time %s", s)
+}
+
+// onConflictModes are the allowed values of the on_conflict parameter.
+var onConflictModes = map[string]int {
+	"error": 1,
+	"ignore": 1,
+	"replace": 1,
+	"update": 1,
+}
+
+// validate_on_conflict() is the validator for the "on_conflict"
+// parameter, which tells createDbRecords what to do with a record
+// that conflicts with an existing one.  the default is "error".
+func validate_on_conflict(s string) (string, error) {
+	log.Debugf("... on_conflict = %s", s)
+	if s == "" {
+		return "error", nil
+	}
+	s = strings.ToLower(s)
+	if onConflictModes[s] == 0 {
+		return s, fmt.Errorf("invalid on_conflict %s", s)
+	}
+	return s, nil
+}
+
+// validate_conflict_target() is the validator for the
+// "conflict_target" parameter, a list of field names.
+// the empty string means the id field.
+func validate_conflict_target(s string) (string, error) {
+	log.Debugf("... conflict_target = %s", s)
+	if s == "" {
+		return s, nil
+	}
+	for _, f := range strings.Split(s, ",") {
+		if ! isValidIdent(f) {
+			return s, fmt.Errorf("invalid conflict_target field %s", f)
+		}
+	}
+	return s, nil
+}
+
+// validate_cursor() is the validator for the "cursor" parameter,
+// an opaque string returned as nextCursor by a previous request.
+// whether it matches the current request is checked later.
+func validate_cursor(s string) (string, error) {
+	log.Debugf("... cursor = %s", s)
+	if s == "" {
+		return s, nil
+	}
+	_, err := decodeCursor(s)
+	return s, err
+}
+
+// validate_aggregate() is the validator for the "aggregate" parameter.
+func validate_aggregate(s string) (string, error) {
+	log.Debugf("... aggregate = %s", s)
+	items, err := parseAggregates(s)
+	if err != nil {
+		return s, err
+	}
+	strs := make([]string, len(items))
+	for i, ai := range items {
+		strs[i] = ai.String()
+	}
+	return strings.Join(strs, ","), nil
+}
+
+// validate_group_by() is the validator for the "group_by" parameter,
+// a list of field names.
+func validate_group_by(s string) (string, error) {
+	log.Debugf("... group_by = %s", s)
+	if s == "" {
+		return s, nil
+	}
+	seen := map[string]bool{}
+	for _, f := range strings.Split(s, ",") {
+		if !isValidIdent(f) {
+			return s, fmt.Errorf("invalid group_by field %s", f)
+		}
+		if seen[f] {
+			return s, fmt.Errorf("duplicate group_by field %s", f)
+		}
+		seen[f] = true
+	}
+	return s, nil
+}
+
+// validate_refresh() is the validator for the "refresh" parameter,
+// a boolean that defaults to false.
+func validate_refresh(s string) (string, error) {
+	log.Debugf("... refresh = %s", s)
+	return validateBool(s, false)
+}
+
+// validate_new_name() is the validator for the "new_name" parameter,
+// the name of the table made by renameDbTable or copyDbTable.
+func validate_new_name(s string) (string, error) {
+	log.Debugf("... new_name = %s", s)
+	return validate_table_name(s)
+}
+
+// validate_with_data() is the validator for the "with_data" parameter,
+// a boolean that defaults to false.
+func validate_with_data(s string) (string, error) {
+	log.Debugf("... with_data = %s", s)
+	return validateBool(s, false)
+}
+
 // ----- misc validation support functions
 
+// isReservedName() returns true if the given table name is reserved
+// for the internal tables of apidCRUD, which start with an underscore,
+// or of sqlite, which start with "sqlite_" in any case.
+func isReservedName(name string) bool {
+	return strings.HasPrefix(name, "_") ||
+		strings.HasPrefix(strings.ToLower(name), "sqlite_")
+}
+
+// reservedTableError() returns a 403 error if the given table name
+// is reserved, so that the internal tables are not exposed to the
+// public APIs.
+func reservedTableError(name string) error {
+	if !isReservedName(name) {
+		return nil
+	}
+	return statusError{http.StatusForbidden,
+		fmt.Errorf("table %s is reserved", name)}
+}
+
+// paramErrorStatus() returns the http status for an error from
+// fetchParams(), which is bad request unless the error has its own.
+func paramErrorStatus(err error) int {
+	if serr, ok := err.(statusError); ok {
+		return serr.code
+	}
+	return badStat
+}
+
+// validateBool() checks the given string for validity as a boolean,
+// returning "true" or "false".  the empty string means defval.
+func validateBool(s string, defval bool) (string, error) {
+	if s == "" {
+		return strconv.FormatBool(defval), nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return s, err
+	}
+	return strconv.FormatBool(b), nil
+}
+
+// orderItem is one sort key of an "order" parameter.
+type orderItem struct {
+	field string
+	desc bool
+}
+
+// parseOrder() breaks up an order string into its sort keys.
+// each field may appear only once.
+func parseOrder(order string) ([]orderItem, error) {
+	ret := []orderItem{}
+	if strings.TrimSpace(order) == "" {
+		return ret, nil
+	}
+	seen := map[string]bool{}
+	for _, item := range strings.Split(order, ",") {
+		words := strings.Fields(item)
+		if len(words) < 1 || len(words) > 2 ||
+				!isValidIdent(words[0]) {
+			return ret, fmt.Errorf("invalid order item \"%s\"", item)
+		}
+		oi := orderItem{field: words[0]}
+		if len(words) == 2 {
+			switch strings.ToUpper(words[1]) {
+			case "ASC":
+			case "DESC":
+				oi.desc = true
+			default:
+				return ret, fmt.Errorf(
+					"invalid order direction \"%s\"", words[1])
+			}
+		}
+		if seen[oi.field] {
+			return ret, fmt.Errorf("duplicate order field %s",
+				oi.field)
+		}
+		seen[oi.field] = true
+		ret = append(ret, oi)
+	}
+	return ret, nil
+}
+
+// orderToA() converts a list of sort keys to the normalized string form.
+func orderToA(items []orderItem) string {
+	strs := make([]string, len(items))
+	for i, oi := range items {
+		dir := "ASC"
+		if oi.desc {
+			dir = "DESC"
+		}
+		strs[i] = oi.field + " " + dir
+	}
+	return strings.Join(strs, ",")
+}
+
 // notIdentChar() returns true iff the given rune is not valid in an
 // SQL identifier.
 func notIdentChar(r rune) bool {
@@ -83,6 +83,52 @@ func Test_validate_id_field(t *testing.T) {
 	run_validator(cx, validate_id_field, validate_id_field_Tab)
 }
 
+// ----- unit tests for validate_index_name
+
+var validate_index_name_Tab = []validator_TC {
+	{ "", "", false },
+	{ "i1", "i1", true },
+	{ "by_name", "by_name", true },
+	{ "1", "1", false },
+	{ "a b", "a b", false },
+}
+
+func Test_validate_index_name(t *testing.T) {
+	cx := newTestContext(t, "validate_index_name_Tab")
+	run_validator(cx, validate_index_name, validate_index_name_Tab)
+}
+
+// ----- unit tests for validate_on_conflict
+
+var validate_on_conflict_Tab = []validator_TC {
+	{ "", "error", true },
+	{ "error", "error", true },
+	{ "ignore", "ignore", true },
+	{ "Replace", "replace", true },
+	{ "update", "update", true },
+	{ "merge", "merge", false },
+}
+
+func Test_validate_on_conflict(t *testing.T) {
+	cx := newTestContext(t, "validate_on_conflict_Tab")
+	run_validator(cx, validate_on_conflict, validate_on_conflict_Tab)
+}
+
+// ----- unit tests for validate_conflict_target
+
+var validate_conflict_target_Tab = []validator_TC {
+	{ "", "", true },
+	{ "f1", "f1", true },
+	{ "f1,f2", "f1,f2", true },
+	{ "f1,", "f1,", false },
+	{ "f 1", "f 1", false },
+}
+
+func Test_validate_conflict_target(t *testing.T) {
+	cx := newTestContext(t, "validate_conflict_target_Tab")
+	run_validator(cx, validate_conflict_target, validate_conflict_target_Tab)
+}
+
 // ----- unit tests for validate_fields
 
 var validate_fields_Tab = []validator_TC {
@@ -109,6 +155,10 @@ var validate_table_name_Tab = []validator_TC {
 	{ "a-2", "a-2", false },
 	{ ".", ".", false },
 	{ "xyz", "xyz", true },
+	{ "a_", "a_", true },
+	{ "_tables_", "_tables_", false },
+	{ "sqlite_master", "sqlite_master", false },
+	{ "SQLite_sequence", "SQLite_sequence", false },
 }
 
 func Test_validate_table_name(t *testing.T) {
@@ -213,6 +263,271 @@ func Test_validate_offset(t *testing.T) {
 	run_validator(cx, validate_offset, validate_offset_Tab)
 }
 
+// ----- unit tests for validate_filter()
+
+var validate_filter_Tab = []validator_TC {
+	{ "", "", true },
+	{ " ", "", true },
+	{ "a = 1", "a = 1", true },
+	{ " a = 'x' ", "a = 'x'", true },
+	{ "a = ", "", false },
+	{ "a = 'x", "", false },
+	{ "(a = 1", "", false },
+}
+
+func Test_validate_filter(t *testing.T) {
+	cx := newTestContext(t, "validate_filter_Tab")
+	run_validator(cx, validate_filter, validate_filter_Tab)
+}
+
+// ----- unit tests for validate_order()
+
+var validate_order_Tab = []validator_TC {
+	{ "", "", true },
+	{ "name", "name ASC", true },
+	{ "name asc,id desc", "name ASC,id DESC", true },
+	{ " name  Desc , id ", "name DESC,id ASC", true },
+	{ "name,", "", false },
+	{ "name up", "", false },
+	{ "name asc desc", "", false },
+	{ "na-me", "", false },
+	{ "name,name desc", "", false },
+}
+
+func Test_validate_order(t *testing.T) {
+	cx := newTestContext(t, "validate_order_Tab")
+	run_validator(cx, validate_order, validate_order_Tab)
+}
+
+// ----- unit tests for validate_include_count()
+
+var validate_include_count_Tab = []validator_TC {
+	{ "", "false", true },
+	{ "true", "true", true },
+	{ "1", "true", true },
+	{ "false", "false", true },
+	{ "yes", "", false },
+}
+
+func Test_validate_include_count(t *testing.T) {
+	cx := newTestContext(t, "validate_include_count_Tab")
+	run_validator(cx, validate_include_count, validate_include_count_Tab)
+}
+
+// ----- unit tests for validate_atomic()
+
+var validate_atomic_Tab = []validator_TC {
+	{ "", "true", true },
+	{ "true", "true", true },
+	{ "0", "false", true },
+	{ "false", "false", true },
+	{ "no", "", false },
+}
+
+func Test_validate_atomic(t *testing.T) {
+	cx := newTestContext(t, "validate_atomic_Tab")
+	run_validator(cx, validate_atomic, validate_atomic_Tab)
+}
+
+// ----- unit tests for validate_create_if_missing()
+
+var validate_create_if_missing_Tab = []validator_TC {
+	{ "", "false", true },
+	{ "true", "true", true },
+	{ "F", "false", true },
+	{ "maybe", "", false },
+}
+
+func Test_validate_create_if_missing(t *testing.T) {
+	cx := newTestContext(t, "validate_create_if_missing_Tab")
+	run_validator(cx, validate_create_if_missing,
+		validate_create_if_missing_Tab)
+}
+
+// ----- unit tests for validate_include_deleted()
+
+var validate_include_deleted_Tab = []validator_TC {
+	{ "", "false", true },
+	{ "true", "true", true },
+	{ "maybe", "", false },
+}
+
+func Test_validate_include_deleted(t *testing.T) {
+	cx := newTestContext(t, "validate_include_deleted_Tab")
+	run_validator(cx, validate_include_deleted,
+		validate_include_deleted_Tab)
+}
+
+// ----- unit tests for validate_older_than()
+
+var validate_older_than_Tab = []validator_TC {
+	{ "", "0s", true },
+	{ "90m", "1h30m0s", true },
+	{ "2d", "48h0m0s", true },
+	{ "-1h", "", false },
+	{ "xd", "", false },
+	{ "soon", "", false },
+}
+
+func Test_validate_older_than(t *testing.T) {
+	cx := newTestContext(t, "validate_older_than_Tab")
+	run_validator(cx, validate_older_than, validate_older_than_Tab)
+}
+
+// ----- unit tests for validate_table()
+
+var validate_table_Tab = []validator_TC {
+	{ "", "", true },
+	{ "bundles", "bundles", true },
+	{ "a-b", "", false },
+	{ "_audit_", "_audit_", false },
+}
+
+func Test_validate_table(t *testing.T) {
+	cx := newTestContext(t, "validate_table_Tab")
+	run_validator(cx, validate_table, validate_table_Tab)
+}
+
+// ----- unit tests for validate_start_time() and validate_end_time()
+
+var validate_start_time_Tab = []validator_TC {
+	{ "", "", true },
+	{ "2017-06-01", "2017-06-01 00:00:00.000", true },
+	{ "2017-06-01 12:30:00", "2017-06-01 12:30:00.000", true },
+	{ "2017-06-01T12:30:00.5-07:00", "2017-06-01 19:30:00.500", true },
+	{ "yesterday", "", false },
+}
+
+func Test_validate_start_time(t *testing.T) {
+	cx := newTestContext(t, "validate_start_time_Tab")
+	run_validator(cx, validate_start_time, validate_start_time_Tab)
+}
+
+func Test_validate_end_time(t *testing.T) {
+	cx := newTestContext(t, "validate_start_time_Tab")
+	run_validator(cx, validate_end_time, validate_start_time_Tab)
+}
+
+// ----- unit tests for validate_since() and validate_wait()
+
+var validate_since_Tab = []validator_TC {
+	{ "", "0", true },
+	{ "12", "12", true },
+	{ "-1", "", false },
+	{ "x", "", false },
+}
+
+func Test_validate_since(t *testing.T) {
+	cx := newTestContext(t, "validate_since_Tab")
+	run_validator(cx, validate_since, validate_since_Tab)
+}
+
+var validate_wait_Tab = []validator_TC {
+	{ "", "0s", true },
+	{ "30s", "30s", true },
+	{ "30", "30s", true },
+	{ "1h", "5m0s", true },
+	{ "-1s", "", false },
+	{ "soon", "", false },
+}
+
+func Test_validate_wait(t *testing.T) {
+	cx := newTestContext(t, "validate_wait_Tab")
+	run_validator(cx, validate_wait, validate_wait_Tab)
+}
+
+var validate_aggregate_Tab = []validator_TC {
+	{ "count(*)", "count(*)", true },
+	{ "COUNT( * ), sum(size),avg(price)", "count(*),sum(size),avg(price)", true },
+	{ "max(host)", "max(host)", true },
+	{ "", "", false },
+	{ "sum(*)", "", false },
+	{ "median(size)", "", false },
+	{ "count(size", "", false },
+	{ "count(a b)", "", false },
+	{ "count(size);drop table x", "", false },
+}
+
+func Test_validate_aggregate(t *testing.T) {
+	cx := newTestContext(t, "validate_aggregate_Tab")
+	run_validator(cx, validate_aggregate, validate_aggregate_Tab)
+}
+
+var validate_group_by_Tab = []validator_TC {
+	{ "", "", true },
+	{ "host", "host", true },
+	{ "host,flag", "host,flag", true },
+	{ "host,host", "", false },
+	{ "host;", "", false },
+	{ "host,", "", false },
+}
+
+func Test_validate_group_by(t *testing.T) {
+	cx := newTestContext(t, "validate_group_by_Tab")
+	run_validator(cx, validate_group_by, validate_group_by_Tab)
+}
+
+// ----- unit tests for validate_refresh()
+
+var validate_refresh_Tab = []validator_TC {
+	{ "", "false", true },
+	{ "true", "true", true },
+	{ "1", "true", true },
+	{ "x", "x", false },
+}
+
+func Test_validate_refresh(t *testing.T) {
+	cx := newTestContext(t, "validate_refresh_Tab")
+	run_validator(cx, validate_refresh, validate_refresh_Tab)
+}
+
+// ----- unit tests for validate_new_name()
+
+var validate_new_name_Tab = []validator_TC {
+	{ "", "", false },
+	{ "abc", "abc", true },
+	{ "a-b", "a-b", false },
+	{ "_tables_", "_tables_", false },
+}
+
+func Test_validate_new_name(t *testing.T) {
+	cx := newTestContext(t, "validate_new_name_Tab")
+	run_validator(cx, validate_new_name, validate_new_name_Tab)
+}
+
+// ----- unit tests for validate_with_data()
+
+var validate_with_data_Tab = []validator_TC {
+	{ "", "false", true },
+	{ "true", "true", true },
+	{ "x", "x", false },
+}
+
+func Test_validate_with_data(t *testing.T) {
+	cx := newTestContext(t, "validate_with_data_Tab")
+	run_validator(cx, validate_with_data, validate_with_data_Tab)
+}
+
+// ----- unit tests for isReservedName() and paramErrorStatus()
+
+func Test_isReservedName(t *testing.T) {
+	cx := newTestContext(t)
+	cx.assertTrue(isReservedName(tableOfTables), "table of tables")
+	cx.assertTrue(isReservedName("sqlite_master"), "sqlite_master")
+	cx.assertTrue(isReservedName("SQLITE_x"), "upper case sqlite_")
+	cx.assertTrue(!isReservedName("bundles"), "bundles")
+	cx.assertTrue(!isReservedName("a_"), "trailing underscore")
+}
+
+func Test_paramErrorStatus(t *testing.T) {
+	cx := newTestContext(t)
+	_, err := validate_table_name("_tables_")
+	cx.assertEqual(http.StatusForbidden, paramErrorStatus(err),
+		"reserved table")
+	_, err = validate_table_name("a-b")
+	cx.assertEqual(badStat, paramErrorStatus(err), "invalid table")
+}
+
 // ---- unit tests for notIdentChar()
 
 type notIdentChar_TC struct {
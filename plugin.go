@@ -1,7 +1,9 @@
 package apidCRUD
 
 import (
+	"sort"
 	"strconv"
+	"strings"
 	"net/http"
 	"github.com/apid/apid-core"
 )
@@ -27,9 +29,15 @@ type forModuler interface {
 // ----- functions go below this line
 
 // initPlugin() is called by the apid InitializePlugins().
-// just calls realInitPlugin() which has been designed to simplify unit testing.
+// calls realInitPlugin() which has been designed to simplify unit testing,
+// then starts the delivery of webhooks.
 func initPlugin(services apid.Services) (apid.PluginData, error) {
-	return realInitPlugin(services.Config(), services.Log(), services.API())
+	pd, err := realInitPlugin(services.Config(), services.Log(),
+		services.API())
+	if err == nil {
+		go deliverHooks(db, hookClient)
+	}
+	return pd, err
 }
 
 // realInitPlugin() drives miscellaneous plugin-specific setup activities,
@@ -56,9 +64,44 @@ func realInitPlugin(gsi getStringer,
 func registerHandlers(service handleFuncer, tab []apiDesc) {
 	ws := newApiWiring(basePath, tab)
 	maps := ws.GetMaps()
-	for path, vmap := range maps {
-		addPath(service, path, vmap)
+	for _, path := range routeOrder(maps) {
+		addPath(service, path, maps[path])
+	}
+}
+
+// routeOrder() returns the paths of the given map in the order
+// they should be registered.  the router uses the first registered
+// path that matches a request, so a path with a literal segment,
+// such as _restore, must come before one with a {variable} segment
+// in the same position, which would match it too.
+func routeOrder(maps map[string]verbMap) []string {
+	paths := make([]string, 0, len(maps))
+	for path := range maps {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return routeLess(paths[i], paths[j])
+	})
+	return paths
+}
+
+// routeLess() compares two paths segment by segment, with literal
+// segments before variable ones, and otherwise in string order.
+func routeLess(a string, b string) bool {
+	as := strings.Split(a, "/")
+	bs := strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+		av := strings.HasPrefix(as[i], "{")
+		bv := strings.HasPrefix(bs[i], "{")
+		if av != bv {
+			return bv
+		}
+		return as[i] < bs[i]
 	}
+	return len(as) < len(bs)
 }
 
 // addPath() registers the given path with the given service,
@@ -106,4 +149,6 @@ func initConfig(gsi getStringer) {
 	basePath = confGet(gsi, "apidCRUD_base_path", basePath)
 	maxRecs, _ = strconv.Atoi(			// nolint
 		confGet(gsi, "apidCRUD_max_recs", aMaxRecs))
+	hookAllowHosts = confGet(gsi, "apidCRUD_hook_allow_hosts",
+		hookAllowHosts)
 }
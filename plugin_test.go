@@ -68,6 +68,29 @@ func Test_registerHandlers(t *testing.T) {
 	}
 }
 
+// ----- unit tests for routeOrder()
+
+func Test_routeOrder(t *testing.T) {
+	cx := newTestContext(t)
+	maps := map[string]verbMap{
+		"/db/_table/{table_name}/{id}": {},
+		"/db/_table/{table_name}/_restore": {},
+		"/db/_table/{table_name}": {},
+		"/db/_table/{table_name}/{id}/_restore": {},
+		"/db/_table": {},
+		"/db/_schema/{table_name}": {},
+	}
+	xres := []string{
+		"/db/_schema/{table_name}",
+		"/db/_table",
+		"/db/_table/{table_name}",
+		"/db/_table/{table_name}/_restore",
+		"/db/_table/{table_name}/{id}",
+		"/db/_table/{table_name}/{id}/_restore",
+	}
+	cx.assertEqualObj(xres, routeOrder(maps), "routeOrder")
+}
+
 // ----- unit tests for initPlugin()
 
 type mockForModuler struct {
@@ -96,6 +119,7 @@ var utConfData = map[string]string {
 	"apidCRUD_max_recs": "7",
 	"apidCRUD_db_driver": "sqlite3",
 	"apidCRUD_db_name": "unit-test.db",
+	"apidCRUD_hook_allow_hosts": "127.0.0.1",
 }
 
 // ----- unit tests for confGet()
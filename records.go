@@ -1,14 +1,23 @@
 package apidCRUD
 
+import (
+	"encoding/json"
+)
+
 // ----- types for parameter record and response structures
 
 // field tags are used to change the case of JSON keys
 // created by json.Unmarshal().
 
 // NumChangedResponse is the response data for API deleteDbRecord and others.
+// Records is present only if the fields parameter is given.
+// Counts is present only for updateDbRecords with per-record bodies,
+// and has the number of records changed by each.
 type NumChangedResponse struct {
 	NumChanged int64 `json:"numChanged"`
+	Counts []int64	`json:"counts,omitempty"`
 	Kind string	`json:"kind"`
+	Records []*KVResponse	`json:"records,omitempty"`
 }
 
 // ErrorResponse is the response data for API errors.
@@ -25,8 +34,11 @@ type KVRecord struct {
 }
 
 // BodyRecord is the body data for APIs that create or update database records.
+// Filter optionally selects the records to update, as an alternative
+// to the filter parameter.
 type BodyRecord struct {
 	Records []KVRecord
+	Filter string
 }
 
 // KVResponse represents data records returned from an API call.
@@ -35,18 +47,44 @@ type KVResponse struct {
 	Values []interface{} `json:"values"`
 	Kind string	`json:"kind"`
 	Self string	`json:"self"`
+	Etag string	`json:"etag,omitempty"`
+	id int64	// the record id, not included in the response
 }
 
 // RecordsResponse is the type for multiple get*Record* APIs.
+// Limit and Offset are the effective values used for the query.
+// Total is present only if include_count was requested.
+// Next and Prev are links to the adjacent pages, if any.
+// NextCursor is a cursor for the next page, if any.
 type RecordsResponse struct {
 	Records []*KVResponse `json:"records"`
 	Kind string	`json:"kind"`
+	Limit int64	`json:"limit"`
+	Offset int64	`json:"offset"`
+	Total *int64	`json:"total,omitempty"`
+	Next string	`json:"next,omitempty"`
+	Prev string	`json:"prev,omitempty"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// RecordOutcome tells what happened to one record in createDbRecords.
+// Outcome is one of inserted, updated, skipped, or failed.
+// Error is present only for a failed record.
+type RecordOutcome struct {
+	Id int64	`json:"id"`
+	Outcome string	`json:"outcome"`
+	Error string	`json:"error,omitempty"`
 }
 
 // IdsResponse is the type returned by createDbRecords .
+// Results is present only if on_conflict is not "error",
+// or if atomic is false.
+// Records is present only if the fields parameter is given.
 type IdsResponse struct {
 	Ids []int64	`json:"ids"`
 	Kind string	`json:"kind"`
+	Results []RecordOutcome `json:"results,omitempty"`
+	Records []*KVResponse	`json:"records,omitempty"`
 }
 
 // TablesResponse is the type returned by getDbTables.
@@ -57,19 +95,100 @@ type TablesResponse struct {
 }
 
 // FieldSchema is the type used to specify a field in a table.
+// the optional properties are pointers, so that the schema
+// stored in the table of tables is the same as the one given.
+// Properties is the legacy list of properties; "is_primary_key"
+// there means an auto-incremented integer primary key.
+// References makes the field a foreign key.
 type FieldSchema struct {
-	Name string
-	Properties []string
+	Name string	`json:"name"`
+	Properties []string `json:"properties,omitempty"`
+	DbType string	`json:"db_type,omitempty"`
+	Length *int64	`json:"length,omitempty"`
+	AllowNull *bool	`json:"allow_null,omitempty"`
+	AutoIncrement *bool `json:"auto_increment,omitempty"`
+	IsPrimaryKey *bool `json:"is_primary_key,omitempty"`
+	Default interface{} `json:"default,omitempty"`
+	Unique *bool	`json:"unique,omitempty"`
+	References *FieldReference `json:"references,omitempty"`
+}
+
+// FieldReference is the type used to specify that a field refers
+// to a field (usually the primary key) of another table.
+// OnDelete tells what happens to the referring records when the
+// referenced record is deleted: cascade, set_null, set_default,
+// restrict, or no_action (the default).
+type FieldReference struct {
+	Table string	`json:"table"`
+	Field string	`json:"field"`
+	OnDelete string	`json:"on_delete,omitempty"`
+}
+
+// IndexSchema is the type used to specify an index on a table.
+type IndexSchema struct {
+	Name string	`json:"name"`
+	Fields []string	`json:"fields"`
+	Unique bool	`json:"unique,omitempty"`
 }
 
 // TableSchema is the type used to describe one table to be created.
 type TableSchema struct {
-	Fields []FieldSchema
+	Fields []FieldSchema `json:"fields"`
+	Indexes []IndexSchema `json:"indexes,omitempty"`
+	SoftDelete bool `json:"soft_delete,omitempty"`
+	Audit bool `json:"audit,omitempty"`
 }
 
-// SchemaResponse is the response format for table creation.
+// RenameField is the type used to rename a field in alterDbTable.
+type RenameField struct {
+	From string	`json:"from"`
+	To string	`json:"to"`
+}
+
+// AlterTableRequest is the body data for alterDbTable.
+// the drops are applied first, then the renames, then the adds.
+type AlterTableRequest struct {
+	Add []FieldSchema `json:"add"`
+	Drop []string	`json:"drop"`
+	Rename []RenameField `json:"rename"`
+}
+
+// SchemaResponse is the response format for describeDbTable, and for
+// the APIs that change a table's schema.  Schema is the schema stored
+// in the table of tables, or null if it is not usable.  the rest comes
+// from sqlite: the table's actual columns and indexes, and its number
+// of rows, including any soft-deleted records.
 type SchemaResponse struct {
-	Schema string	`json:"schema"`
+	Schema *TableSchema `json:"schema"`
+	Columns []ColumnDescription `json:"columns"`
+	Indexes []IndexDescription `json:"indexes"`
+	RowCount int64	`json:"rowCount"`
+	Kind string	`json:"kind"`
+	Self string	`json:"self"`
+}
+
+// ColumnDescription describes a column of a table, as sqlite has it.
+// Default is the SQL expression for the column's default value, if any.
+type ColumnDescription struct {
+	Name string	`json:"name"`
+	Type string	`json:"type"`
+	AllowNull bool	`json:"allow_null"`
+	IsPrimaryKey bool `json:"is_primary_key"`
+	Default string	`json:"default,omitempty"`
+}
+
+// IndexDescription describes an index of a table, as sqlite has it.
+// this includes the indexes that sqlite makes for unique constraints,
+// and the indexes of a schema, under their names in the database.
+type IndexDescription struct {
+	Name string	`json:"name"`
+	Fields []string	`json:"fields"`
+	Unique bool	`json:"unique"`
+}
+
+// IndexesResponse is the response format for the listDbIndexes API.
+type IndexesResponse struct {
+	Indexes []IndexSchema `json:"indexes"`
 	Kind string	`json:"kind"`
 	Self string	`json:"self"`
 }
@@ -80,3 +199,140 @@ type ServiceResponse struct {
 	Kind string	`json:"kind"`
 	Self string	`json:"self"`
 }
+
+// AuditEntry is one change to a record, from the audit log.
+// Before and After are the values of the record's fields before and
+// after the change, absent if the record did not exist.
+// Caller is the remote address of the request that made the change,
+// and ClaimedCaller the unverified X-Apid-Caller header, if any.
+type AuditEntry struct {
+	Id int64 `json:"id"`
+	Time string `json:"time"`
+	Op string `json:"op"`
+	Table string `json:"table"`
+	RecordId int64 `json:"recordId"`
+	Before json.RawMessage `json:"before,omitempty"`
+	After json.RawMessage `json:"after,omitempty"`
+	Caller string `json:"caller"`
+	ClaimedCaller string `json:"claimedCaller,omitempty"`
+}
+
+// AuditResponse is the response data for the audit log APIs.
+type AuditResponse struct {
+	Entries []AuditEntry `json:"entries"`
+	Kind string `json:"kind"`
+}
+
+// RegistryEntry is one entry of the table of tables.
+// Schema is the table's schema, as it was stored.
+type RegistryEntry struct {
+	Id int64 `json:"id"`
+	Name string `json:"name"`
+	Schema string `json:"schema"`
+}
+
+// RegistryResponse is the response data for the getDbAdminRegistry API.
+type RegistryResponse struct {
+	Entries []RegistryEntry `json:"entries"`
+	Kind string `json:"kind"`
+}
+
+// SqliteObject is one object in the sqlite schema, such as a table
+// or an index.  Table is the name of the table that it belongs to.
+type SqliteObject struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	Table string `json:"table"`
+	Sql string `json:"sql"`
+}
+
+// ObjectsResponse is the response data for the getDbAdminObjects API.
+type ObjectsResponse struct {
+	Objects []SqliteObject `json:"objects"`
+	Kind string `json:"kind"`
+}
+
+// ChangeEntry is one change to a record, from the change feed.
+type ChangeEntry struct {
+	Seq int64 `json:"seq"`
+	Time string `json:"time"`
+	Op string `json:"op"`
+	Table string `json:"table"`
+	RecordId int64 `json:"recordId"`
+}
+
+// ChangesResponse is the response data for the getDbChanges API.
+// LastSeq is the sequence number to use as since in the next request.
+type ChangesResponse struct {
+	Changes []ChangeEntry `json:"changes"`
+	LastSeq int64 `json:"lastSeq"`
+	Kind string `json:"kind"`
+}
+
+// HookRequest is the body of the createDbHook API.
+// Events are the operations to deliver; if empty, all of them.
+type HookRequest struct {
+	Table string `json:"table"`
+	Url string `json:"url"`
+	Events []string `json:"events,omitempty"`
+	Secret string `json:"secret"`
+}
+
+// Hook is a webhook, as returned by the webhook APIs.
+// the secret is never returned.  Status is present only
+// for getDbHook.
+type Hook struct {
+	Id int64 `json:"id"`
+	Table string `json:"table"`
+	Url string `json:"url"`
+	Events []string `json:"events"`
+	Status *HookStatus `json:"status,omitempty"`
+	Kind string `json:"kind"`
+}
+
+// HookStatus is the state of the deliveries of a webhook.
+// Failed are the payloads that were given up on.
+type HookStatus struct {
+	Delivered int64 `json:"delivered"`
+	Pending int64 `json:"pending"`
+	Failed int64 `json:"failed"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+// HooksResponse is the response data for the getDbHooks API.
+type HooksResponse struct {
+	Hooks []Hook `json:"hooks"`
+	Kind string `json:"kind"`
+}
+
+// HookPayload is the body of a webhook delivery.
+type HookPayload struct {
+	HookId int64 `json:"hookId"`
+	Seq int64 `json:"seq"`
+	Time string `json:"time"`
+	Op string `json:"op"`
+	Table string `json:"table"`
+	RecordId int64 `json:"recordId"`
+	Before json.RawMessage `json:"before,omitempty"`
+	After json.RawMessage `json:"after,omitempty"`
+}
+
+// AggregateColumn describes a column of the result of the
+// aggregateDbRecords API.  Type is the type of its values:
+// integer, real, text, blob, boolean, numeric, or datetime.
+type AggregateColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// AggregateResponse is the response data for the aggregateDbRecords API.
+// each row has a value for each of the columns, in order.
+// Next is the link to the next page of groups, if there are more.
+type AggregateResponse struct {
+	Columns []AggregateColumn `json:"columns"`
+	Rows [][]interface{} `json:"rows"`
+	Kind string `json:"kind"`
+	Limit int64 `json:"limit"`
+	Offset int64 `json:"offset"`
+	Next string `json:"next,omitempty"`
+}
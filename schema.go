@@ -0,0 +1,510 @@
+package apidCRUD
+
+// this module translates the TableSchema given to createDbTable
+// into the SQL column definitions for the table, and the changes
+// given to alterDbTable into the SQL commands that make them.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// dbTypes maps the db_type values allowed in a FieldSchema
+// to the SQL types used for the column.
+var dbTypes = map[string]string {
+	"integer": "integer",
+	"real": "real",
+	"text": "text",
+	"blob": "blob",
+	"boolean": "boolean",
+	"datetime": "datetime",
+}
+
+// onDeleteActions maps the on_delete values allowed in a
+// FieldReference to the SQL foreign key actions.
+var onDeleteActions = map[string]string {
+	"cascade": "cascade",
+	"set_null": "set null",
+	"set_default": "set default",
+	"restrict": "restrict",
+	"no_action": "no action",
+}
+
+// listToMap() turns a list of property strings into a property map.
+func listToMap(strList []string) map[string]int {
+	ret := map[string]int{}
+	if strList == nil {
+		return ret
+	}
+	for _, s := range strList {
+		ret[s] = 1
+	}
+	return ret
+}
+
+// boolOpt() returns the value of an optional boolean field.
+func boolOpt(b *bool) bool {
+	return b != nil && *b
+}
+
+// isPrimaryKey() returns true iff the field is (part of) the primary key.
+// the legacy "is_primary_key" property is also honored.
+func isPrimaryKey(field FieldSchema) bool {
+	return boolOpt(field.IsPrimaryKey) ||
+		listToMap(field.Properties)["is_primary_key"] != 0
+}
+
+// isAutoIncrement() returns true iff the field's value is assigned
+// automatically.  the legacy "is_primary_key" property implies it.
+func isAutoIncrement(field FieldSchema) bool {
+	return boolOpt(field.AutoIncrement) ||
+		listToMap(field.Properties)["is_primary_key"] != 0
+}
+
+// fieldType() returns the SQL type of the given field.
+// the default is integer for a primary key, and text otherwise.
+func fieldType(field FieldSchema) (string, error) {
+	if field.DbType == "" {
+		if isPrimaryKey(field) {
+			return "integer", nil
+		}
+		return "text", nil
+	}
+	sqlType, ok := dbTypes[strings.ToLower(field.DbType)]
+	if !ok {
+		return "", fmt.Errorf("field %s has invalid db_type %s",
+			field.Name, field.DbType)
+	}
+	return sqlType, nil
+}
+
+// sqlLiteral() returns the SQL literal for the given default value,
+// as decoded from JSON.
+func sqlLiteral(val interface{}) (string, error) {
+	switch v := val.(type) {
+	case nil:
+		return "NULL", nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case string:
+		return "'" + strings.Replace(v, "'", "''", -1) + "'", nil
+	}
+	return "", fmt.Errorf("invalid default value %v", val)
+}
+
+// mkFieldClause() returns the SQL column definition for one field.
+// pkInline tells whether the primary key is to be declared here,
+// rather than as a table constraint.
+func mkFieldClause(field FieldSchema, pkInline bool) (string, error) {
+	if !isValidIdent(field.Name) {
+		return "", fmt.Errorf("invalid field name %s", field.Name)
+	}
+	sqlType, err := fieldType(field)
+	if err != nil {
+		return "", err
+	}
+	pk := isPrimaryKey(field)
+	autoinc := isAutoIncrement(field)
+	if autoinc && (!pk || !pkInline || sqlType != "integer") {
+		return "", fmt.Errorf("field %s: auto_increment requires a single integer primary key",
+			field.Name)
+	}
+
+	var guts bytes.Buffer
+	guts.WriteString(quoteIdent(field.Name) + " " + sqlType)
+	if pk && pkInline {
+		guts.WriteString(" primary key")
+		if autoinc {
+			guts.WriteString(" autoincrement")
+		}
+	} else if !pk && !boolOpt(field.AllowNull) {
+		guts.WriteString(" not null")
+	}
+	if field.Default != nil {
+		lit, err := sqlLiteral(field.Default)
+		if err != nil {
+			return "", fmt.Errorf("field %s: %s", field.Name, err)
+		}
+		guts.WriteString(" default " + lit)
+	}
+	if boolOpt(field.Unique) {
+		guts.WriteString(" unique")
+	}
+	if field.Length != nil {
+		if *field.Length <= 0 {
+			return "", fmt.Errorf("field %s: length must be positive",
+				field.Name)
+		}
+		guts.WriteString(fmt.Sprintf(" check(length(%s) <= %d)",
+			quoteIdent(field.Name), *field.Length))
+	}
+	if field.References != nil {
+		ref, err := mkReferencesClause(*field.References)
+		if err != nil {
+			return "", fmt.Errorf("field %s: %s", field.Name, err)
+		}
+		guts.WriteString(ref)
+	}
+	return guts.String(), nil
+}
+
+// mkReferencesClause() returns the SQL foreign key clause
+// for the given reference.
+func mkReferencesClause(ref FieldReference) (string, error) {
+	if !isValidIdent(ref.Table) {
+		return "", fmt.Errorf("invalid references table %s", ref.Table)
+	}
+	if isReservedName(ref.Table) {
+		return "", fmt.Errorf("references table %s is reserved", ref.Table)
+	}
+	if !isValidIdent(ref.Field) {
+		return "", fmt.Errorf("invalid references field %s", ref.Field)
+	}
+	ret := fmt.Sprintf(" references %s(%s)",
+		quoteIdent(ref.Table), quoteIdent(ref.Field))
+	if ref.OnDelete != "" {
+		action, ok := onDeleteActions[strings.ToLower(ref.OnDelete)]
+		if !ok {
+			return "", fmt.Errorf("invalid on_delete %s", ref.OnDelete)
+		}
+		ret += " on delete " + action
+	}
+	return ret, nil
+}
+
+// mkSchemaClause() constructs the SQL schema string
+// for the given list of fields.
+// a primary key of more than one field becomes a table constraint.
+func mkSchemaClause(sch TableSchema) (string, error) {
+	if len(sch.Fields) == 0 {
+		return "", fmt.Errorf("schema has no fields")
+	}
+	pkeys := []string{}
+	for _, field := range sch.Fields {
+		if isPrimaryKey(field) {
+			pkeys = append(pkeys, field.Name)
+		}
+	}
+	pkInline := len(pkeys) == 1
+
+	clauses := make([]string, 0, len(sch.Fields) + 1)
+	for _, field := range sch.Fields {
+		clause, err := mkFieldClause(field, pkInline)
+		if err != nil {
+			return "", err
+		}
+		clauses = append(clauses, clause)
+	}
+	if len(pkeys) > 1 {
+		clauses = append(clauses,
+			"primary key(" + quoteIdents(pkeys) + ")")
+	}
+	return strings.Join(clauses, ", "), nil
+}
+
+// fieldIndex() returns the index of the named field in the schema,
+// or -1 if there is no such field.
+func fieldIndex(sch TableSchema, name string) int {
+	for i, field := range sch.Fields {
+		if field.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// alterSchema() returns the schema resulting from applying
+// the given changes to sch.  the drops are applied first,
+// then the renames, then the adds.  also returns a map from the name
+// of each field of the new schema that was in the old one,
+// to its old name.
+func alterSchema(sch TableSchema,
+	req AlterTableRequest) (TableSchema, map[string]string, error) {
+	// fields, and the old names of the fields that are kept.
+	fields := append([]FieldSchema{}, sch.Fields...)
+	oldNames := make([]string, len(fields))
+	for i, field := range fields {
+		oldNames[i] = field.Name
+	}
+	nsch := TableSchema{}
+
+	indexes := make([]IndexSchema, len(sch.Indexes))
+	for i, idx := range sch.Indexes {
+		indexes[i] = idx
+		indexes[i].Fields = append([]string{}, idx.Fields...)
+	}
+
+	for _, name := range req.Drop {
+		if sch.SoftDelete && name == softDeleteField {
+			return nsch, nil, fmt.Errorf(
+				"field %s is used by soft_delete", name)
+		}
+		i := fieldIndex(TableSchema{Fields: fields}, name)
+		if i < 0 {
+			return nsch, nil, fmt.Errorf("no such field %s", name)
+		}
+		for _, idx := range indexes {
+			if listToMap(idx.Fields)[name] != 0 {
+				return nsch, nil, fmt.Errorf(
+					"field %s is used by index %s",
+					name, idx.Name)
+			}
+		}
+		fields = append(fields[:i], fields[i+1:]...)
+		oldNames = append(oldNames[:i], oldNames[i+1:]...)
+	}
+
+	for _, rn := range req.Rename {
+		if sch.SoftDelete && rn.From == softDeleteField {
+			return nsch, nil, fmt.Errorf(
+				"field %s is used by soft_delete", rn.From)
+		}
+		i := fieldIndex(TableSchema{Fields: fields}, rn.From)
+		if i < 0 {
+			return nsch, nil, fmt.Errorf("no such field %s", rn.From)
+		}
+		if !isValidIdent(rn.To) {
+			return nsch, nil, fmt.Errorf("invalid field name %s", rn.To)
+		}
+		if fieldIndex(TableSchema{Fields: fields}, rn.To) >= 0 {
+			return nsch, nil, fmt.Errorf("field %s already exists", rn.To)
+		}
+		fields[i].Name = rn.To
+		for _, idx := range indexes {
+			for j, name := range idx.Fields {
+				if name == rn.From {
+					idx.Fields[j] = rn.To
+				}
+			}
+		}
+	}
+
+	for _, field := range req.Add {
+		if fieldIndex(TableSchema{Fields: fields}, field.Name) >= 0 {
+			return nsch, nil,
+				fmt.Errorf("field %s already exists", field.Name)
+		}
+		fields = append(fields, field)
+	}
+
+	nsch = sch
+	nsch.Fields = fields
+	if len(indexes) > 0 {
+		nsch.Indexes = indexes
+	}
+	fromMap := map[string]string{}
+	for i, name := range oldNames {
+		fromMap[fields[i].Name] = name
+	}
+	return nsch, fromMap, nil
+}
+
+// canAlterAdd() returns true iff the given field can be added
+// to a table by sqlite's ALTER TABLE ADD COLUMN.
+// sqlite does not allow a primary key, a unique column,
+// or a not null column without a default.
+func canAlterAdd(field FieldSchema) bool {
+	if isPrimaryKey(field) || isAutoIncrement(field) ||
+		boolOpt(field.Unique) {
+		return false
+	}
+	return boolOpt(field.AllowNull) || field.Default != nil
+}
+
+// mkAlterCmds() returns the SQL commands that change the table tabName
+// to the schema nsch, as returned by alterSchema() for req.
+// columns are added with ALTER TABLE where possible.
+// otherwise, the table is copied to a new table with the new schema,
+// which then replaces the old table, and the indexes are recreated.
+// columns are always renamed by copying, since ALTER TABLE RENAME
+// COLUMN needs sqlite 3.25 or newer.
+func mkAlterCmds(tabName string,
+	nsch TableSchema,
+	fromMap map[string]string,
+	req AlterTableRequest) ([]*xCmd, error) {
+	nclause, err := mkSchemaClause(nsch)
+	if err != nil {
+		return nil, err
+	}
+
+	useAlter := len(req.Drop) == 0 && len(req.Rename) == 0
+	for _, field := range req.Add {
+		useAlter = useAlter && canAlterAdd(field)
+	}
+
+	cmds := []*xCmd{}
+	if useAlter {
+		for _, field := range req.Add {
+			clause, err := mkFieldClause(field, true)
+			if err != nil {
+				return nil, err
+			}
+			cmds = append(cmds, newSQL("alter table ").ident(tabName).
+				sql(" add column " + clause).xcmd())
+		}
+		return cmds, nil
+	}
+
+	// the copy-table strategy.
+	tmpName := "_alter_" + tabName
+	newCols := []string{}
+	oldCols := []string{}
+	for _, field := range nsch.Fields {
+		if from, ok := fromMap[field.Name]; ok {
+			newCols = append(newCols, field.Name)
+			oldCols = append(oldCols, from)
+		}
+	}
+	cmds = append(cmds, newSQL("create table ").ident(tmpName).
+		sql("(" + nclause + ")").xcmd())
+	if len(newCols) > 0 {
+		cmds = append(cmds, newSQL("insert into ").ident(tmpName).
+			sql(" (").idents(newCols).
+			sql(") select ").idents(oldCols).
+			sql(" from ").ident(tabName).xcmd())
+	}
+	cmds = append(cmds,
+		newSQL("drop table ").ident(tabName).xcmd(),
+		newSQL("alter table ").ident(tmpName).
+			sql(" rename to ").ident(tabName).xcmd())
+	icmds, err := mkIndexCmds(tabName, nsch)
+	if err != nil {
+		return nil, err
+	}
+	return append(cmds, icmds...), nil
+}
+
+// sqlIndexName() returns the name of the SQL index for the
+// given index of the given table.  index names are per-table
+// in the API, but sqlite's are per-database.
+func sqlIndexName(tabName string, indexName string) string {
+	return tabName + "__" + indexName
+}
+
+// indexIndex() returns the index of the named index in the schema,
+// or -1 if there is no such index.
+func indexIndex(sch TableSchema, name string) int {
+	for i, idx := range sch.Indexes {
+		if idx.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// validateIndex() checks that the given index can be added
+// to a table with the given schema.
+func validateIndex(sch TableSchema, idx IndexSchema) error {
+	if !isValidIdent(idx.Name) {
+		return fmt.Errorf("invalid index name %s", idx.Name)
+	}
+	if indexIndex(sch, idx.Name) >= 0 {
+		return fmt.Errorf("index %s already exists", idx.Name)
+	}
+	if len(idx.Fields) == 0 {
+		return fmt.Errorf("index %s has no fields", idx.Name)
+	}
+	for _, name := range idx.Fields {
+		if fieldIndex(sch, name) < 0 {
+			return fmt.Errorf("index %s: no such field %s",
+				idx.Name, name)
+		}
+	}
+	return nil
+}
+
+// mkIndexCmd() returns the SQL command that creates the given index.
+func mkIndexCmd(tabName string, idx IndexSchema) *xCmd {
+	unique := ""
+	if idx.Unique {
+		unique = "unique "
+	}
+	return newSQL("create " + unique + "index ").
+		ident(sqlIndexName(tabName, idx.Name)).
+		sql(" on ").ident(tabName).
+		sql("(").idents(idx.Fields).sql(")").xcmd()
+}
+
+// validateIndexes() checks each index of the given schema,
+// in the light of the fields and the indexes before it.
+func validateIndexes(sch TableSchema) error {
+	checked := sch
+	checked.Indexes = nil
+	for _, idx := range sch.Indexes {
+		err := validateIndex(checked, idx)
+		if err != nil {
+			return err
+		}
+		checked.Indexes = append(checked.Indexes, idx)
+	}
+	return nil
+}
+
+// mkIndexCmds() returns the SQL commands that create all the
+// indexes of the given schema, after checking them.
+func mkIndexCmds(tabName string, sch TableSchema) ([]*xCmd, error) {
+	err := validateIndexes(sch)
+	if err != nil {
+		return nil, err
+	}
+	cmds := []*xCmd{}
+	for _, idx := range sch.Indexes {
+		cmds = append(cmds, mkIndexCmd(tabName, idx))
+	}
+	return cmds, nil
+}
+
+// validateSchema() checks that the given schema may be stored in the
+// table of tables: it has fields, with distinct valid names and valid
+// properties, and its indexes are valid.
+func validateSchema(sch TableSchema) error {
+	_, err := mkSchemaClause(sch)
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for _, field := range sch.Fields {
+		if seen[field.Name] {
+			return fmt.Errorf("duplicate field %s", field.Name)
+		}
+		seen[field.Name] = true
+	}
+	return validateIndexes(sch)
+}
+
+// schemaText() returns the given schema as the json to be stored in
+// the table of tables, after checking it.
+func schemaText(sch TableSchema) (string, error) {
+	err := validateSchema(sch)
+	if err != nil {
+		return "", err
+	}
+	jschema, err := json.Marshal(sch)
+	return string(jschema), err
+}
+
+// parseSchema() returns the schema of the given table, given the json
+// stored in the table of tables.  a schema that has unknown keys, or
+// that is not valid, is not usable.
+func parseSchema(tabName string, jschema string) (TableSchema, error) {
+	sch := TableSchema{}
+	dec := json.NewDecoder(strings.NewReader(jschema))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&sch)
+	if err == nil {
+		err = validateSchema(sch)
+	}
+	if err != nil {
+		return sch, fmt.Errorf("table %s has no usable schema: %s",
+			tabName, err)
+	}
+	return sch, nil
+}
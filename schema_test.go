@@ -0,0 +1,245 @@
+package apidCRUD
+
+import (
+	"testing"
+	"strings"
+	"encoding/json"
+)
+
+// ----- unit tests for mkSchemaClause()
+
+// inputs and outputs for one mkSchemaClause testcase.
+type mkSchemaClause_TC struct {
+	schema string
+	xclause string
+	xsucc bool
+}
+
+// table of mkSchemaClause testcases.
+var mkSchemaClause_Tab = []mkSchemaClause_TC {
+	{`{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"}]}`,
+		"`id` integer primary key autoincrement, `name` text not null",
+		true},
+	{`{"fields":[{"name":"id","db_type":"integer","is_primary_key":true,"auto_increment":true},{"name":"r","db_type":"REAL","allow_null":true}]}`,
+		"`id` integer primary key autoincrement, `r` real",
+		true},
+	{`{"fields":[{"name":"k","db_type":"text","is_primary_key":true},{"name":"b","db_type":"blob","allow_null":true},{"name":"f","db_type":"boolean","default":false},{"name":"d","db_type":"datetime","allow_null":true,"default":null}]}`,
+		"`k` text primary key, `b` blob, `f` boolean not null default 0, `d` datetime",
+		true},
+	{`{"fields":[{"name":"a","db_type":"integer","is_primary_key":true},{"name":"b","db_type":"integer","is_primary_key":true}]}`,
+		"`a` integer, `b` integer, primary key(`a`,`b`)",
+		true},
+	{`{"fields":[{"name":"s","length":10,"default":"it's"},{"name":"n","db_type":"integer","default":-2.5}]}`,
+		"`s` text not null default 'it''s' check(length(`s`) <= 10), `n` integer not null default -2.5",
+		true},
+	{`{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"email","unique":true},{"name":"pid","db_type":"integer","allow_null":true,"references":{"table":"P","field":"id","on_delete":"set_null"}},{"name":"qid","db_type":"integer","references":{"table":"Q","field":"id"}}]}`,
+		"`id` integer primary key autoincrement, `email` text not null unique, `pid` integer references `P`(`id`) on delete set null, `qid` integer not null references `Q`(`id`)",
+		true},
+	{`{"fields":[{"name":"pid","references":{"table":"P","field":"id","on_delete":"explode"}}]}`, "", false},
+	{`{"fields":[{"name":"pid","references":{"table":"P;","field":"id"}}]}`, "", false},
+	{`{"fields":[{"name":"pid","references":{"table":"P","field":""}}]}`, "", false},
+	{`{"fields":[]}`, "", false},
+	{`{"fields":[{"name":"bad name"}]}`, "", false},
+	{`{"fields":[{"name":"a","db_type":"varchar"}]}`, "", false},
+	{`{"fields":[{"name":"a","length":0}]}`, "", false},
+	{`{"fields":[{"name":"a","default":[1]}]}`, "", false},
+	{`{"fields":[{"name":"a","auto_increment":true}]}`, "", false},
+	{`{"fields":[{"name":"a","db_type":"text","is_primary_key":true,"auto_increment":true}]}`, "", false},
+	{`{"fields":[{"name":"a","is_primary_key":true,"auto_increment":true},{"name":"b","is_primary_key":true}]}`, "", false},
+}
+
+// run one testcase for function mkSchemaClause.
+func mkSchemaClause_Checker(cx *testContext, tc *mkSchemaClause_TC) {
+	sch := TableSchema{}
+	err := json.Unmarshal([]byte(tc.schema), &sch)
+	if !cx.assertErrorNil(err, "json.Unmarshal") {
+		return
+	}
+	clause, err := mkSchemaClause(sch)
+	if !cx.assertEqual(tc.xsucc, err == nil, "success") || err != nil {
+		return
+	}
+	cx.assertEqual(tc.xclause, clause, "schema clause")
+}
+
+// the mkSchemaClause test suite.  run all mkSchemaClause testcases.
+func Test_mkSchemaClause(t *testing.T) {
+	cx := newTestContext(t, "mkSchemaClause_Tab")
+	for _, tc := range mkSchemaClause_Tab {
+		mkSchemaClause_Checker(cx, &tc)
+		cx.bump()	// increment testno.
+	}
+}
+
+// ----- unit tests for alterSchema() and mkAlterCmds()
+
+// inputs and outputs for one alterSchema testcase.
+type alterSchema_TC struct {
+	schema string
+	req string
+	xschema string
+	xcmds string
+	xsucc bool
+}
+
+// the schema to be altered in the alterSchema testcases.
+var alter_schema = `{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"},{"name":"uri"}]}`
+
+// the same, with an index.
+var alter_schema_idx = `{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"},{"name":"uri"}],"indexes":[{"name":"by_uri","fields":["uri","id"],"unique":true}]}`
+
+// table of alterSchema testcases.
+var alterSchema_Tab = []alterSchema_TC {
+	{alter_schema, `{"add":[{"name":"n","db_type":"integer","allow_null":true}]}`,
+		`{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"},{"name":"uri"},{"name":"n","db_type":"integer","allow_null":true}]}`,
+		"alter table `T` add column `n` integer",
+		true},
+	{alter_schema, `{"rename":[{"from":"uri","to":"url"}],"add":[{"name":"n","default":"x"}]}`,
+		`{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"},{"name":"url"},{"name":"n","default":"x"}]}`,
+		"create table `_alter_T`(`id` integer primary key autoincrement, `name` text not null, `url` text not null, `n` text not null default 'x');insert into `_alter_T` (`id`,`name`,`url`) select `id`,`name`,`uri` from `T`;drop table `T`;alter table `_alter_T` rename to `T`",
+		true},
+	{alter_schema, `{"drop":["name"],"rename":[{"from":"uri","to":"name"}]}`,
+		`{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"}]}`,
+		"create table `_alter_T`(`id` integer primary key autoincrement, `name` text not null);insert into `_alter_T` (`id`,`name`) select `id`,`uri` from `T`;drop table `T`;alter table `_alter_T` rename to `T`",
+		true},
+	{alter_schema, `{"add":[{"name":"n"}]}`,
+		`{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"},{"name":"uri"},{"name":"n"}]}`,
+		"create table `_alter_T`(`id` integer primary key autoincrement, `name` text not null, `uri` text not null, `n` text not null);insert into `_alter_T` (`id`,`name`,`uri`) select `id`,`name`,`uri` from `T`;drop table `T`;alter table `_alter_T` rename to `T`",
+		true},
+	{alter_schema, `{"drop":["bogus"]}`, "", "", false},
+	{alter_schema, `{"drop":["id","name","uri"]}`, "", "", false},
+	{alter_schema, `{"rename":[{"from":"bogus","to":"x"}]}`, "", "", false},
+	{alter_schema, `{"rename":[{"from":"uri","to":"name"}]}`, "", "", false},
+	{alter_schema, `{"rename":[{"from":"uri","to":"bad name"}]}`, "", "", false},
+	{alter_schema, `{"add":[{"name":"uri","allow_null":true}]}`, "", "", false},
+	{alter_schema, `{"add":[{"name":"x","db_type":"bogus","allow_null":true}]}`, "", "", false},
+	{alter_schema_idx, `{"drop":["uri"]}`, "", "", false},
+	{alter_schema_idx, `{"rename":[{"from":"uri","to":"url"}],"drop":["name"]}`,
+		`{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"url"}],"indexes":[{"name":"by_uri","fields":["url","id"],"unique":true}]}`,
+		"create table `_alter_T`(`id` integer primary key autoincrement, `url` text not null);insert into `_alter_T` (`id`,`url`) select `id`,`uri` from `T`;drop table `T`;alter table `_alter_T` rename to `T`;create unique index `T__by_uri` on `T`(`url`,`id`)",
+		true},
+}
+
+// run one testcase for functions alterSchema and mkAlterCmds.
+func alterSchema_Checker(cx *testContext, tc *alterSchema_TC) {
+	sch := TableSchema{}
+	err := json.Unmarshal([]byte(tc.schema), &sch)
+	if !cx.assertErrorNil(err, "json.Unmarshal schema") {
+		return
+	}
+	req := AlterTableRequest{}
+	err = json.Unmarshal([]byte(tc.req), &req)
+	if !cx.assertErrorNil(err, "json.Unmarshal req") {
+		return
+	}
+	nsch, fromMap, err := alterSchema(sch, req)
+	var cmds []*xCmd
+	if err == nil {
+		cmds, err = mkAlterCmds("T", nsch, fromMap, req)
+	}
+	if !cx.assertEqual(tc.xsucc, err == nil, "success") || err != nil {
+		return
+	}
+	jschema, _ := json.Marshal(nsch)
+	cx.assertEqual(tc.xschema, string(jschema), "new schema")
+	strs := make([]string, len(cmds))
+	for i, xc := range cmds {
+		strs[i] = xc.cmd
+	}
+	cx.assertEqual(tc.xcmds, strings.Join(strs, ";"), "commands")
+}
+
+// the alterSchema test suite.  run all alterSchema testcases.
+func Test_alterSchema(t *testing.T) {
+	cx := newTestContext(t, "alterSchema_Tab")
+	for _, tc := range alterSchema_Tab {
+		alterSchema_Checker(cx, &tc)
+		cx.bump()	// increment testno.
+	}
+}
+
+// ----- unit tests for mkIndexCmds()
+
+// inputs and outputs for one mkIndexCmds testcase.
+type mkIndexCmds_TC struct {
+	schema string
+	xcmds string
+	xsucc bool
+}
+
+// table of mkIndexCmds testcases.
+var mkIndexCmds_Tab = []mkIndexCmds_TC {
+	{`{"fields":[{"name":"a"},{"name":"b"}]}`, "", true},
+	{`{"fields":[{"name":"a"},{"name":"b"}],"indexes":[{"name":"i1","fields":["a"]},{"name":"i2","fields":["b","a"],"unique":true}]}`,
+		"create index `T__i1` on `T`(`a`);create unique index `T__i2` on `T`(`b`,`a`)",
+		true},
+	{`{"fields":[{"name":"a"}],"indexes":[{"name":"i1","fields":["a"]},{"name":"i1","fields":["a"]}]}`,
+		"", false},
+	{`{"fields":[{"name":"a"}],"indexes":[{"name":"i1","fields":["b"]}]}`,
+		"", false},
+	{`{"fields":[{"name":"a"}],"indexes":[{"name":"i1","fields":[]}]}`,
+		"", false},
+	{`{"fields":[{"name":"a"}],"indexes":[{"name":"bad name","fields":["a"]}]}`,
+		"", false},
+}
+
+// run one testcase for function mkIndexCmds.
+func mkIndexCmds_Checker(cx *testContext, tc *mkIndexCmds_TC) {
+	sch := TableSchema{}
+	err := json.Unmarshal([]byte(tc.schema), &sch)
+	if !cx.assertErrorNil(err, "json.Unmarshal") {
+		return
+	}
+	cmds, err := mkIndexCmds("T", sch)
+	if !cx.assertEqual(tc.xsucc, err == nil, "success") || err != nil {
+		return
+	}
+	strs := make([]string, len(cmds))
+	for i, xc := range cmds {
+		strs[i] = xc.cmd
+	}
+	cx.assertEqual(tc.xcmds, strings.Join(strs, ";"), "commands")
+}
+
+// the mkIndexCmds test suite.  run all mkIndexCmds testcases.
+func Test_mkIndexCmds(t *testing.T) {
+	cx := newTestContext(t, "mkIndexCmds_Tab")
+	for _, tc := range mkIndexCmds_Tab {
+		mkIndexCmds_Checker(cx, &tc)
+		cx.bump()	// increment testno.
+	}
+}
+
+// ----- unit tests for parseSchema()
+
+// inputs and outputs for one parseSchema testcase.
+type parseSchema_TC struct {
+	schema string
+	xsucc bool
+}
+
+// table of parseSchema testcases.
+var parseSchema_Tab = []parseSchema_TC {
+	{`{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"}]}`, true},
+	{`{"files":[{"name":"line"}]}`, false},
+	{`{"fields":[{"name":"a","tpye":"integer"}]}`, false},
+	{`{"fields":[{"name":"a"},{"name":"a"}]}`, false},
+	{`{"fields":[{"name":"a","db_type":"bogus"}]}`, false},
+	{`{"fields":[{"name":"a"}],"indexes":[{"name":"i1","fields":["b"]}]}`, false},
+	{`not json`, false},
+}
+
+// run one testcase for function parseSchema.
+func parseSchema_Checker(cx *testContext, tc *parseSchema_TC) {
+	_, err := parseSchema("T", tc.schema)
+	cx.assertEqual(tc.xsucc, err == nil, "success")
+}
+
+// the parseSchema test suite.  run all parseSchema testcases.
+func Test_parseSchema(t *testing.T) {
+	cx := newTestContext(t, "parseSchema_Tab")
+	for _, tc := range parseSchema_Tab {
+		parseSchema_Checker(cx, &tc)
+		cx.bump()	// increment testno.
+	}
+}
@@ -0,0 +1,82 @@
+package apidCRUD
+
+// this module implements the schema cache.  the schema of each table
+// that a request uses, from the table of tables, and its columns, from
+// PRAGMA table_info, are cached, so that the field names of a request
+// can be checked, and its values converted, without reading them from
+// the database every time.  a table's entry is dropped from the cache
+// whenever apidCRUD changes the table's schema.  a change made another
+// way, such as by another apid instance using the same database, is
+// picked up by describeDbTable with refresh=true.
+
+import (
+	"sync"
+)
+
+// cachedTable is what the schema cache holds for a table.
+// schErr tells why the stored schema is not usable, if it is not.
+// cols is empty if the table is in the table of tables,
+// but not in the database.
+type cachedTable struct {
+	sch TableSchema
+	schErr error
+	cols []columnInfo
+}
+
+// schemaCache is the schema cache.  gen is incremented whenever an
+// entry is dropped, so that an entry that was read before then is not
+// added after it.
+var schemaCache = struct {
+	mutex sync.Mutex
+	gen int64
+	tables map[string]*cachedTable
+}{tables: map[string]*cachedTable{}}
+
+// lookupTable() returns the cached information about the given table,
+// reading it from the database if it is not cached.  returns a 404
+// error if the table is not in the table of tables.  the information
+// is shared, and must not be changed.
+func lookupTable(db dbType, tabName string) (*cachedTable, error) {
+	schemaCache.mutex.Lock()
+	ct, ok := schemaCache.tables[tabName]
+	gen := schemaCache.gen
+	schemaCache.mutex.Unlock()
+	if ok {
+		return ct, nil
+	}
+	ct, err := readTable(db, tabName)
+	if err != nil {
+		return nil, err
+	}
+	schemaCache.mutex.Lock()
+	if schemaCache.gen == gen {
+		schemaCache.tables[tabName] = ct
+	}
+	schemaCache.mutex.Unlock()
+	return ct, nil
+}
+
+// readTable() reads the information about the given table
+// from the database.
+func readTable(db dbType, tabName string) (*cachedTable, error) {
+	jschema, err := getSchemaText(db, tabName)
+	if err != nil {
+		return nil, err
+	}
+	ct := &cachedTable{}
+	ct.sch, ct.schErr = parseSchema(tabName, jschema)
+	ct.cols, err = readColumnInfo(db, tabName)
+	if err != nil {
+		return nil, err
+	}
+	return ct, nil
+}
+
+// invalidateTable() drops the given table from the schema cache.
+// it is called after the table's schema is changed.
+func invalidateTable(tabName string) {
+	schemaCache.mutex.Lock()
+	defer schemaCache.mutex.Unlock()
+	schemaCache.gen++
+	delete(schemaCache.tables, tabName)
+}
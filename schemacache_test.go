@@ -0,0 +1,66 @@
+package apidCRUD
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// ----- unit tests for the schema cache
+
+// describeSchema() returns the schema returned by describeDbTable,
+// as json.
+func describeSchema(cx *testContext, desc string) string {
+	res := callApiHandler(describeDbTableHandler, http.MethodGet, desc)
+	cx.assertEqual(http.StatusOK, res.code, "describe " + desc)
+	resp, _ := res.data.(SchemaResponse)
+	jschema, _ := json.Marshal(resp.Schema)
+	return string(jschema)
+}
+
+func Test_schemaCache(t *testing.T) {
+	cx := newTestContext(t)
+	res := callApiHandler(createDbTableHandler, http.MethodPost,
+		`/test/db/_schema/SC|table_name=SC||{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"}]}`)
+	cx.assertEqual(http.StatusCreated, res.code, "create table SC")
+	defer callApiHandler(deleteDbTableHandler, http.MethodDelete,
+		`/test/db/_schema/SC|table_name=SC`)
+	desc := `/test/db/_schema/SC|table_name=SC`
+	orig := `{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"}]}`
+	cx.assertEqual(orig, describeSchema(cx, desc), "schema")
+
+	// an alteration is seen at once.
+	res = callApiHandler(alterDbTableHandler, http.MethodPatch,
+		`/test/db/_schema/SC|table_name=SC||{"add":[{"name":"n","db_type":"integer","allow_null":true}]}`)
+	cx.assertEqual(http.StatusOK, res.code, "alter table SC")
+	altered := `{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"},{"name":"n","db_type":"integer","allow_null":true}]}`
+	cx.assertEqual(altered, describeSchema(cx, desc), "altered schema")
+	cols, err := tableColumns(db, "SC")
+	cx.assertErrorNil(err, "tableColumns")
+	cx.assertEqualObj([]string{"id", "name", "n"}, cols, "altered columns")
+
+	// a change made behind apidCRUD's back is seen after a refresh.
+	_, err = db.handle.Exec(
+		"update _tables_ set schema = ? where name = ?", orig, "SC")
+	cx.assertErrorNil(err, "update _tables_")
+	cx.assertEqual(altered, describeSchema(cx, desc), "cached schema")
+	cx.assertEqual(orig, describeSchema(cx, desc + "|refresh=true"),
+		"refreshed schema")
+	cx.assertEqual(orig, describeSchema(cx, desc), "schema after refresh")
+}
+
+func Test_lookupTable(t *testing.T) {
+	cx := newTestContext(t)
+	_, err := lookupTable(db, "NOSUCH")
+	cx.assertEqual(http.StatusNotFound, dbErrorStatus(err), "missing table")
+	ct, err := lookupTable(db, "bundles")
+	if cx.assertErrorNil(err, "lookupTable bundles") {
+		cx.assertTrue(ct.schErr != nil, "unusable schema")
+		cx.assertEqual(3, len(ct.cols), "number of columns")
+	}
+	ct2, _ := lookupTable(db, "bundles")
+	cx.assertTrue(ct == ct2, "cached entry")
+	invalidateTable("bundles")
+	ct2, _ = lookupTable(db, "bundles")
+	cx.assertTrue(ct != ct2, "entry after invalidation")
+}
@@ -0,0 +1,136 @@
+package apidCRUD
+
+// this module implements soft deletion.  a table whose schema has
+// soft_delete set has a deleted_at field, and deleting a record sets
+// that field to the time of the deletion, instead of removing the
+// record.  the records that have been deleted in this way are hidden
+// from the other APIs unless include_deleted is given, and can be
+// restored, or purged, which removes them for real.
+
+import (
+	"fmt"
+	"time"
+)
+
+// softDeleteField is the name of the field that holds the time
+// at which a record was soft deleted, or NULL.
+const softDeleteField = "deleted_at"
+
+// applySoftDelete() returns the given schema, with the deleted_at
+// field added if the schema has soft_delete set.  if the schema already
+// has a field with that name, it must allow null.
+func applySoftDelete(sch TableSchema) (TableSchema, error) {
+	if !sch.SoftDelete {
+		return sch, nil
+	}
+	i := fieldIndex(sch, softDeleteField)
+	if i < 0 {
+		sch.Fields = append(append([]FieldSchema{}, sch.Fields...),
+			FieldSchema{Name: softDeleteField, DbType: "datetime",
+				AllowNull: boolPtr(true)})
+		return sch, nil
+	}
+	if !boolOpt(sch.Fields[i].AllowNull) {
+		return sch, fmt.Errorf("field %s must allow null", softDeleteField)
+	}
+	return sch, nil
+}
+
+// boolPtr() returns a pointer to the given value, for the optional
+// boolean properties of a FieldSchema.
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// softDeleteCond() returns the condition that hides soft-deleted
+// records, or "" if they are not to be hidden.
+func softDeleteCond(params map[string]string) string {
+	if params["soft_delete"] != "true" || params["include_deleted"] == "true" {
+		return ""
+	}
+	return quoteIdent(softDeleteField) + " IS NULL"
+}
+
+// andSoftDelete() adds the condition that hides soft-deleted records,
+// if any, to the given WHERE clause (which may be empty).
+func andSoftDelete(where string, params map[string]string) string {
+	cond := softDeleteCond(params)
+	if cond == "" {
+		return where
+	}
+	return andWhere(where, cond)
+}
+
+// mkSoftDeleteString() returns the command that soft deletes
+// the records that the given WHERE clause selects.  the first
+// value bound to the command must be the time of the deletion.
+func mkSoftDeleteString(params map[string]string, where string) string {
+	return newSQL("UPDATE ").ident(params["table_name"]).
+		sql(" SET ").ident(softDeleteField).sql(" = ?").
+		clause(andSoftDelete(where, params), nil).String()
+}
+
+// restoreRecs() restores the soft-deleted records selected by the
+// id, ids, and filter parameters.  it returns the number of records
+// restored.  the change is recorded.
+func restoreRecs(db dbType, params map[string]string) (idType, error) {
+	if params["soft_delete"] != "true" {
+		return dbErrorRet(fmt.Errorf("table %s does not have soft_delete",
+			params["table_name"]))
+	}
+	idclause, idlist := mkIdClause(params)
+	where, args, err := mkFilterClause(params, idclause, idlist)
+	if err != nil {
+		return dbErrorRet(err)
+	}
+	if where == "" {
+		return dbErrorRet(
+			fmt.Errorf("restore must specify id, ids, or filter"))
+	}
+	where = andWhere(where, quoteIdent(softDeleteField) + " IS NOT NULL")
+	qstring := newSQL("UPDATE ").ident(params["table_name"]).
+		sql(" SET ").ident(softDeleteField).sql(" = NULL").
+		clause(where, nil).String()
+	return changeExec(db, params, "restore", where, args, qstring, args)
+}
+
+// purgeRecs() permanently removes the records that were soft deleted
+// at least the given duration before the given time.  it returns the
+// number of records removed.  the change is recorded.
+func purgeRecs(db dbType,
+	params map[string]string,
+	now time.Time,
+	age time.Duration) (idType, error) {
+	if params["soft_delete"] != "true" {
+		return dbErrorRet(fmt.Errorf("table %s does not have soft_delete",
+			params["table_name"]))
+	}
+	where := "WHERE " + quoteIdent(softDeleteField) + " <= ?"
+	args := []interface{}{dbTime(now.Add(-age))}
+	qstring := newSQL("DELETE FROM ").ident(params["table_name"]).
+		clause(where, nil).String()
+	return changeExec(db, params, "purge", where, args, qstring, args)
+}
+
+// changeExec() runs the given command, which changes the records
+// that the given WHERE clause selects, and records the change with
+// the given operation.  it returns the number of records changed.
+func changeExec(db dbType,
+	params map[string]string,
+	op string,
+	where string,
+	wargs []interface{},
+	qstring string,
+	args []interface{}) (idType, error) {
+	ids, err := selectIds(db, params, where, wargs)
+	if err != nil {
+		return dbErrorRet(err)
+	}
+	var nc idType
+	err = recordChange(db, params, op, ids, func() ([]int64, error) {
+		exres, err := runExec(db, qstring, args)
+		nc = exres.rowsAffected
+		return nil, err
+	})
+	return nc, err
+}
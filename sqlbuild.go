@@ -0,0 +1,105 @@
+package apidCRUD
+
+// this module implements the building of SQL statements.  the text of
+// a statement is only ever written by apidCRUD: the names of tables,
+// fields, and indexes that come from a request are quoted as
+// identifiers, and the values that come from a request are bound to
+// placeholders, so that no request can change the structure of
+// a statement.
+
+import (
+	"bytes"
+	"strings"
+)
+
+// quoteIdent() returns the given name quoted as an SQL identifier.
+// the name is quoted with grave accents, and a grave accent in it is
+// doubled.  sqlite takes a name in double quotes that is not the name
+// of a column to be a string literal, which would hide an unknown
+// field; a name in grave accents is always an identifier.
+func quoteIdent(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+// quoteIdents() returns the given names, each quoted as an SQL
+// identifier, separated by commas.
+func quoteIdents(names []string) string {
+	ret := make([]string, len(names))
+	for i, name := range names {
+		ret[i] = quoteIdent(name)
+	}
+	return strings.Join(ret, ",")
+}
+
+// quoteFields() returns the list of field names given by a fields
+// parameter, quoted, or "*" for all the fields.
+func quoteFields(fields string) string {
+	if fields == "" || fields == "*" {
+		return "*"
+	}
+	return quoteIdents(strings.Split(fields, ","))
+}
+
+// sqlBuilder accumulates the text of an SQL statement, and the values
+// to be bound to its placeholders.
+type sqlBuilder struct {
+	buf bytes.Buffer
+	args []interface{}
+}
+
+// newSQL() returns a builder for a statement that starts with the
+// given SQL text.
+func newSQL(text string) *sqlBuilder {
+	b := &sqlBuilder{args: []interface{}{}}
+	return b.sql(text)
+}
+
+// sql() appends SQL text, which must not come from a request.
+func (b *sqlBuilder) sql(text string) *sqlBuilder {
+	b.buf.WriteString(text)
+	return b
+}
+
+// ident() appends a quoted identifier.
+func (b *sqlBuilder) ident(name string) *sqlBuilder {
+	return b.sql(quoteIdent(name))
+}
+
+// idents() appends a list of quoted identifiers, separated by commas.
+func (b *sqlBuilder) idents(names []string) *sqlBuilder {
+	return b.sql(quoteIdents(names))
+}
+
+// value() appends a placeholder for the given value.
+func (b *sqlBuilder) value(v interface{}) *sqlBuilder {
+	b.args = append(b.args, v)
+	return b.sql("?")
+}
+
+// values() appends placeholders for the given values,
+// separated by commas.
+func (b *sqlBuilder) values(vals []interface{}) *sqlBuilder {
+	b.args = append(b.args, vals...)
+	return b.sql(nstring("?", len(vals)))
+}
+
+// clause() appends a clause, such as a WHERE clause, that was built
+// with quoted identifiers and placeholders, and the values to be
+// bound to it.  an empty clause is skipped.
+func (b *sqlBuilder) clause(text string, args []interface{}) *sqlBuilder {
+	if text == "" {
+		return b
+	}
+	b.args = append(b.args, args...)
+	return b.sql(" " + text)
+}
+
+// String() returns the text of the statement.
+func (b *sqlBuilder) String() string {
+	return b.buf.String()
+}
+
+// xcmd() returns the statement as an xCmd, for execN().
+func (b *sqlBuilder) xcmd() *xCmd {
+	return newXCmd(b.String(), b.args...)
+}
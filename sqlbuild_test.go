@@ -0,0 +1,193 @@
+package apidCRUD
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// ----- unit tests for quoteIdent()
+
+var quoteIdent_Tab = []validator_TC {
+	{ "a", "`a`", true },
+	{ "deleted_at", "`deleted_at`", true },
+	{ "a b", "`a b`", true },
+	{ "a`b", "`a``b`", true },
+	{ `a"b`, "`a\"b`", true },
+	{ "", "``", true },
+}
+
+func Test_quoteIdent(t *testing.T) {
+	cx := newTestContext(t, "quoteIdent_Tab")
+	run_validator(cx,
+		func(s string) (string, error) {
+			return quoteIdent(s), nil
+		},
+		quoteIdent_Tab)
+}
+
+// ----- unit tests for sqlBuilder
+
+func Test_sqlBuilder(t *testing.T) {
+	cx := newTestContext(t)
+	q := newSQL("UPDATE ").ident("T").
+		sql(" SET (").idents([]string{"a", "b"}).
+		sql(") = (").values([]interface{}{1, "x"}).sql(")").
+		clause("WHERE `id` = ?", []interface{}{7}).
+		clause("", []interface{}{8})
+	cx.assertEqual("UPDATE `T` SET (`a`,`b`) = (?,?) WHERE `id` = ?",
+		q.String(), "statement")
+	cx.assertEqualObj([]interface{}{1, "x", 7}, q.args, "args")
+	cx.assertEqual("*", quoteFields("*"), "all fields")
+	cx.assertEqual("`a`,`b`", quoteFields("a,b"), "some fields")
+}
+
+// ----- fuzz tests of statement structure
+
+// identPattern matches a quoted identifier.
+var identPattern = regexp.MustCompile("`(?:[^`]|``)*`")
+
+// sqlTokenPattern matches a token of a statement built by apidCRUD,
+// other than a quoted identifier.
+var sqlTokenPattern = regexp.MustCompile(`[A-Za-z]+|[<>=!]+|\S`)
+
+// sqlWords are the words, in upper case, that statements built by
+// apidCRUD may contain outside of quoted identifiers.
+var sqlWords = listToMap([]string{
+	"SELECT", "FROM", "WHERE", "AND", "OR", "NOT", "IN", "IS", "NULL",
+	"LIKE", "ORDER", "GROUP", "BY", "ASC", "DESC", "LIMIT", "OFFSET",
+	"COUNT", "SUM", "MIN", "MAX", "AVG",
+})
+
+// checkStructure() checks that the given statement consists only of
+// quoted identifiers, SQL words, operators, punctuation, and
+// placeholders, one for each of the given values.
+func checkStructure(t *testing.T, qstring string, args []interface{}) {
+	rest := identPattern.ReplaceAllString(qstring, " ")
+	nplaces := 0
+	for _, tok := range sqlTokenPattern.FindAllString(rest, -1) {
+		switch {
+		case tok == "?":
+			nplaces++
+		case sqlWords[strings.ToUpper(tok)] != 0:
+		case strings.Trim(tok, "<>=!") == "":
+		case strings.Contains("(),*", tok):
+		default:
+			t.Fatalf("token %q in statement %s", tok, qstring)
+		}
+	}
+	if nplaces != len(args) {
+		t.Fatalf("%d placeholders and %d values in statement %s",
+			nplaces, len(args), qstring)
+	}
+}
+
+// validParams() returns the given parameters after validation,
+// and false if any of them is not valid.
+func validParams(vals map[string]string) (map[string]string, bool) {
+	params := map[string]string{}
+	for name, val := range vals {
+		v, err := validators[name](val)
+		if err != nil {
+			return nil, false
+		}
+		params[name] = v
+	}
+	return params, true
+}
+
+// a name in quotes is a single identifier, whatever the name.
+func Fuzz_quoteIdent(f *testing.F) {
+	for _, s := range []string{"a", "a`b", "``", `a"b`, "a; drop table x",
+		"a` FROM xxx; --", "'", "[a]"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, name string) {
+		// sqlite stops reading a statement at a NUL.
+		if strings.ContainsRune(name, 0) || !utf8.ValidString(name) {
+			t.Skip()
+		}
+		rows, err := db.handle.Query("SELECT 1 AS " + quoteIdent(name) +
+			", 2 AS two")
+		if err != nil {
+			t.Fatalf("query w/ %q: %s", name, err)
+		}
+		defer rows.Close() // nolint
+		cols, err := rows.Columns()
+		if err != nil || len(cols) != 2 || cols[0] != name {
+			t.Fatalf("columns w/ %q: %v, %v", name, cols, err)
+		}
+	})
+}
+
+// no request parameters can change the structure of a selection.
+func Fuzz_mkSelectString(f *testing.F) {
+	f.Add("T", "a,b", "a > 1 OR b LIKE 'x%'", "a DESC", "id", "1,2")
+	f.Add("T", "", "a = 'x'' OR 1=1 --'", "", "key", "")
+	f.Add("T`; drop table x", "a`,b", "a IN (1,'2')", "b", "id", "3")
+	f.Fuzz(func(t *testing.T, table, fields, filter, order, idField,
+		ids string) {
+		params, ok := validParams(map[string]string{
+			"table_name": table, "fields": fields, "filter": filter,
+			"order": order, "id_field": idField, "ids": ids,
+			"limit": "", "offset": ""})
+		if !ok {
+			t.Skip()
+		}
+		qstring, args, err := mkSelectString(params)
+		if err == nil {
+			checkStructure(t, qstring, args)
+		}
+		qstring, args, err = mkCountString(params)
+		if err == nil {
+			checkStructure(t, qstring, args)
+		}
+	})
+}
+
+// no request parameters can change the structure of an aggregation.
+func Fuzz_mkAggregateString(f *testing.F) {
+	f.Add("T", "count(*),sum(a)", "b,c", "a IS NOT NULL")
+	f.Add("T", "max(a)", "", "NOT (a = 1 AND b != '2')")
+	f.Add("T", "min(a) FROM x --)", "b", "")
+	f.Fuzz(func(t *testing.T, table, aggregate, groupBy, filter string) {
+		params, ok := validParams(map[string]string{
+			"table_name": table, "aggregate": aggregate,
+			"group_by": groupBy, "filter": filter,
+			"limit": "", "offset": ""})
+		if !ok {
+			t.Skip()
+		}
+		qstring, args, err := mkAggregateString(params)
+		if err == nil {
+			checkStructure(t, qstring, args)
+		}
+	})
+}
+
+// the values of a record are stored as they are, whatever they are.
+func Fuzz_runInsert(f *testing.F) {
+	for _, s := range []string{"x", "x'); drop table xxx; --", `"`, "?"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, value string) {
+		tx, err := db.handle.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback() // nolint
+		txdb := dbType{handle: db.handle, tx: tx}
+		id, err := runInsert(txdb, "xxx", []string{"name", "uri"},
+			[]interface{}{value, "u"})
+		if err != nil {
+			t.Fatalf("insert of %q: %s", value, err)
+		}
+		var got string
+		err = tx.QueryRow("SELECT name FROM xxx WHERE id = ?",
+			id).Scan(&got)
+		if err != nil || got != value {
+			t.Fatalf("insert of %q: got %q, %v", value, got, err)
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package apidCRUD
+
+// this module implements the description of a table that is returned
+// by describeDbTable, and by the APIs that change a table's schema.
+// the schema is the one stored in the table of tables.  the columns,
+// indexes, and number of rows are read from sqlite, so that they are
+// what the table actually has.
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// describeTable() returns the description of the given table.
+// self is the URL of the request.
+func describeTable(db dbType, tabName string, self string) (SchemaResponse, error) {
+	ret := SchemaResponse{Columns: []ColumnDescription{},
+		Indexes: []IndexDescription{}, Kind: "SchemaResponse", Self: self}
+	ct, err := lookupTable(db, tabName)
+	if err != nil {
+		return ret, err
+	}
+	if ct.schErr == nil {
+		sch := ct.sch
+		ret.Schema = &sch
+	}
+	cols, err := readColumnInfo(db, tabName)
+	if err != nil {
+		return ret, err
+	}
+	if len(cols) == 0 {
+		return ret, statusError{http.StatusNotFound,
+			fmt.Errorf("table %s is not in the database", tabName)}
+	}
+	for _, ci := range cols {
+		ret.Columns = append(ret.Columns, ColumnDescription{
+			Name: ci.name,
+			Type: ci.dtype,
+			AllowNull: !ci.notNull && ci.pk == 0,
+			IsPrimaryKey: ci.pk > 0,
+			Default: ci.dflt.String,
+		})
+	}
+	ret.Indexes, err = readIndexes(db, tabName)
+	if err != nil {
+		return ret, err
+	}
+	err = db.runner().QueryRow(newSQL("SELECT count(*) FROM ").
+		ident(tabName).String()).Scan(&ret.RowCount)
+	return ret, err
+}
+
+// readIndexes() reads the descriptions of the indexes of the given
+// table from the database, in order of name.
+func readIndexes(db dbType, tabName string) ([]IndexDescription, error) {
+	ret := []IndexDescription{}
+	rows, err := db.runner().Query("PRAGMA index_list(" +
+		quoteIdent(tabName) + ")")
+	if err != nil {
+		return ret, err
+	}
+	for rows.Next() {
+		var seq, unique, partial int
+		var idx IndexDescription
+		var origin string
+		err = rows.Scan(&seq, &idx.Name, &unique, &origin, &partial)
+		if err != nil {
+			rows.Close() // nolint
+			return ret, err
+		}
+		idx.Unique = unique != 0
+		ret = append(ret, idx)
+	}
+	rows.Close() // nolint
+	if err = rows.Err(); err != nil {
+		return ret, err
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	for i := range ret {
+		ret[i].Fields, err = readIndexFields(db, ret[i].Name)
+		if err != nil {
+			return ret, err
+		}
+	}
+	return ret, nil
+}
+
+// readIndexFields() reads the names of the fields of the given index
+// from the database, in order.  a field that is an expression has
+// no name.
+func readIndexFields(db dbType, indexName string) ([]string, error) {
+	ret := []string{}
+	rows, err := db.runner().Query("PRAGMA index_info(" +
+		quoteIdent(indexName) + ")")
+	if err != nil {
+		return ret, err
+	}
+	defer rows.Close() // nolint
+	for rows.Next() {
+		var seqno, cid int
+		var name sql.NullString
+		err = rows.Scan(&seqno, &cid, &name)
+		if err != nil {
+			return ret, err
+		}
+		ret = append(ret, name.String)
+	}
+	return ret, rows.Err()
+}
@@ -0,0 +1,215 @@
+package apidCRUD
+
+// this module implements the renaming and copying of tables.  each is
+// done in one transaction, which changes the table in sqlite and its
+// entry in the table of tables together, so that they cannot disagree.
+// the indexes of a table are named after it, and sqlite cannot rename
+// an index, so they are dropped and created again under the new name,
+// which takes time in proportion to the size of the table.  renaming a table also changes the references to
+// it in the schemas of other tables, as sqlite does in their SQL,
+// and moves its webhooks to the new name.  the audit log and the
+// change feed are history, and keep the old name.
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// renameTable() renames the given table to newName.
+func renameTable(db dbType, tabName string, newName string) error {
+	defer invalidateTable(tabName)
+	defer invalidateTable(newName)
+	jschema, err := getSchemaText(db, tabName)
+	if err != nil {
+		return err
+	}
+	// names are not case-sensitive, so a name that differs only in
+	// case is that of the table itself.
+	caseOnly := strings.EqualFold(tabName, newName)
+	if !caseOnly {
+		err = checkNameFree(db, newName)
+		if err != nil {
+			return err
+		}
+	}
+
+	// x1 renames the actual table.  sqlite refuses to rename a table
+	// to its own name in another case, so that is done in two steps.
+	cmds := []*xCmd{newSQL("alter table ").ident(tabName).
+		sql(" rename to ").ident(newName).xcmd()}
+	if caseOnly {
+		tmpName := "_rename_" + newName
+		cmds = []*xCmd{
+			newSQL("alter table ").ident(tabName).
+				sql(" rename to ").ident(tmpName).xcmd(),
+			newSQL("alter table ").ident(tmpName).
+				sql(" rename to ").ident(newName).xcmd(),
+		}
+	}
+
+	// the indexes of a table without a usable schema are not known,
+	// and are left alone.
+	sch, err := parseSchema(tabName, jschema)
+	if err == nil {
+		for _, idx := range sch.Indexes {
+			cmds = append(cmds, newSQL("drop index ").
+				ident(sqlIndexName(tabName, idx.Name)).xcmd(),
+				mkIndexCmd(newName, idx))
+		}
+	}
+
+	// this renames the table's entry in our internal table of tables.
+	cmds = append(cmds, newXCmd(fmt.Sprintf(
+		"update %s set name = ? where name = ?", tableOfTables),
+		newName, tabName))
+
+	rcmds, rnames, err := mkReferenceUpdateCmds(db, tabName, newName)
+	if err != nil {
+		return err
+	}
+	for _, name := range rnames {
+		defer invalidateTable(name)
+	}
+	cmds = append(cmds, rcmds...)
+
+	// this moves the table's webhooks.
+	cmds = append(cmds, newXCmd(fmt.Sprintf(
+		"update %s set table_name = ? where table_name = ?",
+		hooksTable), newName, tabName))
+	return execN(db, cmds...)
+}
+
+// mkReferenceUpdateCmds() returns the SQL commands that change the
+// references to the table tabName, in the schemas in the table of
+// tables, to newName, and the names of the tables whose schemas they
+// change.  the commands run after the table is renamed.  schemas that
+// are not usable are left alone.
+func mkReferenceUpdateCmds(db dbType,
+	tabName string,
+	newName string) ([]*xCmd, []string, error) {
+	entries, err := queryRegistry(db)
+	if err != nil {
+		return nil, nil, err
+	}
+	cmds := []*xCmd{}
+	names := []string{}
+	for _, e := range entries {
+		sch, err := parseSchema(e.Name, e.Schema)
+		if err != nil || !renameReferences(&sch, tabName, newName) {
+			continue
+		}
+		name := e.Name
+		if name == tabName {
+			name = newName
+		}
+		ucmd, err := mkSchemaUpdateCmd(name, sch)
+		if err != nil {
+			return nil, nil, err
+		}
+		cmds = append(cmds, ucmd)
+		names = append(names, e.Name)
+	}
+	return cmds, names, nil
+}
+
+// renameReferences() changes the references to the table tabName
+// in the given schema to newName.  returns true if any were changed.
+func renameReferences(sch *TableSchema, tabName string, newName string) bool {
+	changed := false
+	for i, field := range sch.Fields {
+		if field.References != nil && field.References.Table == tabName {
+			ref := *field.References
+			ref.Table = newName
+			sch.Fields[i].References = &ref
+			changed = true
+		}
+	}
+	return changed
+}
+
+// copyTable() creates the table newName, with the schema and indexes
+// of the given table, and a copy of its records if withData is true.
+// references of the table to itself become references of the copy
+// to itself.
+func copyTable(db dbType, tabName string, newName string, withData bool) error {
+	defer invalidateTable(newName)
+	sch, err := getTableSchema(db, tabName)
+	if err != nil {
+		return err
+	}
+	err = checkNameFree(db, newName)
+	if err != nil {
+		return err
+	}
+	renameReferences(&sch, tabName, newName)
+	jschema, err := schemaText(sch)
+	if err != nil {
+		return err
+	}
+	fieldStr, err := mkSchemaClause(sch)
+	if err != nil {
+		return err
+	}
+
+	// x1 creates the copy.
+	x1 := newSQL("create table ").ident(newName).
+		sql("(" + fieldStr + ")").xcmd()
+
+	// x2 puts the copy in our internal table of tables.
+	x2 := newXCmd(fmt.Sprintf("insert into %s (name,schema) values (?,?)",
+		tableOfTables), newName, jschema)
+	cmds := []*xCmd{x1, x2}
+
+	if withData {
+		fields := make([]string, len(sch.Fields))
+		for i, field := range sch.Fields {
+			fields[i] = field.Name
+		}
+		cmds = append(cmds, newSQL("insert into ").ident(newName).
+			sql("(").idents(fields).sql(") select ").idents(fields).
+			sql(" from ").ident(tabName).xcmd())
+	}
+
+	// the rest create the indexes, after the records are copied.
+	icmds, err := mkIndexCmds(newName, sch)
+	if err != nil {
+		return err
+	}
+	return execN(db, append(cmds, icmds...)...)
+}
+
+// checkNameFree() returns a 409 error if the given name is already
+// used by a table in the table of tables, or by any object in sqlite.
+func checkNameFree(db dbType, name string) error {
+	_, err := getSchemaText(db, name)
+	if err == nil {
+		return nameInUse(name)
+	}
+	if dbErrorStatus(err) != http.StatusNotFound {
+		return err
+	}
+	exists, err := objectExists(db, name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nameInUse(name)
+	}
+	return nil
+}
+
+// nameInUse() returns the 409 error for a name that is taken.
+func nameInUse(name string) error {
+	return statusError{http.StatusConflict,
+		fmt.Errorf("table %s already exists", name)}
+}
+
+// objectExists() returns true if sqlite has an object, such as a table
+// or an index, of the given name.  names are not case-sensitive.
+func objectExists(db dbType, name string) (bool, error) {
+	var n int
+	err := db.runner().QueryRow("SELECT count(*) FROM sqlite_master WHERE name = ? COLLATE NOCASE", // nolint
+		name).Scan(&n)
+	return n > 0, err
+}
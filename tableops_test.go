@@ -0,0 +1,212 @@
+package apidCRUD
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// ----- unit tests for renameDbTable and copyDbTable
+
+// a table, and a table that refers to it, for the tests.
+const (
+	ops_schema = `{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"}],"indexes":[{"name":"i1","fields":["name"],"unique":true}]}`
+	opsref_schema = `{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"op","db_type":"integer","allow_null":true,"references":{"table":"OPS","field":"id"}}]}`
+)
+
+var tableOps_Tab = []apiCall_TC {
+	{"setup: create table OPS",
+		createDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/OPS|table_name=OPS||`+ops_schema,
+		http.StatusCreated, noCheck},
+	{"setup: create table OPSREF",
+		createDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/OPSREF|table_name=OPSREF||`+opsref_schema,
+		http.StatusCreated, noCheck},
+	{"setup: create records in OPS",
+		createDbRecordsHandler,
+		http.MethodPost,
+		`/test/db/_table/OPS|table_name=OPS||{"records":[{"keys":["name"],"values":["a"]},{"keys":["name"],"values":["b"]}]}`,
+		http.StatusCreated, noCheck},
+	{"rename table w/ missing new_name",
+		renameDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/OPS/_rename|table_name=OPS`,
+		http.StatusBadRequest, noCheck},
+	{"rename table to reserved name",
+		renameDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/OPS/_rename|table_name=OPS|new_name=_tables_`,
+		http.StatusForbidden, noCheck},
+	{"rename missing table",
+		renameDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/NOSUCH/_rename|table_name=NOSUCH|new_name=OPS2`,
+		http.StatusNotFound, noCheck},
+	{"rename table to existing table",
+		renameDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/OPS/_rename|table_name=OPS|new_name=bundles`,
+		http.StatusConflict,
+		`{"code":409,"message":"table bundles already exists","kind":"ErrorResponse"}`},
+	{"rename table to name of an index",
+		renameDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/OPS/_rename|table_name=OPS|new_name=OPS__i1`,
+		http.StatusConflict, noCheck},
+	{"rename table OPS to OPS2",
+		renameDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/OPS/_rename|table_name=OPS|new_name=OPS2`,
+		http.StatusOK,
+		`{"schema":{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"}],"indexes":[{"name":"i1","fields":["name"],"unique":true}]},"columns":[{"name":"id","type":"INTEGER","allow_null":false,"is_primary_key":true},{"name":"name","type":"TEXT","allow_null":false,"is_primary_key":false}],"indexes":[{"name":"OPS2__i1","fields":["name"],"unique":true}],"rowCount":2,"kind":"SchemaResponse","self":"/test/db/_schema/OPS/_rename?new_name=OPS2"}`},
+	{"get records of old name",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`/test/db/_table/OPS|table_name=OPS`,
+		http.StatusNotFound, noCheck},
+	{"get records of new name",
+		getDbRecordsHandler,
+		http.MethodGet,
+		`/test/db/_table/OPS2|table_name=OPS2|fields=name`,
+		http.StatusOK, noCheck},
+	{"copy table w/ data",
+		copyDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/OPS2/_copy|table_name=OPS2|new_name=OPS3&with_data=true`,
+		http.StatusCreated,
+		`{"schema":{"fields":[{"name":"id","properties":["is_primary_key"]},{"name":"name"}],"indexes":[{"name":"i1","fields":["name"],"unique":true}]},"columns":[{"name":"id","type":"INTEGER","allow_null":false,"is_primary_key":true},{"name":"name","type":"TEXT","allow_null":false,"is_primary_key":false}],"indexes":[{"name":"OPS3__i1","fields":["name"],"unique":true}],"rowCount":2,"kind":"SchemaResponse","self":"/test/db/_schema/OPS2/_copy?new_name=OPS3\u0026with_data=true"}`},
+	{"copy table w/o data",
+		copyDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/OPS2/_copy|table_name=OPS2|new_name=OPS4`,
+		http.StatusCreated, noCheck},
+	{"copy table to existing table",
+		copyDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/OPS2/_copy|table_name=OPS2|new_name=OPS3`,
+		http.StatusConflict, noCheck},
+	{"copy table w/o usable schema",
+		copyDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/bundles/_copy|table_name=bundles|new_name=OPS5`,
+		http.StatusBadRequest, noCheck},
+	{"copy missing table",
+		copyDbTableHandler,
+		http.MethodPost,
+		`/test/db/_schema/NOSUCH/_copy|table_name=NOSUCH|new_name=OPS5`,
+		http.StatusNotFound, noCheck},
+}
+
+func Test_tableOps(t *testing.T) {
+	cx := newTestContext(t, "tableOps_Tab")
+	defer func() {
+		for _, tabName := range []string{"OPSREF", "OPS", "OPS2", "OPS3", "OPS4"} {
+			callApiHandler(deleteDbTableHandler, http.MethodDelete,
+				`/test/db/_schema/`+tabName+`|table_name=`+tabName)
+		}
+	}()
+	for _, tc := range tableOps_Tab {
+		apiCall_Checker(cx, &tc)
+		cx.bump()
+	}
+
+	// the copies have their own records.
+	res := callApiHandler(describeDbTableHandler, http.MethodGet,
+		`/test/db/_schema/OPS4|table_name=OPS4`)
+	resp, _ := res.data.(SchemaResponse)
+	cx.assertEqual(int64(0), resp.RowCount, "rows of copy w/o data")
+
+	// the reference to the renamed table follows it.
+	sch, err := getTableSchema(db, "OPSREF")
+	if cx.assertErrorNil(err, "getTableSchema OPSREF") {
+		cx.assertEqual("OPS2", sch.Fields[1].References.Table,
+			"references table")
+	}
+	var sqlText string
+	err = db.handle.QueryRow("SELECT sql FROM sqlite_master WHERE name = 'OPSREF'").
+		Scan(&sqlText)
+	if cx.assertErrorNil(err, "sql of OPSREF") {
+		cx.assertTrue(strings.Contains(sqlText, "OPS2"),
+			"references in sql: " + sqlText)
+	}
+}
+
+// ----- unit tests for renameReferences()
+
+func Test_renameReferences(t *testing.T) {
+	cx := newTestContext(t)
+	sch, err := parseSchema("T", opsref_schema)
+	if !cx.assertErrorNil(err, "parseSchema") {
+		return
+	}
+	cx.assertTrue(!renameReferences(&sch, "X", "Y"), "no references")
+	cx.assertTrue(renameReferences(&sch, "OPS", "Y"), "references")
+	cx.assertEqual("Y", sch.Fields[1].References.Table, "renamed")
+}
+
+// a table can be renamed to its own name in another case, and a rename
+// to the name of another table changes neither table.
+func Test_renameTableNames(t *testing.T) {
+	cx := newTestContext(t)
+	for _, tabName := range []string{"OPC", "OPX"} {
+		res := callApiHandler(createDbTableHandler, http.MethodPost,
+			`/test/db/_schema/`+tabName+`|table_name=`+tabName+`||`+ops_schema)
+		cx.assertEqual(http.StatusCreated, res.code, "create "+tabName)
+		res = callApiHandler(createDbRecordsHandler, http.MethodPost,
+			`/test/db/_table/`+tabName+`|table_name=`+tabName+
+			`||{"records":[{"keys":["name"],"values":["a"]}]}`)
+		cx.assertEqual(http.StatusCreated, res.code, "records of "+tabName)
+	}
+	defer func() {
+		for _, tabName := range []string{"opc", "OPC", "OPX"} {
+			callApiHandler(deleteDbTableHandler, http.MethodDelete,
+				`/test/db/_schema/`+tabName+`|table_name=`+tabName)
+		}
+	}()
+
+	// objects() returns the sqlite objects of the test tables.
+	objects := func() string {
+		rows, err := db.handle.Query("SELECT type, name, tbl_name FROM sqlite_master WHERE tbl_name IN ('OPC','opc','OPX') ORDER BY name")
+		if !cx.assertErrorNil(err, "query sqlite_master") {
+			return ""
+		}
+		defer rows.Close() // nolint
+		ret := []string{}
+		for rows.Next() {
+			var typ, name, tbl string
+			_ = rows.Scan(&typ, &name, &tbl)
+			ret = append(ret, typ+" "+name+" "+tbl)
+		}
+		return strings.Join(ret, ";")
+	}
+	before := objects()
+	cx.assertEqual("table OPC OPC;index OPC__i1 OPC;table OPX OPX;index OPX__i1 OPX",
+		before, "objects before")
+
+	res := callApiHandler(renameDbTableHandler, http.MethodPost,
+		`/test/db/_schema/OPC/_rename|table_name=OPC|new_name=OPX`)
+	cx.assertEqual(http.StatusConflict, res.code, "rename to other table")
+	res = callApiHandler(renameDbTableHandler, http.MethodPost,
+		`/test/db/_schema/OPC/_rename|table_name=OPC|new_name=opx`)
+	cx.assertEqual(http.StatusConflict, res.code,
+		"rename to other table in another case")
+	cx.assertEqual(before, objects(), "objects after failed renames")
+	for _, tabName := range []string{"OPC", "OPX"} {
+		res = callApiHandler(describeDbTableHandler, http.MethodGet,
+			`/test/db/_schema/`+tabName+`|table_name=`+tabName)
+		resp, _ := res.data.(SchemaResponse)
+		cx.assertEqual(int64(1), resp.RowCount, "rows of "+tabName)
+	}
+
+	res = callApiHandler(renameDbTableHandler, http.MethodPost,
+		`/test/db/_schema/OPC/_rename|table_name=OPC|new_name=opc`)
+	cx.assertEqual(http.StatusOK, res.code, "rename to another case")
+	cx.assertEqual("table OPX OPX;index OPX__i1 OPX;table opc opc;index opc__i1 opc",
+		objects(), "objects after rename to another case")
+	res = callApiHandler(getDbRecordsHandler, http.MethodGet,
+		`/test/db/_table/opc|table_name=opc|fields=name`)
+	cx.assertEqual(http.StatusOK, res.code, "get records of renamed table")
+}
@@ -97,6 +97,17 @@ total=$(get_nrecs)
 [[ "$total" == "$nc" ]]
 AssertOK "recstest.sh expected $total, got $nc"
 
+TestHeader "reading records by filter (filtest.sh)"
+out=$(Logrun "$TESTS_DIR/filtest.sh" "name = 'name3' OR id > 5" \
+	| jq -S '.records[].values[0]' | grep -c "")
+[[ "$out" == 3 ]]
+AssertOK "filtest.sh expected 3, got $out"
+
+TestHeader "reading records by cursor (curtest.sh)"
+out=$(Logrun "$TESTS_DIR/curtest.sh" 2 | grep -c "")
+[[ "$out" == "$total" ]]
+AssertOK "curtest.sh expected $total, got $out"
+
 TestHeader "deleting a record (deltest.sh)"
 nc=$(Logrun "$TESTS_DIR/deltest.sh" 7)
 [[ "$nc" == 1 ]]
@@ -133,6 +144,16 @@ uri2=$(get_rec_uri 6)
 [[ "$uri1" != "$uri2" ]]
 AssertOK "update did not change uri = $uri1"
 
+TestHeader "upserting records (upserttest.sh)"
+out=$(Logrun "$TESTS_DIR/upserttest.sh" 6)
+[[ "$(echo $out)" == "updated inserted" ]]
+AssertOK "upserttest.sh expected [updated inserted], got [$(echo $out)]"
+
+TestHeader "checking the upsert (get_rec_uri)"
+uri3=$(get_rec_uri 6)
+[[ "$uri3" == "uphost6" ]]
+AssertOK "upsert did not change uri = $uri3"
+
 TestHeader "try writing a small file and reading it back (rwftest.sh)"
 "$TESTS_DIR/rwftest.sh" cmd/apidCRUD/main.go > /dev/null 2>&1
 AssertOK file comparison
@@ -143,6 +164,16 @@ out=$(list_tables | grep -c '^[XYZ]$')
 [[ "$out" == 3 ]]
 AssertOK "tables creation"
 
+TestHeader "trying table alteration (alttabtest.sh)"
+out=$(Logrun "$TESTS_DIR/alttabtest.sh" X | jq -r .schema | jq -r '.fields[].name')
+[[ "$(echo $out)" == "id uri name note" ]]
+AssertOK "table alteration, got fields $out"
+
+TestHeader "trying index creation (idxtest.sh)"
+out=$(Logrun "$TESTS_DIR/idxtest.sh" Y | jq -r '.indexes[].name')
+[[ "$out" == "by_name" ]]
+AssertOK "index creation, got indexes $out"
+
 TestHeader "trying table deletion (deltabtest.sh)"
 out=$(Logrun "$TESTS_DIR/deltabtest.sh" X Y Z)
 out=$(list_tables | grep '^$[XYZ]$')
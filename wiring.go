@@ -22,10 +22,19 @@ type apiHandlerRet struct {
 }
 
 // apiHandlerArg is the type of the parameter to an apiHandler function.
+// header holds the headers that the handler sets on the response.
 type apiHandlerArg struct {
 	req *http.Request
 	pathParams map[string]string
 	err error
+	header http.Header
+}
+
+// streamer is implemented by the data of a response that is written
+// as it is produced, such as a stream of server-sent events,
+// rather than being converted all at once.
+type streamer interface {
+	stream(w http.ResponseWriter)
 }
 
 // apiHandler is the type an API handler function.
@@ -119,6 +128,15 @@ func pathDispatch(vmap verbMap, w http.ResponseWriter, harg *apiHandlerArg) {
 		w.Header().Set("Allowed",
 			strings.Join(allowedMethods(vmap), ","))
 	}
+	for name, vals := range harg.header {
+		w.Header()[name] = vals
+	}
+
+	if s, ok := res.data.(streamer); ok {
+		w.WriteHeader(res.code)
+		s.stream(w)
+		return
+	}
 
 	rawdata, err := convData(res.data)
 	if err != nil {
@@ -173,6 +191,11 @@ func (harg *apiHandlerArg) getBody() io.ReadCloser {
 	return harg.req.Body
 }
 
+// setHeader() sets a header of the response.
+func (harg *apiHandlerArg) setHeader(name string, value string) {
+	harg.header.Set(name, value)
+}
+
 // bodyClose() is a wrapper for http.Request.Body.Close()
 func (harg *apiHandlerArg) bodyClose() error {
 	return harg.req.Body.Close()
@@ -183,5 +206,5 @@ func (harg *apiHandlerArg) bodyClose() error {
 func mkApiHandlerArg(req *http.Request,
 		pathParams map[string]string) *apiHandlerArg {
 	err := req.ParseForm()
-	return &apiHandlerArg{req, pathParams, err}
+	return &apiHandlerArg{req, pathParams, err, http.Header{}}
 }
@@ -28,8 +28,9 @@ func abcPostHandler(harg *apiHandlerArg) apiHandlerRet {
 	return apiHandlerRet{abcPostRet, ""}
 }
 
-// a dummy handler
+// a dummy handler, sets a response header.
 func pqrPatchHandler(harg *apiHandlerArg) apiHandlerRet {
+	harg.setHeader("X-Pqr", "pqr")
 	return apiHandlerRet{http.StatusOK, ""}
 }
 
@@ -148,6 +149,17 @@ func Test_pathDispatch(t *testing.T) {
 	}
 }
 
+// pathDispatch() should copy the headers set by the handler.
+func Test_pathDispatch_header(t *testing.T) {
+	cx := newTestContext(t)
+	ws := newApiWiring("", fakeApiTable)
+	w := httptest.NewRecorder()
+	pathDispatch(ws.pathsMap["/pqr"], w,
+		parseHandlerArg(http.MethodPatch, "/pqr"))
+	cx.assertEqual(http.StatusOK, w.Code, "returned code")
+	cx.assertEqual("pqr", w.Header().Get("X-Pqr"), "response header")
+}
+
 // ----- unit tests for convData()
 
 type convData_TC struct {